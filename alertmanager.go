@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"regexp"
 
 	"github.com/go-openapi/strfmt"
 	"github.com/prometheus/alertmanager/api/v2/models"
@@ -26,11 +27,15 @@ type AlertmanagerAPI interface {
 	GetSilenceWithID(uuid string) (models.GettableSilence, error)
 	ListSilences() (models.GettableSilences, error)
 	ExpireSilenceWithID(uuid string) error
+	PostAlerts(alerts models.PostableAlerts) error
 }
 
 // AlertmanagerClient is the concrete implementation of the client object for methods calling the Alertmanager API
 type AlertmanagerClient struct {
 	AlertManagerAPIURL string
+	// ExcludedAlertName alert the silences created never select, so the maintenance alerts are not muted
+	// by their own maintenance
+	ExcludedAlertName string
 }
 
 func (ac *AlertmanagerClient) constructURL(pairs ...string) (string, error) {
@@ -126,7 +131,8 @@ func constructSilence(start, end string, request APISilenceRequest) (models.Sile
 	silence.EndsAt = &endDatetime
 
 	silence.CreatedBy = &request.CreatedBy
-	silence.Comment = &request.Comment
+	comment := request.silenceComment()
+	silence.Comment = &comment
 
 	var matchers models.Matchers
 	for _, m := range request.Matchers {
@@ -156,6 +162,9 @@ func (ac *AlertmanagerClient) CreateSilenceWith(start, end string, request APISi
 	if err != nil {
 		return "", err
 	}
+	if ac.ExcludedAlertName != "" {
+		silence.Matchers = append(silence.Matchers, excludingMatcher(ac.ExcludedAlertName))
+	}
 
 	var b = new(bytes.Buffer)
 	err = json.NewEncoder(b).Encode(silence)
@@ -212,6 +221,7 @@ func (ac *AlertmanagerClient) GetSilenceWithID(uuid string) (models.GettableSile
 	if err != nil {
 		return silence, fmt.Errorf("unable to unmarshal body: %s", err.Error())
 	}
+	ac.hideExcluding(&silence)
 	return silence, nil
 }
 
@@ -233,6 +243,9 @@ func (ac *AlertmanagerClient) ListSilences() (models.GettableSilences, error) {
 	if err != nil {
 		return silences, fmt.Errorf("unable to unmarshal body: %s", err.Error())
 	}
+	for _, s := range silences {
+		ac.hideExcluding(s)
+	}
 	return silences, nil
 }
 
@@ -250,6 +263,62 @@ func (ac *AlertmanagerClient) ExpireSilenceWithID(uuid string) error {
 	return nil
 }
 
+// PostAlerts sends alerts to Alertmanager, or refreshes them if already sent
+func (ac *AlertmanagerClient) PostAlerts(alerts models.PostableAlerts) error {
+	url, err := ac.constructURL("alerts")
+	if err != nil {
+		return err
+	}
+
+	var b = new(bytes.Buffer)
+	err = json.NewEncoder(b).Encode(alerts)
+	if err != nil {
+		return fmt.Errorf("unable to encode request body: %s", err.Error())
+	}
+
+	_, err = ac.doRequest("POST", url, b)
+	if err != nil {
+		return fmt.Errorf("unable to create HTTP request: %s", err.Error())
+	}
+	return nil
+}
+
+// notEqualRegex returns a regex matching every value but the given one, as the API of Alertmanager has no
+// negative matcher: the value is either a prefix of it, or differs at some character, or continues past it
+func notEqualRegex(value string) string {
+	re := ".+"
+	runes := []rune(value)
+	for i := len(runes) - 1; i >= 0; i-- {
+		c := regexp.QuoteMeta(string(runes[i]))
+		re = "(?:|[^" + c + "].*|" + c + re + ")"
+	}
+	return re
+}
+
+// excludingMatcher returns the matcher equivalent to alertname!="<name>"
+func excludingMatcher(name string) *models.Matcher {
+	label, value, isRegex := "alertname", notEqualRegex(name), true
+	return &models.Matcher{Name: &label, Value: &value, IsRegex: &isRegex}
+}
+
+// hideExcluding removes the matcher added by the client from the silence, so the silence can be compared
+// with the request it was created from
+func (ac *AlertmanagerClient) hideExcluding(s *models.GettableSilence) {
+	if s == nil || ac.ExcludedAlertName == "" {
+		return
+	}
+	excluding := excludingMatcher(ac.ExcludedAlertName)
+	var matchers models.Matchers
+	for _, m := range s.Matchers {
+		if m != nil && m.Name != nil && m.Value != nil && m.IsRegex != nil &&
+			*m.Name == *excluding.Name && *m.Value == *excluding.Value && *m.IsRegex {
+			continue
+		}
+		matchers = append(matchers, m)
+	}
+	s.Matchers = matchers
+}
+
 // NewAlertManagerClient creates a client to work with
 func NewAlertManagerClient(apiURL string) *AlertmanagerClient {
 	u := apiURL + "/" + apiVersion
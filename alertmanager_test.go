@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -315,6 +316,39 @@ func TestAlertmanagerClient_expireSilenceWithID_AlreadyExpired(t *testing.T) {
 	}
 }
 
+func TestAlertmanagerClient_postAlerts(t *testing.T) {
+	var got models.PostableAlerts
+	resourcesHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v2/alerts" {
+			w.WriteHeader(404)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}
+	// create test server with handler
+	ts := httptest.NewServer(http.HandlerFunc(resourcesHandler))
+	defer ts.Close()
+
+	startsAt, _ := strfmt.ParseDateTime("2019-11-01T22:00:00.000Z")
+	endsAt, _ := strfmt.ParseDateTime("2019-11-01T23:00:00.000Z")
+	want := models.PostableAlerts{
+		{
+			StartsAt: startsAt,
+			EndsAt:   endsAt,
+			Alert:    models.Alert{Labels: models.LabelSet{"alertname": "MaintenanceInProgress", "job": "FakeApp"}},
+		},
+	}
+
+	ac := NewAlertManagerClient(ts.URL)
+	err := ac.PostAlerts(want)
+	if err != nil {
+		t.Errorf("unexpected error received: '%s'", err.Error())
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Labels, want[0].Labels) {
+		t.Errorf("PostAlerts() didn't send expected alerts\ngot: '%v'\nwant: '%v'\n", got, want)
+	}
+}
+
 // helps testing promoted fields
 type Status struct {
 	State string `json:"state"`
@@ -352,3 +386,66 @@ func TestFilterExpired(t *testing.T) {
 		}
 	}
 }
+
+func TestNotEqualRegex(t *testing.T) {
+	var cases = []struct {
+		excluded string
+		value    string
+		want     bool
+	}{
+		{"MaintenanceInProgress", "MaintenanceInProgress", false},
+		{"MaintenanceInProgress", "", true},
+		{"MaintenanceInProgress", "Maintenance", true},
+		{"MaintenanceInProgress", "MaintenanceInProgress2", true},
+		{"MaintenanceInProgress", "MaintenanceInProgresS", true},
+		{"MaintenanceInProgress", "HighCPU", true},
+		// special characters are matched literally
+		{"a.b", "a.b", false},
+		{"a.b", "axb", true},
+		{"^]-", "^]-", false},
+		{"^]-", "^]", true},
+	}
+
+	for _, c := range cases {
+		m := Matcher{Name: "alertname", Value: notEqualRegex(c.excluded), IsRegex: true}
+		if got := m.accepts(c.value); got != c.want {
+			t.Errorf("wrong match of '%s' excluding '%s': got '%v' want '%v'", c.value, c.excluded, got, c.want)
+		}
+	}
+}
+
+func TestAlertmanagerClient_excludedAlertName(t *testing.T) {
+	var posted models.PostableSilence
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			json.NewDecoder(r.Body).Decode(&posted)
+			w.Write([]byte(`{"silenceID":"s1"}`))
+			return
+		}
+		id := "s1"
+		b, _ := json.Marshal(models.GettableSilences{{ID: &id, Silence: posted.Silence}})
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	ac := NewAlertManagerClient(ts.URL)
+	ac.ExcludedAlertName = defaultMaintenanceAlertName
+	request := APISilenceRequest{CreatedBy: "alice", Comment: "patching", Matchers: []Matcher{{Name: "team", Value: "db"}}}
+	_, err := ac.CreateSilenceWith("2019-11-01T22:12:33.533Z", "2019-11-01T23:11:44.603Z", request)
+	if err != nil {
+		t.Fatalf("unexpected error received: '%s'", err.Error())
+	}
+
+	// the silence never selects the maintenance alert
+	matchers := matchersFromModel(posted.Matchers)
+	if len(matchers) != 2 || selectsAlert(matchers, models.LabelSet{"alertname": defaultMaintenanceAlertName, "team": "db"}) ||
+		!selectsAlert(matchers, models.LabelSet{"alertname": "HighCPU", "team": "db"}) {
+		t.Errorf("unexpected matchers: '%v'", matchers)
+	}
+
+	// but reads back as requested
+	silences, err := ac.ListSilences()
+	if err != nil || len(silences) != 1 || !sameMatchers(matchersFromModel(silences[0].Matchers), request.Matchers) {
+		t.Errorf("unexpected silences: '%v' '%v'", silences, err)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,17 +8,23 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
 	configFile    = kingpin.Flag("config.file", "Path to config file.").Short('c').Default("config/config.yml").String()
 	listenAddress = kingpin.Flag("web.listen-address", "Address for the application to listen on").Default("8080").Short('p').Int()
+	enableReload  = kingpin.Flag("web.enable-lifecycle", "Enable config reloads via HTTP request.").Bool()
+	serveCmd      = kingpin.Command("serve", "Run the scheduler (default).").Default()
 	genericError  = 1
 
 	requestScheduleReg = regexp.MustCompile(`^(h|d|w)?$`)
@@ -36,8 +41,73 @@ const (
 
 // App receiver for app methods
 type App struct {
+	mu     sync.RWMutex
 	config *Config
 	client AlertmanagerAPI
+	auth   *authenticator
+
+	storeOnce sync.Once
+	store     *Store
+
+	auditOnce sync.Once
+	auditor   *AuditLog
+
+	templateOnce         sync.Once
+	maintenanceTemplates *TemplateStore
+
+	// postedAlerts maintenance alerts posted to Alertmanager by the last push, by occurrence
+	alertsMu     sync.Mutex
+	postedAlerts map[string]*models.PostableAlert
+
+	submitLocks keyLocks
+
+	// managedWindows requests of the valid maintenances of the last reconcile, by reconciler and managed name
+	managedMu      sync.Mutex
+	managedWindows map[string]map[string]APISilenceRequest
+}
+
+// conf returns the config currently in use, safe for use during a reload
+func (a *App) conf() *Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.config
+}
+
+// amClient returns the Alertmanager client currently in use, safe for use during a reload
+func (a *App) amClient() AlertmanagerAPI {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.client
+}
+
+// maintenanceStore returns the store of maintenances, in memory unless one was provided
+func (a *App) maintenanceStore() *Store {
+	a.storeOnce.Do(func() {
+		if a.store == nil {
+			a.store = newMemoryStore()
+		}
+	})
+	return a.store
+}
+
+// auditLog returns the audit log, in memory unless one was provided
+func (a *App) auditLog() *AuditLog {
+	a.auditOnce.Do(func() {
+		if a.auditor == nil {
+			a.auditor = newMemoryAuditLog()
+		}
+	})
+	return a.auditor
+}
+
+// templateStore returns the store of maintenance templates, in memory unless one was provided
+func (a *App) templateStore() *TemplateStore {
+	a.templateOnce.Do(func() {
+		if a.maintenanceTemplates == nil {
+			a.maintenanceTemplates = newMemoryTemplateStore()
+		}
+	})
+	return a.maintenanceTemplates
 }
 
 // APIResponse classical response of the API
@@ -47,13 +117,46 @@ type APIResponse struct {
 }
 
 func writeError(msg string, w http.ResponseWriter) {
+	writeErrorWithCode(msg, http.StatusInternalServerError, w)
+}
+
+func writeErrorWithCode(msg string, code int, w http.ResponseWriter) {
 	resp := APIResponse{Status: errorStatus, Message: msg}
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(resp)
 }
 
+func isJSONBody(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// isAPIClient returns true if the request comes from an API client rather than the web UI
+func isAPIClient(r *http.Request) bool {
+	if id, ok := identityFromContext(r.Context()); ok && id.Token {
+		return true
+	}
+	return isJSONBody(r) || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// replyForm reports the outcome of a submitted request, as a flash message and a redirect
+// for the web UI, or as a JSON response for API clients
+func replyForm(w http.ResponseWriter, r *http.Request, redirect string, code int, msg string) {
+	status, flash := "success", "success"
+	if code >= http.StatusBadRequest {
+		status, flash = errorStatus, "danger"
+	}
+
+	if isAPIClient(r) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(APIResponse{Status: status, Message: msg})
+		return
+	}
+	sessionAddFlash(w, r, flash, msg)
+	http.Redirect(w, r, redirect, 302)
+}
+
 func (a *App) getAlerts(w http.ResponseWriter, r *http.Request) {
-	alerts, err := a.client.ListAlerts()
+	alerts, err := a.amClient().ListAlerts()
 	if err != nil {
 		msg := fmt.Sprintf("unable to retrieve alerts: %s", err.Error())
 		writeError(msg, w)
@@ -65,11 +168,31 @@ func (a *App) getAlerts(w http.ResponseWriter, r *http.Request) {
 
 // APISilenceRequest request for silence
 type APISilenceRequest struct {
-	ID        string    `json:"id" schema:"ID"`
-	Comment   string    `json:"comment" schema:"Comment"`
-	CreatedBy string    `json:"createdBy" schema:"CreatedBy"`
-	Matchers  []Matcher `json:"matchers" schema:"Matchers"`
-	Schedule  Schedule  `json:"schedule" schema:"Schedule"`
+	ID            string    `json:"id" schema:"ID"`
+	Comment       string    `json:"comment" schema:"Comment"`
+	CreatedBy     string    `json:"createdBy" schema:"CreatedBy"`
+	Matchers      []Matcher `json:"matchers" schema:"Matchers"`
+	Schedule      Schedule  `json:"schedule" schema:"Schedule"`
+	Justification string    `json:"justification,omitempty" schema:"Justification"`
+	Ticket        string    `json:"ticket,omitempty" schema:"Ticket"`
+	// OnOverlap what to do if the request overlaps an existing window: reject, merge or create
+	OnOverlap string `json:"onOverlap,omitempty" schema:"OnOverlap"`
+
+	// Managed name of the declared maintenance the request comes from, never set by callers
+	Managed string `json:"-" schema:"-"`
+}
+
+// silenceComment returns the comment of the silences created for the request, referencing its ticket
+// and tagged with the declared maintenance managing them
+func (r APISilenceRequest) silenceComment() string {
+	comment := r.Comment
+	if r.Ticket != "" {
+		comment = fmt.Sprintf("[%s] %s", r.Ticket, comment)
+	}
+	if r.Managed != "" {
+		comment = fmt.Sprintf("%s %s%s]", comment, managedTagPrefix, r.Managed)
+	}
+	return comment
 }
 
 type Matcher struct {
@@ -83,6 +206,10 @@ type Schedule struct {
 	StartTime string `json:"start_time" schema:"StartTime"`
 	EndTime   string `json:"end_time" schema:"EndTime"`
 	Repeat    Repeat `json:"repeat" schema:"Repeat"`
+	// Exclude starts of the occurrences skipped, in the request time layout
+	Exclude []string `json:"exclude,omitempty" schema:"-"`
+	// Shift new starts of the occurrences moved off a holiday, by their original start
+	Shift map[string]string `json:"shift,omitempty" schema:"-"`
 }
 
 // Repeat structure
@@ -90,14 +217,27 @@ type Repeat struct {
 	Enabled  bool   `json:"enabled" schema:"-"`
 	Interval string `json:"interval" schema:"Interval"`
 	Count    int    `json:"count" schema:"Count"`
+	// Calendar name of the calendar telling business days and holidays
+	Calendar string `json:"calendar,omitempty" schema:"Calendar" yaml:"calendar"`
+	// BusinessDays skips the occurrences which do not land on a business day
+	BusinessDays bool `json:"businessDays,omitempty" schema:"BusinessDays" yaml:"business_days"`
+	// OnHoliday what happens to occurrences landing on a holiday: skip or shift to the next business day
+	OnHoliday string `json:"onHoliday,omitempty" schema:"OnHoliday" yaml:"on_holiday"`
 }
 
-// Valid validates a silence request
-func (r APISilenceRequest) Valid() (string, bool) {
+// ValidationRule an additional check of a silence request depending on the config or the caller
+type ValidationRule func(r APISilenceRequest) (string, bool)
+
+// Valid validates a silence request, then applies the additional rules
+func (r APISilenceRequest) Valid(rules ...ValidationRule) (string, bool) {
 	if r.Comment == "" {
 		return "comment field empty", false
 	}
 
+	if strings.Contains(r.Comment, managedTagPrefix) {
+		return fmt.Sprintf("comment field cannot contain '%s'", managedTagPrefix), false
+	}
+
 	if r.CreatedBy == "" {
 		return "createdBy field empty", false
 	}
@@ -121,9 +261,24 @@ func (r APISilenceRequest) Valid() (string, bool) {
 	if !ok {
 		return msg, false
 	}
+
+	for _, rule := range rules {
+		msg, ok = rule(r)
+		if !ok {
+			return msg, false
+		}
+	}
 	return "", true
 }
 
+func (m Matcher) String() string {
+	op := "="
+	if m.IsRegex {
+		op = "=~"
+	}
+	return fmt.Sprintf("%s%s%q", m.Name, op, m.Value)
+}
+
 func (m Matcher) Valid() bool {
 	if m.Name == "" {
 		return false
@@ -136,7 +291,7 @@ func (m Matcher) Valid() bool {
 
 // Valid returns true if the schedule is valid
 func (s Schedule) Valid() (string, bool) {
-	if s == (Schedule{}) {
+	if s.StartTime == "" && s.EndTime == "" && s.Repeat == (Repeat{}) {
 		return "empty schedule provided", false
 	}
 
@@ -169,6 +324,16 @@ func (r Repeat) Valid() (string, bool) {
 	if !requestScheduleReg.MatchString(r.Interval) {
 		return "unknown schedule interval provided", false
 	}
+
+	switch r.OnHoliday {
+	case "", holidaySkip:
+	case holidayShift:
+		if r.Interval == "h" {
+			return "only daily and weekly occurrences can be shifted off a holiday", false
+		}
+	default:
+		return fmt.Sprintf("invalid onHoliday '%s', expected skip or shift", r.OnHoliday), false
+	}
 	return "", true
 }
 
@@ -187,6 +352,61 @@ func addDuration(timestamp, interval string, count int) (string, error) {
 	return next.Format(requestTimeLayout), nil
 }
 
+// occurrence a single window of a repeated schedule
+type occurrence struct {
+	Start string
+	End   string
+}
+
+// occurrences expands the schedule into the windows it repeats
+func (s Schedule) occurrences() ([]occurrence, error) {
+	excluded := map[string]bool{}
+	for _, e := range s.Exclude {
+		excluded[e] = true
+	}
+
+	var out []occurrence
+	for i := 0; i < s.Repeat.Count; i++ {
+		start, err := addDuration(s.StartTime, s.Repeat.Interval, i)
+		if err != nil {
+			return nil, err
+		}
+		if excluded[start] {
+			continue
+		}
+
+		end, err := addDuration(s.EndTime, s.Repeat.Interval, i)
+		if err != nil {
+			return nil, err
+		}
+		if to, ok := s.Shift[start]; ok {
+			start, end, err = shiftOccurrence(start, end, to)
+			if err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, occurrence{Start: start, End: end})
+	}
+	return out, nil
+}
+
+// shiftOccurrence moves the occurrence to the new start, keeping its duration
+func shiftOccurrence(start, end, to string) (string, string, error) {
+	s, err := time.Parse(requestTimeLayout, start)
+	if err != nil {
+		return "", "", err
+	}
+	e, err := time.Parse(requestTimeLayout, end)
+	if err != nil {
+		return "", "", err
+	}
+	t, err := time.Parse(requestTimeLayout, to)
+	if err != nil {
+		return "", "", err
+	}
+	return to, t.Add(e.Sub(s)).Format(requestTimeLayout), nil
+}
+
 func reIndex(form map[string][]string) map[string][]string {
 	count := 0
 	o := map[string][]string{}
@@ -233,6 +453,28 @@ func reIndex(form map[string][]string) map[string][]string {
 	return o
 }
 
+// validationRules returns the rules applied to silence requests on top of the basic validation,
+// policy overrides are collected in the override log, to be recorded once the maintenance is created
+func (a *App) validationRules(r *http.Request, maintenanceID string, overrides *overrideLog) []ValidationRule {
+	ac := a.accessFor(r)
+	onOverride := func(req APISilenceRequest, violations []string) {
+		overrides.add(AuditEntry{
+			Action:        actionPolicyOverride,
+			Actor:         req.CreatedBy,
+			MaintenanceID: maintenanceID,
+			After:         auditState(req),
+			Justification: req.Justification,
+			Details:       strings.Join(violations, "; "),
+		})
+	}
+	return []ValidationRule{
+		ac.rule(),
+		a.conf().Policy.rule(ac, onOverride),
+		a.freezeRule(r, maintenanceID, overrides),
+		a.conf().Ticket.rule(),
+	}
+}
+
 func (a *App) createSilence(w http.ResponseWriter, r *http.Request) {
 	var silenceRequest APISilenceRequest
 	var decoder = schema.NewDecoder()
@@ -244,76 +486,223 @@ func (a *App) createSilence(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = r.ParseForm()
+	if !validCSRF(r) {
+		replyForm(w, r, url.String(), http.StatusForbidden, "invalid CSRF token")
+		return
+	}
+
+	if isJSONBody(r) {
+		err = json.NewDecoder(r.Body).Decode(&silenceRequest)
+		if err != nil {
+			msg := fmt.Sprintf("unable to read silence request: %s", err.Error())
+			replyForm(w, r, url.String(), http.StatusBadRequest, msg)
+			return
+		}
+	} else {
+		err = r.ParseForm()
+		if err != nil {
+			msg := fmt.Sprintf("unable to parse form: %s", err.Error())
+			replyForm(w, r, url.String(), http.StatusBadRequest, msg)
+			return
+		}
+
+		ordered := reIndex(r.PostForm)
+		delete(ordered, csrfFieldName)
+
+		err = decoder.Decode(&silenceRequest, ordered)
+		if err != nil {
+			msg := fmt.Sprintf("unable to read silence request: %s", err.Error())
+			replyForm(w, r, url.String(), http.StatusBadRequest, msg)
+			return
+		}
+	}
+
+	a.submitMaintenance(w, r, url.String(), silenceRequest)
+}
+
+// submitMaintenance validates a silence request then creates its silences, or keeps it for approval
+func (a *App) submitMaintenance(w http.ResponseWriter, r *http.Request, redirect string, silenceRequest APISilenceRequest) {
+	// the authenticated identity takes precedence over the free text creator
+	if id, ok := identityFromContext(r.Context()); ok {
+		silenceRequest.CreatedBy = id.Name
+	}
+	// occurrences are only shifted off the holidays of the calendar of the recurrence
+	silenceRequest.Schedule.Shift = nil
+	mode, err := a.conf().Overlap.mode(silenceRequest.OnOverlap)
 	if err != nil {
-		msg := fmt.Sprintf("unable to parse form: %s", err.Error())
-		sessionAddFlash(w, r, "danger", msg)
-		http.Redirect(w, r, url.String(), 302)
+		replyForm(w, r, redirect, http.StatusBadRequest, err.Error())
 		return
 	}
+	silenceRequest.OnOverlap = ""
 
-	ordered := reIndex(r.PostForm)
+	m := Maintenance{ID: newID(), Request: silenceRequest}
+	if key := r.Header.Get(idempotencyHeader); key != "" {
+		// submissions with the same key are serialized so that a retry is seen as one
+		unlock := a.submitLocks.lock(silenceRequest.CreatedBy + "\x00" + key)
+		defer unlock()
 
-	err = decoder.Decode(&silenceRequest, ordered)
+		m.Idempotency = &Idempotency{Key: key, Hash: requestHash(silenceRequest)}
+		previous, ok := a.maintenanceStore().FindByIdempotencyKey(key, silenceRequest.CreatedBy)
+		if ok && previous.Idempotency.Hash != m.Idempotency.Hash {
+			msg := fmt.Sprintf("idempotency key '%s' was already used for another request", key)
+			replyForm(w, r, redirect, http.StatusUnprocessableEntity, msg)
+			return
+		}
+		if ok {
+			code, msg := previous.Idempotency.replay(previous.ID)
+			replyForm(w, r, redirect, code, msg)
+			return
+		}
+	}
+	m.Request.ID = m.ID
+
+	// the days off are resolved once, the occurrences do not move if the holidays of the calendar change
+	silenceRequest, err = a.conf().applyCalendar(silenceRequest)
 	if err != nil {
-		msg := fmt.Sprintf("unable to read silence request: %s", err.Error())
-		sessionAddFlash(w, r, "danger", msg)
-		http.Redirect(w, r, url.String(), 302)
+		msg := fmt.Sprintf("silence request is invalid: %s", err.Error())
+		replyForm(w, r, redirect, http.StatusBadRequest, msg)
 		return
 	}
+	m.Request.Schedule = silenceRequest.Schedule
 
-	msg, ok := silenceRequest.Valid()
+	overrides := &overrideLog{}
+	rules := a.validationRules(r, m.ID, overrides)
+	msg, ok := silenceRequest.Valid(rules...)
 	if !ok {
 		msg = fmt.Sprintf("silence request is invalid: %s", msg)
-		sessionAddFlash(w, r, "danger", msg)
-		http.Redirect(w, r, url.String(), 302)
+		replyForm(w, r, redirect, http.StatusBadRequest, msg)
 		return
 	}
 
-	var requestErr = 0
-	for i := 0; i < silenceRequest.Schedule.Repeat.Count; i++ {
-		nextStart, err := addDuration(silenceRequest.Schedule.StartTime, silenceRequest.Schedule.Repeat.Interval, i)
+	msg, ok, err = a.conf().Ticket.check(silenceRequest.Ticket)
+	if err != nil {
+		replyForm(w, r, redirect, http.StatusBadGateway, err.Error())
+		return
+	}
+	if !ok {
+		replyForm(w, r, redirect, http.StatusBadRequest, msg)
+		return
+	}
+
+	silenceRequest, merged, msg, code := a.resolveOverlaps(r, silenceRequest, mode, rules, overrides)
+	if code != http.StatusOK {
+		replyForm(w, r, redirect, code, msg)
+		return
+	}
+	m.Request.Schedule = silenceRequest.Schedule
+
+	if a.conf().Approval.required(silenceRequest) {
+		if len(merged) != 0 {
+			msg = "a maintenance awaiting approval cannot be merged into overlapping silences"
+			replyForm(w, r, redirect, http.StatusConflict, msg)
+			return
+		}
+		m.State = statePending
+		m.Idempotency.record(http.StatusAccepted)
+		err = a.maintenanceStore().Add(m)
 		if err != nil {
-			log.Println(err)
-			requestErr++
-			continue
+			msg = fmt.Sprintf("unable to store maintenance: %s", err.Error())
+			replyForm(w, r, redirect, http.StatusInternalServerError, msg)
+			return
 		}
+		a.auditMaintenance(r, actionCreate, Maintenance{}, m)
+		a.recordOverrides(r, overrides, m.ID)
+		a.notify(eventCreated, m, nil)
+		msg = fmt.Sprintf("maintenance '%s' is awaiting approval", m.ID)
+		replyForm(w, r, redirect, http.StatusAccepted, msg)
+		return
+	}
 
-		nextEnd, err := addDuration(silenceRequest.Schedule.EndTime, silenceRequest.Schedule.Repeat.Interval, i)
-		if err != nil {
-			log.Println(err)
-			requestErr++
-			continue
+	silenceIDs, requestErr := a.createSilences(m.Request)
+	m.State = stateScheduled
+	m.SilenceIDs = silenceIDs
+	if requestErr != 0 {
+		m.Idempotency.record(http.StatusInternalServerError)
+	} else {
+		m.Idempotency.record(http.StatusOK)
+	}
+	err = a.maintenanceStore().Add(m)
+	if err != nil {
+		log.Printf("unable to store maintenance '%s': %s\n", m.ID, err.Error())
+	}
+	a.auditMaintenance(r, actionCreate, Maintenance{}, m)
+	a.recordOverrides(r, overrides, m.ID)
+	a.notify(eventCreated, m, nil)
+
+	msg = fmt.Sprintf("%d/%d new silences created", len(silenceIDs), len(silenceIDs)+requestErr)
+	if requestErr != 0 {
+		msg = fmt.Sprintf("'%d' request(s) could not be completed", requestErr)
+		replyForm(w, r, redirect, http.StatusInternalServerError, msg)
+		return
+	}
+	if len(merged) != 0 {
+		// the overlapping silences are only expired once the merged one exists
+		failed := a.expireMerged(r, merged)
+		msg = fmt.Sprintf("%s, %d overlapping silence(s) merged", msg, len(merged)-failed)
+		if failed != 0 {
+			a.maintenanceStore().Update(m.ID, func(m *Maintenance) error {
+				m.Idempotency.record(http.StatusInternalServerError)
+				return nil
+			})
+			msg = fmt.Sprintf("%s, '%d' overlapping silence(s) could not be expired", msg, failed)
+			replyForm(w, r, redirect, http.StatusInternalServerError, msg)
+			return
 		}
+	}
+	replyForm(w, r, redirect, http.StatusOK, msg)
+}
 
-		_, err = a.client.CreateSilenceWith(nextStart, nextEnd, silenceRequest)
+// createSilences creates a silence for each occurrence of the request schedule, it returns the IDs
+// of the created silences and the number of silences which could not be created
+func (a *App) createSilences(request APISilenceRequest) ([]string, int) {
+	occurrences, err := request.Schedule.occurrences()
+	if err != nil {
+		log.Println(err)
+		return nil, request.Schedule.Repeat.Count
+	}
+
+	var silenceIDs []string
+	var requestErr = 0
+	for _, o := range occurrences {
+		id, err := a.amClient().CreateSilenceWith(o.Start, o.End, request)
 		if err != nil {
 			log.Println(err)
 			requestErr++
 			continue
 		}
+		silenceIDs = append(silenceIDs, id)
 	}
-
-	msg = fmt.Sprintf("%d/%d new silences created", silenceRequest.Schedule.Repeat.Count-requestErr, silenceRequest.Schedule.Repeat.Count)
-	if requestErr != 0 {
-		msg = fmt.Sprintf("'%d' request(s) could not be completed", requestErr)
-		sessionAddFlash(w, r, "danger", msg)
-		http.Redirect(w, r, url.String(), 302)
-		return
-	}
-	sessionAddFlash(w, r, "success", msg)
-	http.Redirect(w, r, url.String(), 302)
+	return silenceIDs, requestErr
 }
 
 func (a *App) updateSilence(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
-	err := a.client.ExpireSilenceWithID(id)
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	msg, ok, err := a.canExpire(r, id)
+	if err != nil {
+		msg := fmt.Sprintf("unable to retrieve silence '%s': %s\n", id, err.Error())
+		writeError(msg, w)
+		return
+	}
+	if !ok {
+		writeErrorWithCode(msg, http.StatusForbidden, w)
+		return
+	}
+
+	before := a.silenceSnapshot(id)
+	err = a.amClient().ExpireSilenceWithID(id)
 	if err != nil {
 		msg := fmt.Sprintf("unable to expire silence '%s': %s\n", id, err.Error())
 		writeError(msg, w)
 		return
 	}
+	a.auditExpire(r, id, before)
+	a.markExpired(id, before)
 
 	url, err := mux.CurrentRoute(r).Subrouter().Get("indexHandler").URL()
 	if err != nil {
@@ -327,7 +716,7 @@ func (a *App) updateSilence(w http.ResponseWriter, r *http.Request) {
 func (a *App) getSilenceWithID(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
-	silence, err := a.client.GetSilenceWithID(id)
+	silence, err := a.amClient().GetSilenceWithID(id)
 	if err != nil {
 		msg := fmt.Sprintf("unable to retrieve silence from Alertmanager: %s\n", err.Error())
 		writeError(msg, w)
@@ -338,7 +727,7 @@ func (a *App) getSilenceWithID(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) getAllSilences(w http.ResponseWriter, r *http.Request) {
-	silences, err := a.client.ListSilences()
+	silences, err := a.amClient().ListSilences()
 	if err != nil {
 		msg := fmt.Sprintf("unable to retrieve silences: %s\n", err.Error())
 		writeError(msg, w)
@@ -361,12 +750,26 @@ func (a *App) getAllSilences(w http.ResponseWriter, r *http.Request) {
 func (a *App) expireSilence(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
-	err := a.client.ExpireSilenceWithID(id)
+	msg, ok, err := a.canExpire(r, id)
+	if err != nil {
+		msg := fmt.Sprintf("unable to retrieve silence '%s': %s\n", id, err.Error())
+		writeError(msg, w)
+		return
+	}
+	if !ok {
+		writeErrorWithCode(msg, http.StatusForbidden, w)
+		return
+	}
+
+	before := a.silenceSnapshot(id)
+	err = a.amClient().ExpireSilenceWithID(id)
 	if err != nil {
 		msg := fmt.Sprintf("unable to expire silence '%s': %s\n", id, err.Error())
 		writeError(msg, w)
 		return
 	}
+	a.auditExpire(r, id, before)
+	a.markExpired(id, before)
 	resp := APIResponse{
 		Status:  "success",
 		Message: fmt.Sprintf("expired silence with ID: %s", id),
@@ -378,7 +781,9 @@ func (a *App) expireSilence(w http.ResponseWriter, r *http.Request) {
 var templates *template.Template
 
 type basePage struct {
-	Flashes []interface{}
+	Flashes   []interface{}
+	User      *Identity
+	CSRFToken string
 }
 
 func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) error {
@@ -393,8 +798,19 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, err := csrfToken(w, r)
+	if err != nil {
+		msg := fmt.Sprintf("Internal error rendering page: %s", err.Error())
+		writeError(msg, w)
+		return
+	}
+
 	data := basePage{
-		Flashes: flashes,
+		Flashes:   flashes,
+		CSRFToken: token,
+	}
+	if id, ok := identityFromContext(r.Context()); ok {
+		data.User = id
 	}
 
 	err = renderTemplate(w, "layout.gohtml", data)
@@ -406,17 +822,42 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	kingpin.Parse()
+	command := kingpin.Parse()
+	if command != serveCmd.FullCommand() {
+		os.Exit(runClient(command))
+	}
 
-	appConf, err := loadConfig(*configFile)
+	application := &App{}
+	err := application.reloadConfig(*configFile)
 	if err != nil {
 		log.Printf("error loading config: %s\n", err.Error())
 		os.Exit(genericError)
 	}
-	application := App{
-		config: appConf,
-		client: NewAlertManagerClient(appConf.AlertmanagerURL),
+	go application.watchReloadSignal(*configFile)
+
+	application.store, err = NewStore(application.conf().Storage.Path)
+	if err != nil {
+		log.Printf("error loading maintenances: %s\n", err.Error())
+		os.Exit(genericError)
+	}
+	application.maintenanceTemplates, err = NewTemplateStore(application.conf().Storage.TemplatesPath)
+	if err != nil {
+		log.Printf("error loading maintenance templates: %s\n", err.Error())
+		os.Exit(genericError)
 	}
+	application.auditor, err = NewAuditLog(application.conf().Audit.Path)
+	if err != nil {
+		log.Printf("error loading audit log: %s\n", err.Error())
+		os.Exit(genericError)
+	}
+	go application.expirePendingLoop(time.Minute)
+	go application.watchDeclarations(declarativeWatchInterval)
+	go application.driftLoop()
+	go application.notifyLoop()
+	go application.grafanaLoop()
+	go application.maintenanceAlertsLoop()
+	go application.kubernetesLoop()
+	prometheus.MustRegister(&windowCollector{app: application, now: time.Now})
 
 	templates, err = template.ParseGlob("templates/*")
 	if err != nil {
@@ -427,19 +868,45 @@ func main() {
 	router = mux.NewRouter().StrictSlash(true)
 
 	s := router.PathPrefix("/api/v1/").Subrouter()
-	s.HandleFunc("/alerts", application.getAlerts).Methods("GET").Name("getAlerts")
-	s.HandleFunc("/silence", application.createSilence).Methods("POST").Name("createSilence")
-	s.HandleFunc("/silences", application.getAllSilences).Methods("GET").Name("getAllSilences")
-	s.HandleFunc("/silences_filtered", application.getAllSilences).Methods("GET").Name("getAllSilencesFiltered")
-	s.HandleFunc("/silence/{id}", application.getSilenceWithID).Methods("GET").Name("getSilence")
-	s.HandleFunc("/silence/{id}", application.updateSilence).Methods("POST").Name("updateSilence")
-	s.HandleFunc("/silence/{id}", application.expireSilence).Methods("DELETE").Name("expireSilence")
-
-	router.HandleFunc("/", indexHandler).Name("indexHandler")
+	s.Use(application.requireAuth)
+	s.HandleFunc("/alerts", requireScope(scopeRead, application.getAlerts)).Methods("GET").Name("getAlerts")
+	s.HandleFunc("/silence", requireScope(scopeCreate, application.createSilence)).Methods("POST").Name("createSilence")
+	s.HandleFunc("/silences", requireScope(scopeRead, application.getAllSilences)).Methods("GET").Name("getAllSilences")
+	s.HandleFunc("/silences_filtered", requireScope(scopeRead, application.getAllSilences)).Methods("GET").Name("getAllSilencesFiltered")
+	s.HandleFunc("/silence/{id}", requireScope(scopeRead, application.getSilenceWithID)).Methods("GET").Name("getSilence")
+	s.HandleFunc("/silence/{id}", requireScope(scopeExpire, application.updateSilence)).Methods("POST").Name("updateSilence")
+	s.HandleFunc("/silence/{id}", requireScope(scopeExpire, application.expireSilence)).Methods("DELETE").Name("expireSilence")
+	s.HandleFunc("/maintenances", requireScope(scopeRead, application.getMaintenances)).Methods("GET").Name("getMaintenances")
+	s.HandleFunc("/maintenances/export", requireScope(scopeRead, application.exportMaintenances)).Methods("GET").Name("exportMaintenances")
+	s.HandleFunc("/maintenances/import", requireScope(scopeCreate, application.importMaintenances)).Methods("POST").Name("importMaintenances")
+	s.HandleFunc("/maintenance/{id}", requireScope(scopeRead, application.getMaintenance)).Methods("GET").Name("getMaintenance")
+	s.HandleFunc("/maintenance/{id}/approve", requireScope(scopeCreate, application.approveMaintenance)).Methods("POST").Name("approveMaintenance")
+	s.HandleFunc("/maintenance/{id}/reject", requireScope(scopeCreate, application.rejectMaintenance)).Methods("POST").Name("rejectMaintenance")
+	s.HandleFunc("/templates", requireScope(scopeRead, application.getTemplates)).Methods("GET").Name("getTemplates")
+	s.HandleFunc("/templates", requireScope(scopeCreate, application.createTemplate)).Methods("POST").Name("createTemplate")
+	s.HandleFunc("/template/{name}", requireScope(scopeRead, application.getTemplate)).Methods("GET").Name("getTemplate")
+	s.HandleFunc("/template/{name}", requireScope(scopeCreate, application.updateTemplate)).Methods("PUT").Name("updateTemplate")
+	s.HandleFunc("/template/{name}", requireScope(scopeCreate, application.deleteTemplate)).Methods("DELETE").Name("deleteTemplate")
+	s.HandleFunc("/template/{name}/instantiate", requireScope(scopeCreate, application.instantiateTemplate)).Methods("POST").Name("instantiateTemplate")
+	s.HandleFunc("/calendar.ics", requireScope(scopeRead, application.getCalendar)).Methods("GET").Name("getCalendar")
+	s.HandleFunc("/calendar/import", requireScope(scopeCreate, application.importCalendar)).Methods("POST").Name("importCalendar")
+	s.HandleFunc("/drift", requireScope(scopeRead, application.getDrift)).Methods("GET").Name("getDrift")
+	s.HandleFunc("/drift/repair", requireScope(scopeCreate, application.repairDriftHandler)).Methods("POST").Name("repairDrift")
+	s.HandleFunc("/import/candidates", requireScope(scopeRead, application.getImportCandidates)).Methods("GET").Name("getImportCandidates")
+	s.HandleFunc("/import/candidates/{id}/adopt", requireScope(scopeCreate, application.adoptCandidate)).Methods("POST").Name("adoptCandidate")
+	s.HandleFunc("/audit", requireScope(scopeRead, application.getAuditLog)).Methods("GET").Name("getAuditLog")
+	s.HandleFunc("/audit/export", requireScope(scopeRead, application.exportAuditLog)).Methods("GET").Name("exportAuditLog")
+
+	if *enableReload {
+		router.HandleFunc("/-/reload", application.reloadHandler(*configFile)).Methods("POST").Name("reload")
+	}
+	router.Handle("/metrics", promhttp.Handler()).Name("metrics")
+	router.HandleFunc("/auth/login", application.login).Methods("GET").Name("login")
+	router.HandleFunc("/auth/callback", application.loginCallback).Methods("GET").Name("loginCallback")
+	router.HandleFunc("/auth/logout", application.logout).Methods("GET").Name("logout")
+	router.HandleFunc("/", application.requireLogin(indexHandler)).Name("indexHandler")
 	http.Handle("/", router)
 
-	gob.Register(&Flash{})
-
 	log.Printf("Starting server on port %d\n", *listenAddress)
 	err = http.ListenAndServe(fmt.Sprintf(":%d", *listenAddress), nil)
 	if err != nil {
@@ -45,6 +45,10 @@ func (m *MockAlertManagerClient) ExpireSilenceWithID(uuid string) error {
 	args := m.Called(uuid)
 	return args.Error(0)
 }
+func (m *MockAlertManagerClient) PostAlerts(alerts models.PostableAlerts) error {
+	args := m.Called(alerts)
+	return args.Error(0)
+}
 
 func Test_getAlerts(t *testing.T) {
 	client := MockAlertManagerClient{}
@@ -162,6 +166,7 @@ func TestApp_createSilence(t *testing.T) {
 		mock.AnythingOfType("string"),
 		mock.AnythingOfType("string"),
 		mock.AnythingOfType("APISilenceRequest")).Return("1234", nil)
+	client.On("ListSilences").Return(models.GettableSilences{}, nil)
 	app := App{
 		config: &Config{},
 		client: &client,
@@ -171,7 +176,10 @@ func TestApp_createSilence(t *testing.T) {
 	router = mux.NewRouter().StrictSlash(true)
 	router.HandleFunc("/", indexHandler).Name("indexHandler")
 
+	token, cookies := newCSRFSession(t)
+
 	form := url.Values{}
+	form.Add("csrf_token", token)
 	form.Add("Comment", "test")
 	form.Add("CreatedBy", "test")
 	form.Add("Matchers.0.Name", "job")
@@ -185,6 +193,9 @@ func TestApp_createSilence(t *testing.T) {
 	req := httptest.NewRequest("POST", "/webhook", bytes.NewBufferString(form.Encode()))
 	req.Form = form
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
 
 	// Create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response
 	rr := httptest.NewRecorder()
@@ -196,11 +207,14 @@ func TestApp_createSilence(t *testing.T) {
 	if status := rr.Code; status != http.StatusFound {
 		t.Errorf("wrong status code: got '%d' want '%d'", status, http.StatusFound)
 	}
+	client.AssertNumberOfCalls(t, "CreateSilenceWith", 1)
 }
 
 func TestApp_expireSilence(t *testing.T) {
 	client := MockAlertManagerClient{}
 
+	client.On("GetSilenceWithID",
+		mock.AnythingOfType("string")).Return(models.GettableSilence{}, nil)
 	client.On("ExpireSilenceWithID",
 		mock.AnythingOfType("string")).Return(nil)
 	app := App{
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ApprovalConfig maintenances which need a second person to approve them before any silence is created
+type ApprovalConfig struct {
+	// Scopes maintenances possibly silencing alerts with all the labels of one of these scopes need approval
+	Scopes    []LabelScope `yaml:"scopes"`
+	Approvers Approvers    `yaml:"approvers"`
+	// ExemptDeclarative lets declared maintenances within the scopes create their silences, for directories
+	// whose changes are already reviewed before being deployed
+	ExemptDeclarative bool `yaml:"exempt_declarative"`
+}
+
+// Approvers users and groups allowed to approve maintenances
+type Approvers struct {
+	Users  []string `yaml:"users"`
+	Groups []string `yaml:"groups"`
+}
+
+func (c ApprovalConfig) validate() error {
+	if len(c.Scopes) > 0 && len(c.Approvers.Users) == 0 && len(c.Approvers.Groups) == 0 {
+		return fmt.Errorf("approval needs at least one approver user or group")
+	}
+	for _, s := range c.Scopes {
+		if len(s) == 0 {
+			return fmt.Errorf("approval has an empty scope")
+		}
+	}
+	return nil
+}
+
+// required returns true if the request may silence alerts within one of the approval scopes
+func (c ApprovalConfig) required(r APISilenceRequest) bool {
+	for _, s := range c.Scopes {
+		if s.touchedBy(r.Matchers) {
+			return true
+		}
+	}
+	return false
+}
+
+// rule returns a validation rule rejecting the maintenances needing an approval, for the sources
+// which cannot go through the approval flow
+func (c ApprovalConfig) rule(source string) ValidationRule {
+	return func(r APISilenceRequest) (string, bool) {
+		if c.required(r) {
+			return fmt.Sprintf("maintenance needs an approval, which %s cannot go through", source), false
+		}
+		return "", true
+	}
+}
+
+// touchedBy returns true if alerts carrying all the labels of the scope can be matched,
+// that is if no matcher on one of the scope labels excludes the scope value
+func (s LabelScope) touchedBy(matchers []Matcher) bool {
+	for name, value := range s {
+		for _, m := range matchers {
+			if m.Name == name && !m.accepts(value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// accepts returns true if a label with the given value is matched
+func (m Matcher) accepts(value string) bool {
+	if !m.IsRegex {
+		return m.Value == value
+	}
+	reg, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		// an invalid regex matches nothing
+		return false
+	}
+	return reg.MatchString(value)
+}
+
+func (c ApprovalConfig) canApprove(id *Identity) bool {
+	return Role{Users: c.Approvers.Users, Groups: c.Approvers.Groups}.grantedTo(id)
+}
+
+// firstStart returns the start of the first occurrence of the maintenance
+func (m Maintenance) firstStart() (time.Time, error) {
+	return time.Parse(requestTimeLayout, m.Request.Schedule.StartTime)
+}
+
+// expirePending marks pending maintenances whose first occurrence already started as expired
+func (a *App) expirePending(now time.Time) {
+	for _, m := range a.maintenanceStore().List() {
+		if m.State != statePending {
+			continue
+		}
+		start, err := m.firstStart()
+		if err != nil || start.After(now) {
+			continue
+		}
+
+		updated, err := a.maintenanceStore().Update(m.ID, func(m *Maintenance) error {
+			if m.State != statePending {
+				return fmt.Errorf("maintenance '%s' is not pending anymore", m.ID)
+			}
+			m.State = stateExpired
+			return nil
+		})
+		if err != nil {
+			log.Printf("unable to expire pending maintenance '%s': %s\n", m.ID, err.Error())
+			continue
+		}
+		a.record(AuditEntry{
+			Time:          now.UTC(),
+			Action:        actionExpire,
+			Actor:         "system",
+			MaintenanceID: m.ID,
+			Before:        auditState(m),
+			After:         auditState(updated),
+			Details:       "not approved before its first occurrence",
+		})
+		log.Printf("pending maintenance '%s' expired without approval\n", m.ID)
+	}
+}
+
+// expirePendingLoop periodically expires pending maintenances which were not approved in time
+func (a *App) expirePendingLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		a.expirePending(time.Now())
+	}
+}
+
+func (a *App) getMaintenances(w http.ResponseWriter, r *http.Request) {
+	list := a.maintenanceStore().List()
+
+	state := r.URL.Query().Get("state")
+	if state != "" {
+		var filtered []Maintenance
+		for _, m := range list {
+			if m.State == state {
+				filtered = append(filtered, m)
+			}
+		}
+		list = filtered
+	}
+	if list == nil {
+		list = []Maintenance{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+}
+
+func (a *App) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	m, ok := a.maintenanceStore().Get(id)
+	if !ok {
+		writeErrorWithCode(fmt.Sprintf("maintenance '%s' not found", id), http.StatusNotFound, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(m)
+}
+
+// checkApprover returns an error message and status code if the caller cannot decide on the maintenance
+func (a *App) checkApprover(r *http.Request, id string) (Maintenance, *Identity, string, int) {
+	if !validCSRF(r) {
+		return Maintenance{}, nil, "invalid CSRF token", http.StatusForbidden
+	}
+
+	a.expirePending(time.Now())
+	m, ok := a.maintenanceStore().Get(id)
+	if !ok {
+		return m, nil, fmt.Sprintf("maintenance '%s' not found", id), http.StatusNotFound
+	}
+	if m.State != statePending {
+		return m, nil, fmt.Sprintf("maintenance '%s' is %s, not pending", id, m.State), http.StatusConflict
+	}
+
+	caller, ok := identityFromContext(r.Context())
+	if !ok || !a.conf().Approval.canApprove(caller) {
+		return m, nil, "you are not allowed to approve maintenances", http.StatusForbidden
+	}
+	if caller.Name == m.Request.CreatedBy {
+		return m, nil, "a maintenance cannot be approved by its creator", http.StatusForbidden
+	}
+	return m, caller, "", http.StatusOK
+}
+
+func (a *App) approveMaintenance(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	m, caller, msg, code := a.checkApprover(r, id)
+	if code != http.StatusOK {
+		writeErrorWithCode(msg, code, w)
+		return
+	}
+
+	// the ticket may have been cancelled since the maintenance was submitted
+	msg, ok, err := a.conf().Ticket.check(m.Request.Ticket)
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadGateway, w)
+		return
+	}
+	if !ok {
+		writeErrorWithCode(msg, http.StatusBadRequest, w)
+		return
+	}
+
+	// a freeze may have started since the maintenance was submitted, only an approver allowed to override it can approve
+	overrides := &overrideLog{}
+	msg, ok = a.freezeRule(r, id, overrides)(m.Request)
+	if !ok {
+		writeErrorWithCode(msg, http.StatusConflict, w)
+		return
+	}
+
+	// mark the maintenance approved first so a concurrent approval cannot create the silences twice
+	_, err = a.maintenanceStore().Update(id, func(m *Maintenance) error {
+		if m.State != statePending {
+			return fmt.Errorf("maintenance '%s' is %s, not pending", m.ID, m.State)
+		}
+		m.State = stateScheduled
+		m.ApprovedBy = caller.Name
+		return nil
+	})
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusConflict, w)
+		return
+	}
+
+	silenceIDs, requestErr := a.createSilences(m.Request)
+	updated, err := a.maintenanceStore().Update(id, func(m *Maintenance) error {
+		m.SilenceIDs = silenceIDs
+		return nil
+	})
+	if err != nil {
+		log.Printf("unable to store silences of maintenance '%s': %s\n", id, err.Error())
+	}
+	a.auditMaintenance(r, actionApprove, m, updated)
+	a.recordOverrides(r, overrides, id)
+	log.Printf("maintenance '%s' approved by '%s'\n", id, caller.Name)
+
+	if requestErr != 0 {
+		msg := fmt.Sprintf("maintenance approved but '%d' request(s) could not be completed", requestErr)
+		writeError(msg, w)
+		return
+	}
+	resp := APIResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("maintenance '%s' approved, %d new silences created", id, len(silenceIDs)),
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (a *App) rejectMaintenance(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	m, caller, msg, code := a.checkApprover(r, id)
+	if code != http.StatusOK {
+		writeErrorWithCode(msg, code, w)
+		return
+	}
+
+	updated, err := a.maintenanceStore().Update(id, func(m *Maintenance) error {
+		if m.State != statePending {
+			return fmt.Errorf("maintenance '%s' is %s, not pending", m.ID, m.State)
+		}
+		m.State = stateRejected
+		return nil
+	})
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusConflict, w)
+		return
+	}
+	log.Printf("maintenance '%s' rejected by '%s'\n", id, caller.Name)
+	a.auditMaintenance(r, actionReject, m, updated)
+
+	resp := APIResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("maintenance '%s' rejected", id),
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
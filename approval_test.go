@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/mock"
+)
+
+var testApproval = ApprovalConfig{
+	Scopes:    []LabelScope{{"env": "prod"}},
+	Approvers: Approvers{Groups: []string{"change-managers"}},
+}
+
+func TestApprovalConfig_required(t *testing.T) {
+	var cases = []struct {
+		matchers []Matcher
+		want     bool
+	}{
+		{[]Matcher{{Name: "env", Value: "prod"}}, true},
+		{[]Matcher{{Name: "env", Value: "prod|staging", IsRegex: true}}, true},
+		{[]Matcher{{Name: "team", Value: "payments"}}, true},
+		{[]Matcher{{Name: "env", Value: "staging"}}, false},
+		{[]Matcher{{Name: "env", Value: "dev.*", IsRegex: true}, {Name: "team", Value: "payments"}}, false},
+	}
+
+	for _, c := range cases {
+		if got := testApproval.required(APISilenceRequest{Matchers: c.matchers}); got != c.want {
+			t.Errorf("approval requirement not returning expected result for => '%v'", c.matchers)
+		}
+	}
+}
+
+func newPendingMaintenance(t *testing.T, app *App, start time.Time) Maintenance {
+	m := Maintenance{
+		ID:    newID(),
+		State: statePending,
+		Request: APISilenceRequest{
+			Comment:   "db patching",
+			CreatedBy: "alice",
+			Matchers:  []Matcher{{Name: "env", Value: "prod"}},
+			Schedule: Schedule{
+				StartTime: start.Format(requestTimeLayout),
+				EndTime:   start.Add(time.Hour).Format(requestTimeLayout),
+				Repeat:    Repeat{Interval: "d", Count: 2},
+			},
+		},
+	}
+	err := app.maintenanceStore().Add(m)
+	if err != nil {
+		t.Fatalf("unable to add maintenance: %s", err.Error())
+	}
+	return m
+}
+
+func TestApp_createSilence_pending(t *testing.T) {
+	client := MockAlertManagerClient{}
+	client.On("ListSilences").Return(models.GettableSilences{}, nil)
+	app := &App{
+		config: &Config{Approval: testApproval},
+		client: &client,
+	}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	token, cookies := newCSRFSession(t)
+	form := url.Values{}
+	form.Add("csrf_token", token)
+	form.Add("Comment", "db patching")
+	form.Add("CreatedBy", "alice")
+	form.Add("Matchers.0.Name", "env")
+	form.Add("Matchers.0.Value", "prod")
+	form.Add("Schedule.StartTime", "2020-11-01T22:00:00.000Z")
+	form.Add("Schedule.EndTime", "2020-11-01T23:00:00.000Z")
+	form.Add("Schedule.Repeat.Count", "1")
+	form.Add("Schedule.Repeat.Interval", "h")
+
+	req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBufferString(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("wrong status code: got '%d' want '%d', body: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+	client.AssertNotCalled(t, "CreateSilenceWith", mock.Anything, mock.Anything, mock.Anything)
+
+	list := app.maintenanceStore().List()
+	if len(list) != 1 || list[0].State != statePending || len(list[0].SilenceIDs) != 0 {
+		t.Errorf("expected a single pending maintenance without silences, got '%+v'", list)
+	}
+}
+
+func TestApp_approveMaintenance(t *testing.T) {
+	var cases = []struct {
+		name     string
+		caller   *Identity
+		start    time.Time
+		wantCode int
+		wantCall int
+	}{
+		{"approver", &Identity{Name: "bob", Groups: []string{"change-managers"}}, time.Now().Add(time.Hour), http.StatusOK, 2},
+		{"creator", &Identity{Name: "alice", Groups: []string{"change-managers"}}, time.Now().Add(time.Hour), http.StatusForbidden, 0},
+		{"not an approver", &Identity{Name: "carol"}, time.Now().Add(time.Hour), http.StatusForbidden, 0},
+		{"too late", &Identity{Name: "bob", Groups: []string{"change-managers"}}, time.Now().Add(-time.Minute), http.StatusConflict, 0},
+	}
+
+	for _, c := range cases {
+		client := MockAlertManagerClient{}
+		client.On("CreateSilenceWith",
+			mock.AnythingOfType("string"),
+			mock.AnythingOfType("string"),
+			mock.AnythingOfType("APISilenceRequest")).Return("1234", nil)
+		client.On("ListSilences").Return(models.GettableSilences{}, nil)
+		app := &App{
+			config: &Config{Approval: testApproval},
+			client: &client,
+		}
+		m := newPendingMaintenance(t, app, c.start)
+
+		req := httptest.NewRequest("POST", "/api/v1/maintenance/"+m.ID+"/approve", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": m.ID})
+		req = withIdentity(req, &Identity{Name: c.caller.Name, Groups: c.caller.Groups, Token: true})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.approveMaintenance).ServeHTTP(rr, req)
+
+		if rr.Code != c.wantCode {
+			t.Errorf("wrong status code for '%s': got '%d' want '%d', body: %s", c.name, rr.Code, c.wantCode, rr.Body.String())
+		}
+		client.AssertNumberOfCalls(t, "CreateSilenceWith", c.wantCall)
+
+		got, _ := app.maintenanceStore().Get(m.ID)
+		if c.wantCode == http.StatusOK && (got.State != stateScheduled || got.ApprovedBy != "bob" || len(got.SilenceIDs) != 2) {
+			t.Errorf("unexpected maintenance after approval: '%+v'", got)
+		}
+	}
+}
+
+func TestApp_expirePending(t *testing.T) {
+	app := &App{config: &Config{Approval: testApproval}}
+	past := newPendingMaintenance(t, app, time.Now().Add(-time.Minute))
+	future := newPendingMaintenance(t, app, time.Now().Add(time.Hour))
+
+	app.expirePending(time.Now())
+
+	if got, _ := app.maintenanceStore().Get(past.ID); got.State != stateExpired {
+		t.Errorf("maintenance which already started should be expired, got '%s'", got.State)
+	}
+	if got, _ := app.maintenanceStore().Get(future.ID); got.State != statePending {
+		t.Errorf("maintenance which did not start should still be pending, got '%s'", got.State)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+const (
+	actionCreate         = "create"
+	actionUpdate         = "update"
+	actionExpire         = "expire"
+	actionApprove        = "approve"
+	actionReject         = "reject"
+	actionPolicyOverride = "policy_override"
+	actionFreezeOverride = "freeze_override"
+	actionAdopt          = "adopt"
+	actionImport         = "import"
+	actionCancel         = "cancel"
+	actionDelete         = "delete"
+)
+
+// AuditConfig where the audit log is written
+type AuditConfig struct {
+	Path string `yaml:"path"`
+}
+
+// AuditEntry a single action performed on maintenances or silences
+type AuditEntry struct {
+	Time          time.Time       `json:"time"`
+	Action        string          `json:"action"`
+	Actor         string          `json:"actor"`
+	SourceIP      string          `json:"sourceIP"`
+	ForwardedFor  string          `json:"forwardedFor,omitempty"`
+	MaintenanceID string          `json:"maintenanceID,omitempty"`
+	SilenceIDs    []string        `json:"silenceIDs,omitempty"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after,omitempty"`
+	Justification string          `json:"justification,omitempty"`
+	Details       string          `json:"details,omitempty"`
+}
+
+// AuditFilter criteria entries must all match to be returned by a query
+type AuditFilter struct {
+	Actor         string
+	Action        string
+	MaintenanceID string
+	SilenceID     string
+	Since         time.Time
+	Until         time.Time
+}
+
+// AuditLog append-only log of actions, kept in memory and in a JSON lines file when a path is set
+type AuditLog struct {
+	mu      sync.RWMutex
+	path    string
+	entries []AuditEntry
+}
+
+func newMemoryAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// NewAuditLog creates an audit log appending to path, loading the entries it already contains
+func NewAuditLog(path string) (*AuditLog, error) {
+	l := &AuditLog{path: path}
+	if path == "" {
+		return l, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read audit log: %s", err.Error())
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e AuditEntry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal audit log entry: %s", err.Error())
+		}
+		l.entries = append(l.entries, e)
+	}
+	if scanner.Err() != nil {
+		return nil, fmt.Errorf("unable to read audit log: %s", scanner.Err().Error())
+	}
+	return l, nil
+}
+
+// Record appends an entry to the log
+func (l *AuditLog) Record(e AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.path != "" {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("unable to marshal audit log entry: %s", err.Error())
+		}
+
+		f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("unable to open audit log: %s", err.Error())
+		}
+		defer f.Close()
+
+		_, err = f.Write(append(b, '\n'))
+		if err != nil {
+			return fmt.Errorf("unable to write audit log: %s", err.Error())
+		}
+	}
+
+	l.entries = append(l.entries, e)
+	return nil
+}
+
+func (f AuditFilter) matches(e AuditEntry) bool {
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.MaintenanceID != "" && e.MaintenanceID != f.MaintenanceID {
+		return false
+	}
+	if f.SilenceID != "" {
+		found := false
+		for _, id := range e.SilenceIDs {
+			if id == f.SilenceID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns the entries matching the filter, oldest first
+func (l *AuditLog) Query(f AuditFilter) []AuditEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	out := []AuditEntry{}
+	for _, e := range l.entries {
+		if f.matches(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// auditState serializes a state recorded as before or after an action
+func auditState(v interface{}) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// actor returns the name of the caller of the request, or the fallback for anonymous callers
+func actor(r *http.Request, fallback string) string {
+	if id, ok := identityFromContext(r.Context()); ok {
+		return id.Name
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return "anonymous"
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// record adds an entry to the audit log, failures are only logged so that an unwritable
+// audit log never leaves an action half done
+func (a *App) record(e AuditEntry) {
+	err := a.auditLog().Record(e)
+	if err != nil {
+		log.Printf("unable to record '%s' by '%s' in audit log: %s\n", e.Action, e.Actor, err.Error())
+	}
+}
+
+// audit records an action performed by the caller of the request
+func (a *App) audit(r *http.Request, e AuditEntry) {
+	if e.Actor == "" {
+		e.Actor = actor(r, "")
+	}
+	e.SourceIP = sourceIP(r)
+	e.ForwardedFor = r.Header.Get("X-Forwarded-For")
+	a.record(e)
+}
+
+// overrideLog the policy overrides granted while validating a request, which are only recorded
+// once the maintenance they allowed is created
+type overrideLog struct {
+	entries []AuditEntry
+}
+
+func (o *overrideLog) add(e AuditEntry) {
+	o.entries = append(o.entries, e)
+}
+
+// reset forgets the overrides, before the request is validated again
+func (o *overrideLog) reset() {
+	o.entries = nil
+}
+
+// recordOverrides writes the overrides to the audit log against the created maintenance
+func (a *App) recordOverrides(r *http.Request, o *overrideLog, maintenanceID string) {
+	if o == nil {
+		return
+	}
+	for _, e := range o.entries {
+		e.MaintenanceID = maintenanceID
+		a.audit(r, e)
+	}
+	o.entries = nil
+}
+
+// auditMaintenance records a change of state of a maintenance, before is empty for new maintenances
+func (a *App) auditMaintenance(r *http.Request, action string, before, after Maintenance) {
+	e := AuditEntry{
+		Action:        action,
+		MaintenanceID: after.ID,
+		SilenceIDs:    after.SilenceIDs,
+		After:         auditState(after),
+		Justification: after.Request.Justification,
+	}
+	if before.ID != "" {
+		e.Before = auditState(before)
+	}
+	if action == actionCreate {
+		e.Actor = actor(r, after.Request.CreatedBy)
+	}
+	a.audit(r, e)
+}
+
+// silenceSnapshot returns the silence as it is before being changed, nil if it cannot be retrieved
+func (a *App) silenceSnapshot(id string) *models.GettableSilence {
+	silence, err := a.amClient().GetSilenceWithID(id)
+	if err != nil {
+		log.Printf("unable to retrieve silence '%s' for the audit log: %s\n", id, err.Error())
+		return nil
+	}
+	return &silence
+}
+
+// auditExpire records the expiration of a silence, along with the maintenance it belongs to
+func (a *App) auditExpire(r *http.Request, id string, before *models.GettableSilence) {
+	e := AuditEntry{
+		Action:     actionExpire,
+		SilenceIDs: []string{id},
+	}
+	if m, ok := a.maintenanceStore().FindBySilenceID(id); ok {
+		e.MaintenanceID = m.ID
+	}
+	if before != nil {
+		e.Before = auditState(before)
+
+		after := *before
+		state := models.SilenceStatusStateExpired
+		after.Status = &models.SilenceStatus{State: &state}
+		e.After = auditState(after)
+	}
+	a.audit(r, e)
+}
+
+func parseAuditFilter(r *http.Request) (AuditFilter, error) {
+	q := r.URL.Query()
+	f := AuditFilter{
+		Actor:         q.Get("actor"),
+		Action:        q.Get("action"),
+		MaintenanceID: q.Get("maintenance"),
+		SilenceID:     q.Get("silence"),
+	}
+
+	var err error
+	if s := q.Get("since"); s != "" {
+		f.Since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return f, fmt.Errorf("invalid since parameter: %s", err.Error())
+		}
+	}
+	if s := q.Get("until"); s != "" {
+		f.Until, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return f, fmt.Errorf("invalid until parameter: %s", err.Error())
+		}
+	}
+	return f, nil
+}
+
+func (a *App) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.auditLog().Query(f))
+}
+
+func (a *App) exportAuditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+
+	// json.Encoder writes one entry per line
+	enc := json.NewEncoder(w)
+	for _, e := range a.auditLog().Query(f) {
+		enc.Encode(e)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAuditLog_persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ams-audit")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	l, err := NewAuditLog(path)
+	if err != nil {
+		t.Fatalf("unable to create audit log: %s", err.Error())
+	}
+	entries := []AuditEntry{
+		{Action: actionCreate, Actor: "alice", MaintenanceID: "m1", SilenceIDs: []string{"s1", "s2"}},
+		{Action: actionExpire, Actor: "bob", MaintenanceID: "m1", SilenceIDs: []string{"s2"}},
+		{Action: actionCreate, Actor: "bob", MaintenanceID: "m2", SilenceIDs: []string{"s3"}},
+	}
+	for _, e := range entries {
+		err = l.Record(e)
+		if err != nil {
+			t.Fatalf("unable to record entry: %s", err.Error())
+		}
+	}
+
+	// entries are kept across restarts
+	l, err = NewAuditLog(path)
+	if err != nil {
+		t.Fatalf("unable to reload audit log: %s", err.Error())
+	}
+
+	var cases = []struct {
+		filter AuditFilter
+		want   int
+	}{
+		{AuditFilter{}, 3},
+		{AuditFilter{Actor: "bob"}, 2},
+		{AuditFilter{Action: actionCreate}, 2},
+		{AuditFilter{MaintenanceID: "m1"}, 2},
+		{AuditFilter{SilenceID: "s2"}, 2},
+		{AuditFilter{Actor: "bob", Action: actionCreate}, 1},
+		{AuditFilter{Since: time.Now().Add(time.Hour)}, 0},
+		{AuditFilter{Until: time.Now().Add(-time.Hour)}, 0},
+	}
+	for _, c := range cases {
+		if got := l.Query(c.filter); len(got) != c.want {
+			t.Errorf("unexpected entries for filter '%+v'\ngot: %d\nwant: %d", c.filter, len(got), c.want)
+		}
+	}
+
+	// the file is only ever appended to, one entry per line
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unable to open audit log: %s", err.Error())
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	if lines != len(entries) {
+		t.Errorf("wrong number of lines in audit log: got '%d' want '%d'", lines, len(entries))
+	}
+}
+
+func TestApp_createSilence_auditPolicyOverride(t *testing.T) {
+	client := MockAlertManagerClient{}
+	client.On("CreateSilenceWith",
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("APISilenceRequest")).Return("1234", nil)
+	client.On("ListSilences").Return(models.GettableSilences{}, nil)
+	app := App{
+		config: &Config{RBAC: testRBAC, Policy: newTestPolicy(t)},
+		client: &client,
+	}
+
+	router = mux.NewRouter()
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	body, _ := json.Marshal(APISilenceRequest{
+		Comment:       "emergency",
+		Matchers:      []Matcher{{Name: "severity", Value: ".+", IsRegex: true}},
+		Justification: "incident 42",
+		Schedule: Schedule{
+			StartTime: "2021-10-12T12:00:00.000Z",
+			EndTime:   "2021-10-12T13:00:00.000Z",
+			Repeat:    Repeat{Interval: "h", Count: 1},
+		},
+	})
+	req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.RemoteAddr = "192.0.2.1:51234"
+	req = withIdentity(req, &Identity{Name: "carol", Groups: []string{"sre"}, Token: true})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status code: got '%d' want '%d', body: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	overrides := app.auditLog().Query(AuditFilter{Action: actionPolicyOverride})
+	created := app.auditLog().Query(AuditFilter{Action: actionCreate})
+	if len(overrides) != 1 || len(created) != 1 {
+		t.Fatalf("expected one override and one creation, got '%+v'", app.auditLog().Query(AuditFilter{}))
+	}
+
+	o, c := overrides[0], created[0]
+	if o.Actor != "carol" || o.Justification != "incident 42" || o.Details == "" {
+		t.Errorf("unexpected override entry: '%+v'", o)
+	}
+	if o.SourceIP != "192.0.2.1" || o.ForwardedFor != "203.0.113.7" {
+		t.Errorf("unexpected source of override entry: '%s' '%s'", o.SourceIP, o.ForwardedFor)
+	}
+	if o.MaintenanceID == "" || o.MaintenanceID != c.MaintenanceID {
+		t.Errorf("override should be linked to the created maintenance: '%s' '%s'", o.MaintenanceID, c.MaintenanceID)
+	}
+	if c.Actor != "carol" || len(c.SilenceIDs) != 1 || c.SilenceIDs[0] != "1234" || c.Before != nil || c.After == nil {
+		t.Errorf("unexpected creation entry: '%+v'", c)
+	}
+}
+
+func TestApp_createSilence_auditPolicyOverrideFailed(t *testing.T) {
+	client := MockAlertManagerClient{}
+	client.On("ListSilences").Return(models.GettableSilences{}, fmt.Errorf("connection refused"))
+	app := App{
+		// the lookup of overlapping windows fails once the policy is overridden
+		config: &Config{RBAC: testRBAC, Policy: newTestPolicy(t), Overlap: OverlapConfig{Default: overlapReject}},
+		client: &client,
+	}
+
+	router = mux.NewRouter()
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	body, _ := json.Marshal(APISilenceRequest{
+		Comment:       "emergency",
+		Matchers:      []Matcher{{Name: "severity", Value: ".+", IsRegex: true}},
+		Justification: "incident 42",
+		Schedule: Schedule{
+			StartTime: "2021-10-12T12:00:00.000Z",
+			EndTime:   "2021-10-12T13:00:00.000Z",
+			Repeat:    Repeat{Interval: "h", Count: 1},
+		},
+	})
+	req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = withIdentity(req, &Identity{Name: "carol", Groups: []string{"sre"}, Token: true})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("wrong status code: got '%d' want '%d', body: %s", rr.Code, http.StatusBadGateway, rr.Body.String())
+	}
+
+	// the override is only recorded once the maintenance it allowed exists
+	if entries := app.auditLog().Query(AuditFilter{}); len(entries) != 0 {
+		t.Errorf("expected no audit entry, got '%+v'", entries)
+	}
+}
+
+func TestApp_expireSilence_audit(t *testing.T) {
+	id := "s1"
+	state := models.SilenceStatusStateActive
+	silence := models.GettableSilence{ID: &id, Status: &models.SilenceStatus{State: &state}}
+
+	client := MockAlertManagerClient{}
+	client.On("GetSilenceWithID", "s1").Return(silence, nil)
+	client.On("ExpireSilenceWithID", "s1").Return(nil)
+	app := App{
+		config: &Config{},
+		client: &client,
+	}
+	err := app.maintenanceStore().Add(Maintenance{ID: "m1", State: stateScheduled, SilenceIDs: []string{"s1"}})
+	if err != nil {
+		t.Fatalf("unable to add maintenance: %s", err.Error())
+	}
+
+	req := httptest.NewRequest("DELETE", "/api/v1/silence/s1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "s1"})
+	req = withIdentity(req, &Identity{Name: "bob", Token: true})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.expireSilence).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusOK)
+	}
+
+	got := app.auditLog().Query(AuditFilter{SilenceID: "s1"})
+	if len(got) != 1 {
+		t.Fatalf("expected one entry for the silence, got '%+v'", got)
+	}
+	e := got[0]
+	if e.Action != actionExpire || e.Actor != "bob" || e.MaintenanceID != "m1" {
+		t.Errorf("unexpected expire entry: '%+v'", e)
+	}
+
+	var before, after models.GettableSilence
+	json.Unmarshal(e.Before, &before)
+	json.Unmarshal(e.After, &after)
+	if before.Status == nil || *before.Status.State != models.SilenceStatusStateActive {
+		t.Errorf("before state should be the active silence, got '%s'", string(e.Before))
+	}
+	if after.Status == nil || *after.Status.State != models.SilenceStatusStateExpired {
+		t.Errorf("after state should be the expired silence, got '%s'", string(e.After))
+	}
+}
+
+func TestApp_exportAuditLog(t *testing.T) {
+	app := App{config: &Config{}}
+	app.auditLog().Record(AuditEntry{Action: actionCreate, Actor: "alice", MaintenanceID: "m1"})
+	app.auditLog().Record(AuditEntry{Action: actionReject, Actor: "bob", MaintenanceID: "m1"})
+	app.auditLog().Record(AuditEntry{Action: actionCreate, Actor: "bob", MaintenanceID: "m2"})
+
+	rr := httptest.NewRecorder()
+	app.exportAuditLog(rr, httptest.NewRequest("GET", "/api/v1/audit/export?maintenance=m1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("wrong content type: got '%s'", ct)
+	}
+
+	var actions []string
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var e AuditEntry
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			t.Fatalf("export line is not a JSON entry: %s", err.Error())
+		}
+		actions = append(actions, e.Action)
+	}
+	if len(actions) != 2 || actions[0] != actionCreate || actions[1] != actionReject {
+		t.Errorf("unexpected exported entries: '%v'", actions)
+	}
+
+	rr = httptest.NewRecorder()
+	app.getAuditLog(rr, httptest.NewRequest("GET", "/api/v1/audit?since=yesterday", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code for invalid filter: got '%d' want '%d'", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionUserKey  = "user"
+	sessionStateKey = "oidc_state"
+
+	defaultUsernameClaim = "preferred_username"
+	defaultGroupsClaim   = "groups"
+)
+
+type contextKey string
+
+const identityContextKey contextKey = "identity"
+
+// Identity of the authenticated caller of a request
+type Identity struct {
+	Name   string
+	Email  string
+	Groups []string
+
+	// Token is set when the caller authenticated with an API token restricted to Scopes
+	Token  bool
+	Scopes []string
+}
+
+// authenticator logs users in through an OpenID Connect provider
+type authenticator struct {
+	oauth2        oauth2.Config
+	verifier      *oidc.IDTokenVerifier
+	usernameClaim string
+	groupsClaim   string
+}
+
+func newAuthenticator(ctx context.Context, conf *OIDCConfig) (*authenticator, error) {
+	provider, err := oidc.NewProvider(ctx, conf.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to discover oidc provider: %s", err.Error())
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+	scopes = append(scopes, conf.Scopes...)
+
+	usernameClaim := conf.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = defaultUsernameClaim
+	}
+	groupsClaim := conf.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+
+	return &authenticator{
+		oauth2: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:      provider.Verifier(&oidc.Config{ClientID: conf.ClientID}),
+		usernameClaim: usernameClaim,
+		groupsClaim:   groupsClaim,
+	}, nil
+}
+
+// identity exchanges an authorization code for an ID token and extracts the caller identity from it
+func (au *authenticator) identity(ctx context.Context, code string) (*Identity, error) {
+	token, err := au.oauth2.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("unable to exchange authorization code: %s", err.Error())
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("no id_token in token response")
+	}
+
+	idToken, err := au.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify id_token: %s", err.Error())
+	}
+
+	var claims map[string]interface{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read id_token claims: %s", err.Error())
+	}
+
+	id := &Identity{}
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims[au.usernameClaim].(string)
+	if id.Name == "" {
+		id.Name = id.Email
+	}
+	if id.Name == "" {
+		id.Name = idToken.Subject
+	}
+
+	groups, _ := claims[au.groupsClaim].([]interface{})
+	for _, g := range groups {
+		if s, ok := g.(string); ok {
+			id.Groups = append(id.Groups, s)
+		}
+	}
+	return id, nil
+}
+
+func (a *App) authenticator() *authenticator {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.auth
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (a *App) login(w http.ResponseWriter, r *http.Request) {
+	au := a.authenticator()
+	if au == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		msg := fmt.Sprintf("unable to generate login state: %s", err.Error())
+		writeError(msg, w)
+		return
+	}
+
+	// an undecodable cookie (eg: after a key rotation) is replaced by a new session
+	session, _ := getSession(r)
+	session.Values[sessionStateKey] = state
+	err = session.Save(r, w)
+	if err != nil {
+		msg := fmt.Sprintf("unable to save session: %s", err.Error())
+		writeError(msg, w)
+		return
+	}
+	http.Redirect(w, r, au.oauth2.AuthCodeURL(state), http.StatusFound)
+}
+
+func (a *App) loginCallback(w http.ResponseWriter, r *http.Request) {
+	au := a.authenticator()
+	if au == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	session, err := getSession(r)
+	if err != nil {
+		http.Error(w, "invalid session", http.StatusBadRequest)
+		return
+	}
+
+	state, ok := session.Values[sessionStateKey].(string)
+	if !ok || state == "" || r.URL.Query().Get("state") != state {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+	delete(session.Values, sessionStateKey)
+
+	id, err := au.identity(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		log.Printf("login failed: %s\n", err.Error())
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	session.Values[sessionUserKey] = id
+	err = session.Save(r, w)
+	if err != nil {
+		msg := fmt.Sprintf("unable to save session: %s", err.Error())
+		writeError(msg, w)
+		return
+	}
+	log.Printf("user '%s' logged in\n", id.Name)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (a *App) logout(w http.ResponseWriter, r *http.Request) {
+	session, _ := getSession(r)
+	delete(session.Values, sessionUserKey)
+	session.Options.MaxAge = -1
+	session.Save(r, w)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func sessionIdentity(r *http.Request) (*Identity, bool) {
+	session, err := getSession(r)
+	if err != nil {
+		return nil, false
+	}
+	id, ok := session.Values[sessionUserKey].(*Identity)
+	return id, ok && id != nil
+}
+
+// identityFromContext returns the caller identity set by the authentication middleware
+func identityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityContextKey).(*Identity)
+	return id, ok && id != nil
+}
+
+func withIdentity(r *http.Request, id *Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityContextKey, id))
+}
+
+// requireAuth authenticates API calls with a bearer token, or with the session when oidc is enabled
+func (a *App) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a token in the query string ends up in logs and browser history, so it can only read
+		if raw, ok := queryToken(r); ok {
+			id, err := a.tokenIdentity(raw)
+			if err != nil {
+				writeErrorWithCode(err.Error(), http.StatusUnauthorized, w)
+				return
+			}
+			if !id.readOnly() {
+				msg := fmt.Sprintf("api token '%s' must be restricted to the '%s' scope to be passed in the query string", id.Name, scopeRead)
+				writeErrorWithCode(msg, http.StatusForbidden, w)
+				return
+			}
+			next.ServeHTTP(w, withIdentity(r, id))
+			return
+		}
+
+		if raw, ok := bearerToken(r); ok {
+			id, err := a.tokenIdentity(raw)
+			if err != nil {
+				writeErrorWithCode(err.Error(), http.StatusUnauthorized, w)
+				return
+			}
+			next.ServeHTTP(w, withIdentity(r, id))
+			return
+		}
+
+		if a.authenticator() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, ok := sessionIdentity(r)
+		if !ok {
+			writeErrorWithCode("authentication required", http.StatusUnauthorized, w)
+			return
+		}
+		next.ServeHTTP(w, withIdentity(r, id))
+	})
+}
+
+// requireLogin redirects to the oidc login page when there is no authenticated session
+func (a *App) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.authenticator() == nil {
+			next(w, r)
+			return
+		}
+
+		id, ok := sessionIdentity(r)
+		if !ok {
+			http.Redirect(w, r, "/auth/login", http.StatusFound)
+			return
+		}
+		next(w, withIdentity(r, id))
+	}
+}
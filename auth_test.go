@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/mock"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	testClientID      = "ams"
+	testAuthKey       = "0123456789abcdef0123456789abcdef"
+	testEncryptionKey = "fedcba9876543210fedcba9876543210"
+)
+
+// newFakeOIDCProvider serves the discovery, keys and token endpoints of an oidc provider
+// issuing ID tokens with the given claims
+func newFakeOIDCProvider(t *testing.T, claims map[string]interface{}) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err.Error())
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatalf("unable to create signer: %s", err.Error())
+	}
+
+	var ts *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                ts.URL,
+			"authorization_endpoint":                ts.URL + "/auth",
+			"token_endpoint":                        ts.URL + "/token",
+			"jwks_uri":                              ts.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		c := map[string]interface{}{
+			"iss": ts.URL,
+			"aud": testClientID,
+			"sub": "1234",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range claims {
+			c[k] = v
+		}
+		payload, _ := json.Marshal(c)
+		jws, err := signer.Sign(payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		idToken, _ := jws.CompactSerialize()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	ts = httptest.NewServer(mux)
+	return ts
+}
+
+func newTestAuthApp(t *testing.T, issuer string) *App {
+	au, err := newAuthenticator(context.Background(), &OIDCConfig{
+		IssuerURL:   issuer,
+		ClientID:    testClientID,
+		RedirectURL: "http://ams.local/auth/callback",
+	})
+	if err != nil {
+		t.Fatalf("unable to create authenticator: %s", err.Error())
+	}
+	setSessionStore(newSessionStore(SessionConfig{AuthKey: testAuthKey, EncryptionKey: testEncryptionKey}))
+	return &App{config: &Config{}, auth: au}
+}
+
+func resetSessionStore() {
+	setSessionStore(sessions.NewCookieStore([]byte("")))
+}
+
+// loginAs goes through the oidc login flow and returns the resulting session cookies
+func loginAs(t *testing.T, app *App) []*http.Cookie {
+	rr := httptest.NewRecorder()
+	app.login(rr, httptest.NewRequest("GET", "/auth/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("wrong status code on login: got '%d' want '%d'", rr.Code, http.StatusFound)
+	}
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	state := location.Query().Get("state")
+
+	req := httptest.NewRequest("GET", "/auth/callback?code=abc&state="+url.QueryEscape(state), nil)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	app.loginCallback(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("wrong status code on callback: got '%d' want '%d', body: %s", rr.Code, http.StatusFound, rr.Body.String())
+	}
+	return rr.Result().Cookies()
+}
+
+func TestApp_loginFlow(t *testing.T) {
+	provider := newFakeOIDCProvider(t, map[string]interface{}{
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"groups":             []string{"sre"},
+	})
+	defer provider.Close()
+	defer resetSessionStore()
+
+	app := newTestAuthApp(t, provider.URL)
+	cookies := loginAs(t, app)
+
+	var got *Identity
+	handler := app.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = identityFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/api/v1/silences", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusOK)
+	}
+	if got == nil || got.Name != "alice" || got.Email != "alice@example.com" || len(got.Groups) != 1 || got.Groups[0] != "sre" {
+		t.Errorf("unexpected identity in request context: '%+v'", got)
+	}
+}
+
+func TestApp_loginCallback_invalidState(t *testing.T) {
+	provider := newFakeOIDCProvider(t, nil)
+	defer provider.Close()
+	defer resetSessionStore()
+
+	app := newTestAuthApp(t, provider.URL)
+
+	rr := httptest.NewRecorder()
+	app.loginCallback(rr, httptest.NewRequest("GET", "/auth/callback?code=abc&state=forged", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestApp_requireAuth(t *testing.T) {
+	provider := newFakeOIDCProvider(t, nil)
+	defer provider.Close()
+	defer resetSessionStore()
+
+	handler := func(app *App) http.Handler {
+		return app.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	// authentication disabled
+	rr := httptest.NewRecorder()
+	handler(&App{config: &Config{}}).ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/alerts", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("wrong status code without oidc: got '%d' want '%d'", rr.Code, http.StatusOK)
+	}
+
+	// authentication enabled, no session
+	rr = httptest.NewRecorder()
+	handler(newTestAuthApp(t, provider.URL)).ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/alerts", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code without session: got '%d' want '%d'", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestApp_createSilence_createdByIdentity(t *testing.T) {
+	client := MockAlertManagerClient{}
+	client.On("CreateSilenceWith",
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string"),
+		mock.MatchedBy(func(r APISilenceRequest) bool { return r.CreatedBy == "alice" })).Return("1234", nil)
+	client.On("ListSilences").Return(models.GettableSilences{}, nil)
+	app := App{
+		config: &Config{},
+		client: &client,
+	}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	token, cookies := newCSRFSession(t)
+
+	form := url.Values{}
+	form.Add("csrf_token", token)
+	form.Add("Comment", "test")
+	form.Add("CreatedBy", "mallory")
+	form.Add("Matchers.0.Name", "job")
+	form.Add("Matchers.0.Value", "MockApp")
+	form.Add("Schedule.StartTime", "2020-11-01T22:12:33.533Z")
+	form.Add("Schedule.EndTime", "2020-11-01T23:11:44.603Z")
+	form.Add("Schedule.Repeat.Count", "1")
+	form.Add("Schedule.Repeat.Interval", "h")
+
+	req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBufferString(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	req = withIdentity(req, &Identity{Name: "alice"})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+	client.AssertNumberOfCalls(t, "CreateSilenceWith", 1)
+}
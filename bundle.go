@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// bundleVersion version of the bundle format, bumped on incompatible changes
+const bundleVersion = 1
+
+// Bundle portable set of maintenances, moved from one scheduler to another
+type Bundle struct {
+	Version      int                  `json:"version" yaml:"version"`
+	ExportedAt   time.Time            `json:"exportedAt" yaml:"exported_at"`
+	Maintenances []BundledMaintenance `json:"maintenances" yaml:"maintenances"`
+}
+
+// BundledMaintenance a maintenance of a bundle, with its recurrence and metadata
+type BundledMaintenance struct {
+	ID         string           `json:"id" yaml:"id"`
+	State      string           `json:"state" yaml:"state"`
+	Comment    string           `json:"comment" yaml:"comment"`
+	CreatedBy  string           `json:"createdBy" yaml:"created_by"`
+	Ticket     string           `json:"ticket,omitempty" yaml:"ticket,omitempty"`
+	Matchers   []BundledMatcher `json:"matchers" yaml:"matchers"`
+	Schedule   BundledSchedule  `json:"schedule" yaml:"schedule"`
+	CreatedAt  time.Time        `json:"createdAt" yaml:"created_at"`
+	ApprovedBy string           `json:"approvedBy,omitempty" yaml:"approved_by,omitempty"`
+}
+
+// BundledMatcher a matcher of a bundled maintenance
+type BundledMatcher struct {
+	Name    string `json:"name" yaml:"name"`
+	Value   string `json:"value" yaml:"value"`
+	IsRegex bool   `json:"isRegex" yaml:"is_regex"`
+}
+
+// BundledSchedule the recurrence of a bundled maintenance
+type BundledSchedule struct {
+	StartTime string   `json:"startTime" yaml:"start_time"`
+	EndTime   string   `json:"endTime" yaml:"end_time"`
+	Interval  string   `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Count     int      `json:"count" yaml:"count"`
+	Exclude   []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	// Calendar, BusinessDays and OnHoliday the occurrences shifted off a holiday are resolved from on import
+	Calendar     string `json:"calendar,omitempty" yaml:"calendar,omitempty"`
+	BusinessDays bool   `json:"businessDays,omitempty" yaml:"business_days,omitempty"`
+	OnHoliday    string `json:"onHoliday,omitempty" yaml:"on_holiday,omitempty"`
+}
+
+// ImportReport outcome of the import of a bundle, per maintenance
+type ImportReport struct {
+	Imported  []ImportResult `json:"imported"`
+	Conflicts []ImportResult `json:"conflicts"`
+	Invalid   []ImportResult `json:"invalid"`
+	Failed    []ImportResult `json:"failed"`
+}
+
+// ImportResult outcome of the import of a single maintenance
+type ImportResult struct {
+	ID       string `json:"id"`
+	State    string `json:"state,omitempty"`
+	Silences int    `json:"silences,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func bundleMaintenance(m Maintenance) BundledMaintenance {
+	b := BundledMaintenance{
+		ID:         m.ID,
+		State:      m.State,
+		Comment:    m.Request.Comment,
+		CreatedBy:  m.Request.CreatedBy,
+		Ticket:     m.Request.Ticket,
+		CreatedAt:  m.CreatedAt,
+		ApprovedBy: m.ApprovedBy,
+		Schedule: BundledSchedule{
+			StartTime:    m.Request.Schedule.StartTime,
+			EndTime:      m.Request.Schedule.EndTime,
+			Interval:     m.Request.Schedule.Repeat.Interval,
+			Count:        m.Request.Schedule.Repeat.Count,
+			Exclude:      m.Request.Schedule.Exclude,
+			Calendar:     m.Request.Schedule.Repeat.Calendar,
+			BusinessDays: m.Request.Schedule.Repeat.BusinessDays,
+			OnHoliday:    m.Request.Schedule.Repeat.OnHoliday,
+		},
+	}
+	for _, matcher := range m.Request.Matchers {
+		b.Matchers = append(b.Matchers, BundledMatcher{Name: matcher.Name, Value: matcher.Value, IsRegex: matcher.IsRegex})
+	}
+	return b
+}
+
+// request converts the bundled maintenance back into the request it was created from
+func (b BundledMaintenance) request() APISilenceRequest {
+	r := APISilenceRequest{
+		ID:        b.ID,
+		Comment:   b.Comment,
+		CreatedBy: b.CreatedBy,
+		Ticket:    b.Ticket,
+		Schedule: Schedule{
+			StartTime: b.Schedule.StartTime,
+			EndTime:   b.Schedule.EndTime,
+			Repeat: Repeat{
+				Interval:     b.Schedule.Interval,
+				Count:        b.Schedule.Count,
+				Calendar:     b.Schedule.Calendar,
+				BusinessDays: b.Schedule.BusinessDays,
+				OnHoliday:    b.Schedule.OnHoliday,
+			},
+			Exclude: b.Schedule.Exclude,
+		},
+	}
+	for _, m := range b.Matchers {
+		r.Matchers = append(r.Matchers, Matcher{Name: m.Name, Value: m.Value, IsRegex: m.IsRegex})
+	}
+	return r
+}
+
+// isYAML returns true if the content type is YAML rather than JSON
+func isYAML(contentType string) bool {
+	return strings.Contains(contentType, "yaml")
+}
+
+func (a *App) exportMaintenances(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ids := map[string]bool{}
+	for _, id := range q["id"] {
+		ids[id] = true
+	}
+
+	bundle := Bundle{Version: bundleVersion, ExportedAt: time.Now().UTC(), Maintenances: []BundledMaintenance{}}
+	for _, m := range a.maintenanceStore().List() {
+		if len(ids) > 0 && !ids[m.ID] {
+			continue
+		}
+		if state := q.Get("state"); state != "" && m.State != state {
+			continue
+		}
+		if createdBy := q.Get("createdBy"); createdBy != "" && m.Request.CreatedBy != createdBy {
+			continue
+		}
+		bundle.Maintenances = append(bundle.Maintenances, bundleMaintenance(m))
+	}
+
+	if q.Get("format") == "yaml" {
+		b, err := yaml.Marshal(bundle)
+		if err != nil {
+			writeError(fmt.Sprintf("unable to marshal bundle: %s", err.Error()), w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.Header().Set("Content-Disposition", `attachment; filename="maintenances.yml"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="maintenances.json"`)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(bundle)
+}
+
+// importedRequest returns the request of a bundled maintenance as submitted by the caller, the
+// authenticated identity takes precedence over the creator of the bundle like for any other submission
+// and the shifted occurrences are resolved from the calendars of the target
+func (a *App) importedRequest(r *http.Request, b BundledMaintenance) (APISilenceRequest, error) {
+	request := b.request()
+	if id, ok := identityFromContext(r.Context()); ok {
+		request.CreatedBy = id.Name
+	}
+	return a.conf().applyCalendar(request)
+}
+
+// validateBundle checks every maintenance of the bundle as if it was submitted by the caller,
+// and reports the ones already present in the store along with the overrides of the valid ones
+func (a *App) validateBundle(r *http.Request, bundle Bundle) (ImportReport, map[string]*overrideLog) {
+	overrides := map[string]*overrideLog{}
+	report := ImportReport{Imported: []ImportResult{}, Conflicts: []ImportResult{}, Invalid: []ImportResult{}, Failed: []ImportResult{}}
+
+	seen := map[string]bool{}
+	for _, b := range bundle.Maintenances {
+		if b.ID == "" {
+			report.Invalid = append(report.Invalid, ImportResult{Reason: "maintenance id is empty"})
+			continue
+		}
+		if seen[b.ID] {
+			report.Invalid = append(report.Invalid, ImportResult{ID: b.ID, Reason: "maintenance is present more than once"})
+			continue
+		}
+		seen[b.ID] = true
+
+		if b.State != stateScheduled && b.State != statePending {
+			report.Conflicts = append(report.Conflicts, ImportResult{ID: b.ID, Reason: fmt.Sprintf("maintenance is %s", b.State)})
+			continue
+		}
+		if _, ok := a.maintenanceStore().Get(b.ID); ok {
+			report.Conflicts = append(report.Conflicts, ImportResult{ID: b.ID, Reason: "maintenance already exists"})
+			continue
+		}
+
+		request, err := a.importedRequest(r, b)
+		if err != nil {
+			report.Invalid = append(report.Invalid, ImportResult{ID: b.ID, Reason: err.Error()})
+			continue
+		}
+		overrides[b.ID] = &overrideLog{}
+		msg, ok := request.Valid(a.validationRules(r, b.ID, overrides[b.ID])...)
+		if !ok {
+			report.Invalid = append(report.Invalid, ImportResult{ID: b.ID, Reason: msg})
+			continue
+		}
+		report.Imported = append(report.Imported, ImportResult{ID: b.ID})
+	}
+	return report, overrides
+}
+
+// importMaintenances re-creates the maintenances of a bundle against the current Alertmanager.
+// Nothing is imported if a single maintenance is invalid, maintenances already present are skipped.
+func (a *App) importMaintenances(w http.ResponseWriter, r *http.Request) {
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeErrorWithCode(fmt.Sprintf("unable to read bundle: %s", err.Error()), http.StatusBadRequest, w)
+		return
+	}
+
+	var bundle Bundle
+	if isYAML(r.Header.Get("Content-Type")) {
+		err = yaml.UnmarshalStrict(body, &bundle)
+	} else {
+		err = json.Unmarshal(body, &bundle)
+	}
+	if err != nil {
+		writeErrorWithCode(fmt.Sprintf("unable to parse bundle: %s", err.Error()), http.StatusBadRequest, w)
+		return
+	}
+	if bundle.Version != bundleVersion {
+		writeErrorWithCode(fmt.Sprintf("unsupported bundle version %d, expected %d", bundle.Version, bundleVersion), http.StatusBadRequest, w)
+		return
+	}
+
+	report, overrides := a.validateBundle(r, bundle)
+	if len(report.Invalid) > 0 || r.URL.Query().Get("dryRun") == "true" {
+		code := http.StatusOK
+		if len(report.Invalid) > 0 {
+			code = http.StatusBadRequest
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	byID := map[string]BundledMaintenance{}
+	for _, b := range bundle.Maintenances {
+		byID[b.ID] = b
+	}
+
+	now := time.Now()
+	validated := report.Imported
+	report.Imported = []ImportResult{}
+	for _, res := range validated {
+		b := byID[res.ID]
+		// the request was resolved without error when the bundle was validated
+		request, _ := a.importedRequest(r, b)
+		m := Maintenance{ID: b.ID, Request: request, CreatedAt: b.CreatedAt}
+
+		msg, ok, err := a.conf().Ticket.check(m.Request.Ticket)
+		if err != nil {
+			msg = err.Error()
+		}
+		if err != nil || !ok {
+			report.Failed = append(report.Failed, ImportResult{ID: b.ID, Reason: msg})
+			continue
+		}
+
+		// the approval policy of the target applies, whatever the state of the maintenance in the bundle
+		res = ImportResult{ID: b.ID}
+		m.State = statePending
+		if !a.conf().Approval.required(m.Request) {
+			m.State = stateScheduled
+			ids, failed := a.createUpcomingSilences(m.Request, now)
+			m.SilenceIDs = ids
+			if failed != 0 {
+				res.Reason = fmt.Sprintf("'%d' silence(s) could not be created", failed)
+			}
+		}
+
+		err = a.maintenanceStore().Add(m)
+		if err != nil {
+			report.Failed = append(report.Failed, ImportResult{ID: b.ID, Reason: fmt.Sprintf("unable to store maintenance: %s", err.Error())})
+			continue
+		}
+		a.audit(r, AuditEntry{
+			Action:        actionImport,
+			MaintenanceID: m.ID,
+			SilenceIDs:    m.SilenceIDs,
+			After:         auditState(m),
+			Details:       fmt.Sprintf("created by '%s' in the bundle", b.CreatedBy),
+		})
+		a.recordOverrides(r, overrides[m.ID], m.ID)
+		a.notify(eventCreated, m, nil)
+		res.State = m.State
+		res.Silences = len(m.SilenceIDs)
+		report.Imported = append(report.Imported, res)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// createUpcomingSilences creates a silence for each occurrence of the request which is not over yet
+func (a *App) createUpcomingSilences(request APISilenceRequest, now time.Time) ([]string, int) {
+	occurrences, err := request.Schedule.occurrences()
+	if err != nil {
+		log.Println(err)
+		return nil, request.Schedule.Repeat.Count
+	}
+
+	var silenceIDs []string
+	var failed = 0
+	for _, o := range occurrences {
+		end, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil || !end.After(now) {
+			continue
+		}
+		id, err := a.amClient().CreateSilenceWith(o.Start, o.End, request)
+		if err != nil {
+			log.Println(err)
+			failed++
+			continue
+		}
+		silenceIDs = append(silenceIDs, id)
+	}
+	return silenceIDs, failed
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func importBundle(t *testing.T, app *App, contentType string, body []byte, query string) (int, ImportReport) {
+	req := httptest.NewRequest("POST", "/api/v1/maintenances/import"+query, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", contentType)
+	req = withIdentity(req, &Identity{Name: "carol", Token: true})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.importMaintenances).ServeHTTP(rr, req)
+
+	var report ImportReport
+	json.NewDecoder(rr.Body).Decode(&report)
+	return rr.Code, report
+}
+
+func TestApp_exportImportMaintenances(t *testing.T) {
+	// the source maintenance is half over
+	now := time.Now().UTC().Truncate(time.Hour)
+	source := App{config: &Config{}, client: newFakeAlertmanager(now)}
+	m := newScheduledMaintenance(t, &source, source.client.(*fakeAlertmanager), now.Add(-48*time.Hour), 4)
+	source.maintenanceStore().Add(Maintenance{ID: "rejected", State: stateRejected, Request: m.Request})
+
+	rr := httptest.NewRecorder()
+	source.exportMaintenances(rr, httptest.NewRequest("GET", "/api/v1/maintenances/export?format=yaml", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "version: 1") {
+		t.Errorf("bundle should be versioned, got:\n%s", rr.Body.String())
+	}
+	bundle := rr.Body.Bytes()
+
+	am := newFakeAlertmanager(now)
+	target := App{config: &Config{}, client: am}
+
+	// a dry run only reports what would be imported
+	code, report := importBundle(t, &target, "application/x-yaml", bundle, "?dryRun=true")
+	if code != http.StatusOK || len(report.Imported) != 1 || len(am.silences) != 0 {
+		t.Fatalf("unexpected dry run: '%d' '%+v'", code, report)
+	}
+
+	code, report = importBundle(t, &target, "application/x-yaml", bundle, "")
+	if code != http.StatusOK {
+		t.Fatalf("wrong status code: got '%d' want '%d'", code, http.StatusOK)
+	}
+	if len(report.Imported) != 1 || len(report.Conflicts) != 1 {
+		t.Fatalf("unexpected report: '%+v'", report)
+	}
+	// only the occurrences not over yet are re-created
+	if got := report.Imported[0].Silences; got != 2 {
+		t.Errorf("wrong number of silences created: got '%d' want '%d'", got, 2)
+	}
+	imported, ok := target.maintenanceStore().Get(m.ID)
+	// the importer is the creator, the one of the bundle is only audited
+	if !ok || imported.State != stateScheduled || imported.Request.CreatedBy != "carol" {
+		t.Errorf("unexpected imported maintenance: '%+v'", imported)
+	}
+	entries := target.auditLog().Query(AuditFilter{Action: actionImport})
+	if len(entries) != 1 || !strings.Contains(entries[0].Details, m.Request.CreatedBy) {
+		t.Errorf("unexpected import audit entries: '%+v'", entries)
+	}
+
+	// importing twice reports a conflict
+	code, report = importBundle(t, &target, "application/x-yaml", bundle, "")
+	if code != http.StatusOK || len(report.Imported) != 0 || len(report.Conflicts) != 2 {
+		t.Errorf("unexpected report on second import: '%d' '%+v'", code, report)
+	}
+}
+
+func TestApp_importMaintenances_invalid(t *testing.T) {
+	start := time.Now().UTC().Add(24 * time.Hour)
+	valid := BundledMaintenance{
+		ID:        "valid",
+		State:     stateScheduled,
+		Comment:   "patching",
+		CreatedBy: "alice",
+		Matchers:  []BundledMatcher{{Name: "team", Value: "db"}},
+		Schedule: BundledSchedule{
+			StartTime: start.Format(requestTimeLayout),
+			EndTime:   start.Add(time.Hour).Format(requestTimeLayout),
+			Count:     1,
+		},
+	}
+	invalid := valid
+	invalid.ID = "invalid"
+	invalid.Matchers = nil
+
+	var cases = []struct {
+		name   string
+		bundle Bundle
+	}{
+		{"unsupported version", Bundle{Version: 2, Maintenances: []BundledMaintenance{valid}}},
+		{"invalid maintenance", Bundle{Version: bundleVersion, Maintenances: []BundledMaintenance{valid, invalid}}},
+		{"duplicate maintenance", Bundle{Version: bundleVersion, Maintenances: []BundledMaintenance{valid, valid}}},
+	}
+
+	for _, c := range cases {
+		am := newFakeAlertmanager(time.Now())
+		app := App{config: &Config{}, client: am}
+
+		body, _ := json.Marshal(c.bundle)
+		code, _ := importBundle(t, &app, "application/json", body, "")
+		if code != http.StatusBadRequest {
+			t.Errorf("wrong status code for '%s': got '%d' want '%d'", c.name, code, http.StatusBadRequest)
+		}
+		if len(am.silences) != 0 || len(app.maintenanceStore().List()) != 0 {
+			t.Errorf("nothing should be imported for '%s'", c.name)
+		}
+	}
+}
+
+func TestApp_importMaintenances_approval(t *testing.T) {
+	start := time.Now().UTC().Add(24 * time.Hour)
+	am := newFakeAlertmanager(time.Now())
+	app := App{config: &Config{Approval: testApproval}, client: am}
+
+	// the bundle claims somebody else created the maintenance
+	body, _ := json.Marshal(Bundle{Version: bundleVersion, Maintenances: []BundledMaintenance{{
+		ID:        "forged",
+		State:     stateScheduled,
+		Comment:   "patching",
+		CreatedBy: "alice",
+		Matchers:  []BundledMatcher{{Name: "env", Value: "prod"}},
+		Schedule: BundledSchedule{
+			StartTime: start.Format(requestTimeLayout),
+			EndTime:   start.Add(time.Hour).Format(requestTimeLayout),
+			Count:     1,
+		},
+	}}})
+	code, report := importBundle(t, &app, "application/json", body, "")
+	if code != http.StatusOK || len(report.Imported) != 1 || report.Imported[0].State != statePending {
+		t.Fatalf("unexpected report: '%d' '%+v'", code, report)
+	}
+
+	// the importer cannot approve it
+	req := httptest.NewRequest("POST", "/api/v1/maintenance/forged/approve", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "forged"})
+	req = withIdentity(req, &Identity{Name: "carol", Groups: []string{"change-managers"}, Token: true})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.approveMaintenance).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusForbidden)
+	}
+	if m, _ := app.maintenanceStore().Get("forged"); m.State != statePending || len(am.silences) != 0 {
+		t.Errorf("maintenance should still be pending, got '%+v'", m)
+	}
+}
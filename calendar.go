@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	holidaySkip  = "skip"
+	holidayShift = "shift"
+
+	holidayDateLayout = "2006-01-02"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// defaultBusinessDays business days of a calendar which does not list them
+var defaultBusinessDays = []string{"mon", "tue", "wed", "thu", "fri"}
+
+// CalendarConfig a named calendar of business days and holidays recurrences can follow
+type CalendarConfig struct {
+	Name string `yaml:"name"`
+	// Timezone the days of the calendar are in, defaults to UTC
+	Timezone     string   `yaml:"timezone"`
+	BusinessDays []string `yaml:"business_days"`
+	Holidays     []string `yaml:"holidays"`
+	// HolidayFiles iCalendar (.ics) or YAML files listing holidays
+	HolidayFiles []string `yaml:"holiday_files"`
+
+	loc      *time.Location
+	days     map[time.Weekday]bool
+	holidays map[string]string
+}
+
+// Holiday a day off listed in a YAML holiday file
+type Holiday struct {
+	Date string `yaml:"date"`
+	Name string `yaml:"name"`
+}
+
+// load checks the calendar and reads its holiday files
+func (c *CalendarConfig) load() error {
+	if c.Name == "" {
+		return fmt.Errorf("calendar name is mandatory")
+	}
+
+	var err error
+	c.loc = time.UTC
+	if c.Timezone != "" {
+		c.loc, err = time.LoadLocation(c.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid time zone '%s' of calendar '%s': %s", c.Timezone, c.Name, err.Error())
+		}
+	}
+
+	days := c.BusinessDays
+	if len(days) == 0 {
+		days = defaultBusinessDays
+	}
+	c.days = map[time.Weekday]bool{}
+	for _, d := range days {
+		day, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return fmt.Errorf("invalid business day '%s' of calendar '%s', expected one of mon, tue, wed, thu, fri, sat or sun", d, c.Name)
+		}
+		c.days[day] = true
+	}
+
+	c.holidays = map[string]string{}
+	for _, h := range c.Holidays {
+		if _, err = time.Parse(holidayDateLayout, h); err != nil {
+			return fmt.Errorf("invalid holiday '%s' of calendar '%s', expected YYYY-MM-DD", h, c.Name)
+		}
+		c.holidays[h] = ""
+	}
+	for _, path := range c.HolidayFiles {
+		holidays, err := loadHolidays(path)
+		if err != nil {
+			return fmt.Errorf("unable to load holidays of calendar '%s': %s", c.Name, err.Error())
+		}
+		for _, h := range holidays {
+			c.holidays[h.Date] = h.Name
+		}
+	}
+	return nil
+}
+
+// loadHolidays reads the holidays of an iCalendar file, where each all-day event is a holiday,
+// or of a YAML file
+func loadHolidays(path string) ([]Holiday, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".ics" {
+		return parseHolidaysICS(string(data))
+	}
+
+	var holidays []Holiday
+	err = yaml.Unmarshal(data, &holidays)
+	if err != nil {
+		return nil, fmt.Errorf("invalid holidays in '%s': %s", path, err.Error())
+	}
+	for _, h := range holidays {
+		if _, err = time.Parse(holidayDateLayout, h.Date); err != nil {
+			return nil, fmt.Errorf("invalid holiday date '%s' in '%s', expected YYYY-MM-DD", h.Date, path)
+		}
+	}
+	return holidays, nil
+}
+
+// parseHolidaysICS returns a holiday for each day covered by the events of an iCalendar document,
+// recurring events are not supported
+func parseHolidaysICS(data string) ([]Holiday, error) {
+	notices, err := parseCalendar(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var holidays []Holiday
+	for _, n := range notices {
+		if n.Cancelled {
+			continue
+		}
+		if n.RRule != "" {
+			return nil, fmt.Errorf("recurring holiday '%s' is not supported, list each occurrence", n.Summary)
+		}
+		// all-day events start at midnight, their days are read as is
+		day := time.Date(n.Start.Year(), n.Start.Month(), n.Start.Day(), 0, 0, 0, 0, time.UTC)
+		last := n.End
+		if last.IsZero() {
+			last = day.AddDate(0, 0, 1)
+		}
+		for ; day.Before(last); day = day.AddDate(0, 0, 1) {
+			holidays = append(holidays, Holiday{Date: day.Format(holidayDateLayout), Name: n.Summary})
+		}
+	}
+	return holidays, nil
+}
+
+// holiday returns true if the day of the time, in the calendar time zone, is a holiday
+func (c CalendarConfig) holiday(t time.Time) bool {
+	_, ok := c.holidays[t.In(c.loc).Format(holidayDateLayout)]
+	return ok
+}
+
+// businessDay returns true if the day of the time, in the calendar time zone, is a business day
+// which is not a holiday
+func (c CalendarConfig) businessDay(t time.Time) bool {
+	return c.days[t.In(c.loc).Weekday()] && !c.holiday(t)
+}
+
+// nextBusinessDay returns the same time of the first business day after the one of the time
+func (c CalendarConfig) nextBusinessDay(t time.Time) (time.Time, bool) {
+	local := t.In(c.loc)
+	for i := 1; i <= 366; i++ {
+		next := local.AddDate(0, 0, i)
+		if c.businessDay(next) {
+			return next, true
+		}
+	}
+	return t, false
+}
+
+// adjust skips or shifts the occurrences of the schedule landing on days off, the ones skipped are
+// added to its exclusions and the ones shifted to its shifts
+func (c CalendarConfig) adjust(s Schedule) (Schedule, error) {
+	excluded := map[string]bool{}
+	for _, e := range s.Exclude {
+		excluded[e] = true
+	}
+
+	var starts []time.Time
+	taken := map[string]bool{}
+	for i := 0; i < s.Repeat.Count; i++ {
+		start, err := addDuration(s.StartTime, s.Repeat.Interval, i)
+		if err != nil {
+			return s, err
+		}
+		if excluded[start] {
+			continue
+		}
+		t, _ := time.Parse(requestTimeLayout, start)
+		starts = append(starts, t)
+		taken[t.In(c.loc).Format(holidayDateLayout)] = true
+	}
+
+	s.Shift = nil
+	left := 0
+	for _, t := range starts {
+		start := t.Format(requestTimeLayout)
+		switch {
+		case c.holiday(t) && s.Repeat.OnHoliday == holidayShift:
+			// an occurrence is not shifted onto a day which already has one
+			next, ok := c.nextBusinessDay(t)
+			day := next.Format(holidayDateLayout)
+			if ok && !taken[day] {
+				if s.Shift == nil {
+					s.Shift = map[string]string{}
+				}
+				s.Shift[start] = next.UTC().Format(requestTimeLayout)
+				taken[day] = true
+				left++
+				continue
+			}
+		case c.holiday(t) && s.Repeat.OnHoliday == holidaySkip:
+		case s.Repeat.BusinessDays && !c.businessDay(t):
+		default:
+			left++
+			continue
+		}
+		s.Exclude = append(s.Exclude, start)
+	}
+
+	if left == 0 {
+		return s, fmt.Errorf("every occurrence lands on a day off of calendar '%s'", c.Name)
+	}
+	return s, nil
+}
+
+// calendar returns the calendar with the given name
+func (c *Config) calendar(name string) (CalendarConfig, bool) {
+	for _, cal := range c.Calendars {
+		if cal.Name == name {
+			return cal, true
+		}
+	}
+	return CalendarConfig{}, false
+}
+
+// applyCalendar resolves the calendar of the request recurrence into the occurrences it skips or shifts,
+// so that they do not move when the holidays of the calendar change
+func (c *Config) applyCalendar(r APISilenceRequest) (APISilenceRequest, error) {
+	repeat := r.Schedule.Repeat
+	if repeat.Calendar == "" && !repeat.BusinessDays && repeat.OnHoliday == "" {
+		return r, nil
+	}
+
+	cal := CalendarConfig{Name: "default"}
+	if repeat.Calendar != "" {
+		var ok bool
+		cal, ok = c.calendar(repeat.Calendar)
+		if !ok {
+			return r, fmt.Errorf("unknown calendar '%s'", repeat.Calendar)
+		}
+	} else if repeat.OnHoliday != "" {
+		return r, fmt.Errorf("a calendar is needed to know the holidays")
+	} else if err := cal.load(); err != nil {
+		return r, err
+	}
+
+	schedule, err := cal.adjust(r.Schedule)
+	if err != nil {
+		return r, err
+	}
+	r.Schedule = schedule
+	return r, nil
+}
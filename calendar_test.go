@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const testHolidaysICS = `BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VEVENT
+UID:christmas-2021
+SUMMARY:Christmas Day
+DTSTART;VALUE=DATE:20211225
+END:VEVENT
+BEGIN:VEVENT
+UID:new-year-2022
+SUMMARY:New Year
+DTSTART;VALUE=DATE:20211231
+DTEND;VALUE=DATE:20220102
+END:VEVENT
+END:VCALENDAR
+`
+
+const testHolidaysYAML = `- date: "2021-10-11"
+  name: Thanksgiving
+- date: "2021-12-27"
+  name: Christmas Day (observed)
+`
+
+func TestCalendarConfig_load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ams-calendars")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	ics := filepath.Join(dir, "ca.ics")
+	yml := filepath.Join(dir, "ca.yml")
+	ioutil.WriteFile(ics, []byte(testHolidaysICS), 0644)
+	ioutil.WriteFile(yml, []byte(testHolidaysYAML), 0644)
+
+	cal := CalendarConfig{Name: "ca", Timezone: "America/Montreal", Holidays: []string{"2021-07-01"}, HolidayFiles: []string{ics, yml}}
+	if err = cal.load(); err != nil {
+		t.Fatalf("unable to load calendar: %s", err.Error())
+	}
+	for _, day := range []string{"2021-07-01", "2021-10-11", "2021-12-25", "2021-12-27", "2021-12-31", "2022-01-01"} {
+		if _, ok := cal.holidays[day]; !ok {
+			t.Errorf("'%s' should be a holiday", day)
+		}
+	}
+	if len(cal.holidays) != 6 || cal.holidays["2021-12-25"] != "Christmas Day" {
+		t.Errorf("unexpected holidays: '%v'", cal.holidays)
+	}
+
+	var cases = []struct {
+		name string
+		cal  CalendarConfig
+	}{
+		{"no name", CalendarConfig{}},
+		{"invalid time zone", CalendarConfig{Name: "ca", Timezone: "Mars/Olympus"}},
+		{"invalid business day", CalendarConfig{Name: "ca", BusinessDays: []string{"monday"}}},
+		{"invalid holiday", CalendarConfig{Name: "ca", Holidays: []string{"12/25/2021"}}},
+		{"missing file", CalendarConfig{Name: "ca", HolidayFiles: []string{filepath.Join(dir, "missing.yml")}}},
+	}
+	for _, c := range cases {
+		if err = c.cal.load(); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func TestCalendarConfig_adjust(t *testing.T) {
+	cal := CalendarConfig{Name: "ca", Timezone: "America/Montreal", Holidays: []string{"2021-10-11", "2021-12-24"}}
+	if err := cal.load(); err != nil {
+		t.Fatalf("unable to load calendar: %s", err.Error())
+	}
+
+	// from Saturday 2021-10-09 to Friday 2021-10-15, at 2am in Montreal
+	daily := Schedule{StartTime: "2021-10-09T06:00:00.000Z", EndTime: "2021-10-09T08:00:00.000Z", Repeat: Repeat{Interval: "d", Count: 7}}
+	weekly := Schedule{StartTime: "2021-10-04T06:00:00.000Z", EndTime: "2021-10-04T08:00:00.000Z", Repeat: Repeat{Interval: "w", Count: 3}}
+	// 10pm on December 24th in Montreal is already the 25th in UTC
+	evening := Schedule{StartTime: "2021-12-25T03:00:00.000Z", EndTime: "2021-12-25T04:00:00.000Z", Repeat: Repeat{Interval: "d", Count: 1}}
+
+	with := func(s Schedule, businessDays bool, onHoliday string) Schedule {
+		s.Repeat.BusinessDays = businessDays
+		s.Repeat.OnHoliday = onHoliday
+		return s
+	}
+
+	var cases = []struct {
+		name     string
+		schedule Schedule
+		want     []string
+		err      bool
+	}{
+		{"business days", with(daily, true, ""), []string{"2021-10-12T06:00:00.000Z", "2021-10-13T06:00:00.000Z", "2021-10-14T06:00:00.000Z", "2021-10-15T06:00:00.000Z"}, false},
+		{"skip holidays", with(daily, false, holidaySkip), []string{"2021-10-09T06:00:00.000Z", "2021-10-10T06:00:00.000Z", "2021-10-12T06:00:00.000Z",
+			"2021-10-13T06:00:00.000Z", "2021-10-14T06:00:00.000Z", "2021-10-15T06:00:00.000Z"}, false},
+		// the next business day already has an occurrence
+		{"shift onto an occurrence", with(daily, true, holidayShift), []string{"2021-10-12T06:00:00.000Z", "2021-10-13T06:00:00.000Z", "2021-10-14T06:00:00.000Z", "2021-10-15T06:00:00.000Z"}, false},
+		{"shift weekly", with(weekly, false, holidayShift), []string{"2021-10-04T06:00:00.000Z", "2021-10-12T06:00:00.000Z", "2021-10-18T06:00:00.000Z"}, false},
+		{"time zone", with(evening, false, holidaySkip), nil, true},
+		{"keep holidays", with(evening, false, ""), []string{"2021-12-25T03:00:00.000Z"}, false},
+	}
+
+	for _, c := range cases {
+		s, err := cal.adjust(c.schedule)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error: '%v'", c.name, err)
+			continue
+		}
+		if c.err {
+			continue
+		}
+		occurrences, _ := s.occurrences()
+		var got []string
+		for _, o := range occurrences {
+			got = append(got, o.Start)
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: wrong occurrences: got '%v' want '%v'", c.name, got, c.want)
+		}
+	}
+
+	// shifted occurrences keep their duration
+	s, _ := cal.adjust(with(weekly, false, holidayShift))
+	occurrences, _ := s.occurrences()
+	if occurrences[1].End != "2021-10-12T08:00:00.000Z" {
+		t.Errorf("wrong end of shifted occurrence: got '%s'", occurrences[1].End)
+	}
+}
+
+func TestConfig_applyCalendar(t *testing.T) {
+	conf := &Config{Calendars: []CalendarConfig{{Name: "ca", Holidays: []string{"2021-10-11"}}}}
+	if err := conf.Calendars[0].load(); err != nil {
+		t.Fatalf("unable to load calendar: %s", err.Error())
+	}
+	r := APISilenceRequest{Schedule: Schedule{StartTime: "2021-10-09T06:00:00.000Z", EndTime: "2021-10-09T08:00:00.000Z", Repeat: Repeat{Interval: "d", Count: 7}}}
+
+	var cases = []struct {
+		name    string
+		repeat  Repeat
+		exclude int
+		err     bool
+	}{
+		{"no calendar", Repeat{Interval: "d", Count: 7}, 0, false},
+		{"default business days", Repeat{Interval: "d", Count: 7, BusinessDays: true}, 2, false},
+		{"named calendar", Repeat{Interval: "d", Count: 7, BusinessDays: true, Calendar: "ca"}, 3, false},
+		{"unknown calendar", Repeat{Interval: "d", Count: 7, Calendar: "us"}, 0, true},
+		{"holidays without calendar", Repeat{Interval: "d", Count: 7, OnHoliday: holidaySkip}, 0, true},
+	}
+
+	for _, c := range cases {
+		req := r
+		req.Schedule.Repeat = c.repeat
+		got, err := conf.applyCalendar(req)
+		if (err != nil) != c.err || len(got.Schedule.Exclude) != c.exclude {
+			t.Errorf("%s: unexpected result: '%v' '%v'", c.name, got.Schedule.Exclude, err)
+		}
+	}
+}
+
+func TestApp_createSilence_calendar(t *testing.T) {
+	now := time.Now().UTC().Truncate(24 * time.Hour)
+	am := newFakeAlertmanager(now)
+	holiday := now.AddDate(0, 0, 2).Format(holidayDateLayout)
+	app := &App{config: &Config{Calendars: []CalendarConfig{{Name: "ca", BusinessDays: []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}, Holidays: []string{holiday}}}}, client: am}
+	if err := app.config.Calendars[0].load(); err != nil {
+		t.Fatalf("unable to load calendar: %s", err.Error())
+	}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	body, _ := json.Marshal(APISilenceRequest{
+		Comment:  "patching",
+		Matchers: []Matcher{{Name: "team", Value: "db"}},
+		Schedule: Schedule{
+			StartTime: now.AddDate(0, 0, 1).Add(2 * time.Hour).Format(requestTimeLayout),
+			EndTime:   now.AddDate(0, 0, 1).Add(4 * time.Hour).Format(requestTimeLayout),
+			Repeat:    Repeat{Interval: "d", Count: 3, Calendar: "ca", OnHoliday: holidaySkip},
+		},
+	})
+	req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = withIdentity(req, &Identity{Name: "carol", Token: true})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+	var resp APIResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || resp.Message != "2/2 new silences created" || len(am.active()) != 2 {
+		t.Fatalf("unexpected response: '%d' '%s'", rr.Code, resp.Message)
+	}
+
+	// the skipped occurrence is recorded on the maintenance
+	m := app.maintenanceStore().List()[0]
+	if len(m.Request.Schedule.Exclude) != 1 || !strings.HasPrefix(m.Request.Schedule.Exclude[0], holiday) {
+		t.Errorf("unexpected exclusions: '%v'", m.Request.Schedule.Exclude)
+	}
+}
+
+func TestApp_createSilence_shiftIgnored(t *testing.T) {
+	now := time.Now().UTC().Truncate(24 * time.Hour)
+	am := newFakeAlertmanager(now)
+	app := &App{config: &Config{}, client: am}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	// shifts are resolved from the calendar, the ones sent by the client are dropped
+	start := now.AddDate(0, 0, 1).Add(2 * time.Hour)
+	body, _ := json.Marshal(APISilenceRequest{
+		Comment:  "patching",
+		Matchers: []Matcher{{Name: "team", Value: "db"}},
+		Schedule: Schedule{
+			StartTime: start.Format(requestTimeLayout),
+			EndTime:   start.Add(time.Hour).Format(requestTimeLayout),
+			Repeat:    Repeat{Interval: "d", Count: 1},
+			Shift:     map[string]string{start.Format(requestTimeLayout): start.AddDate(1, 0, 0).Format(requestTimeLayout)},
+		},
+	})
+	req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = withIdentity(req, &Identity{Name: "carol", Token: true})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+	active := am.active()
+	if rr.Code != http.StatusOK || len(active) != 1 {
+		t.Fatalf("unexpected response: '%d' '%s'", rr.Code, rr.Body.String())
+	}
+	if got := time.Time(*active[0].StartsAt); !got.Equal(start) {
+		t.Errorf("occurrence should not be shifted, got start '%s' want '%s'", got, start)
+	}
+	if m := app.maintenanceStore().List()[0]; len(m.Request.Schedule.Shift) != 0 {
+		t.Errorf("unexpected shifts: '%v'", m.Request.Schedule.Shift)
+	}
+}
+
+func TestApp_importMaintenances_calendar(t *testing.T) {
+	now := time.Now().UTC().Truncate(24 * time.Hour)
+	am := newFakeAlertmanager(now)
+	start := now.AddDate(0, 0, 1).Add(2 * time.Hour)
+	holiday := start.AddDate(0, 0, 1).Format(holidayDateLayout)
+	app := App{config: &Config{Calendars: []CalendarConfig{{Name: "ca", BusinessDays: []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}, Holidays: []string{holiday}}}}, client: am}
+	if err := app.config.Calendars[0].load(); err != nil {
+		t.Fatalf("unable to load calendar: %s", err.Error())
+	}
+
+	// the second occurrence lands on a holiday of the target, it is shifted to the day after
+	body, _ := json.Marshal(Bundle{Version: bundleVersion, Maintenances: []BundledMaintenance{{
+		ID:       "shifted",
+		State:    stateScheduled,
+		Comment:  "patching",
+		Matchers: []BundledMatcher{{Name: "team", Value: "db"}},
+		Schedule: BundledSchedule{
+			StartTime: start.Format(requestTimeLayout),
+			EndTime:   start.Add(time.Hour).Format(requestTimeLayout),
+			Interval:  "d",
+			Count:     2,
+			Calendar:  "ca",
+			OnHoliday: holidayShift,
+		},
+	}}})
+	code, report := importBundle(t, &app, "application/json", body, "")
+	if code != http.StatusOK || len(report.Imported) != 1 {
+		t.Fatalf("unexpected report: '%d' '%+v'", code, report)
+	}
+	m, _ := app.maintenanceStore().Get("shifted")
+	want := start.AddDate(0, 0, 2).Format(requestTimeLayout)
+	if got := m.Request.Schedule.Shift[start.AddDate(0, 0, 1).Format(requestTimeLayout)]; got != want {
+		t.Errorf("wrong shift: got '%s' want '%s'", got, want)
+	}
+}
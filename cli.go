@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const defaultClientTimeout = 30 * time.Second
+
+var (
+	createCmd   = kingpin.Command("create", "Create a maintenance.")
+	createFlags = newMaintenanceFlags(createCmd)
+	createOpts  = newClientOptions(createCmd)
+
+	listCmd  = kingpin.Command("list", "List the silences which are not expired.")
+	listAll  = listCmd.Flag("all", "Include expired silences.").Bool()
+	listJSON = listCmd.Flag("json", "Print the silences as JSON.").Bool()
+	listOpts = newClientOptions(listCmd)
+
+	showCmd  = kingpin.Command("show", "Show a silence as JSON.")
+	showID   = showCmd.Arg("id", "ID of the silence.").Required().String()
+	showOpts = newClientOptions(showCmd)
+
+	expireCmd  = kingpin.Command("expire", "Expire silences.")
+	expireIDs  = expireCmd.Arg("id", "IDs of the silences.").Required().Strings()
+	expireOpts = newClientOptions(expireCmd)
+
+	previewCmd   = kingpin.Command("preview", "Show the occurrences of a maintenance and the firing alerts it would silence, without creating it.")
+	previewFlags = newMaintenanceFlags(previewCmd)
+	previewOpts  = newClientOptions(previewCmd)
+)
+
+// clientOptions where the commands of the command line client send their requests
+type clientOptions struct {
+	SchedulerURL    string
+	Token           string
+	AlertmanagerURL string
+}
+
+func newClientOptions(cmd *kingpin.CmdClause) *clientOptions {
+	o := &clientOptions{}
+	cmd.Flag("scheduler.url", "URL of a running scheduler.").Envar("AMS_URL").StringVar(&o.SchedulerURL)
+	cmd.Flag("scheduler.token", "API token sent to the scheduler.").Envar("AMS_TOKEN").StringVar(&o.Token)
+	cmd.Flag("alertmanager.url", "URL of Alertmanager, used directly when no scheduler URL is given.").Envar("ALERTMANAGER_URL").StringVar(&o.AlertmanagerURL)
+	return o
+}
+
+// backend returns the scheduler client when a scheduler URL is given, Alertmanager otherwise
+func (o clientOptions) backend() (clientBackend, error) {
+	if o.SchedulerURL != "" {
+		return &SchedulerClient{URL: strings.TrimSuffix(o.SchedulerURL, "/"), Token: o.Token}, nil
+	}
+	if o.AlertmanagerURL != "" {
+		return directBackend{NewAlertManagerClient(o.AlertmanagerURL)}, nil
+	}
+	return nil, fmt.Errorf("either --scheduler.url or --alertmanager.url is required")
+}
+
+// maintenanceFlags the flags describing the maintenance of the create and preview commands
+type maintenanceFlags struct {
+	Matchers  []string
+	Start     string
+	End       string
+	Duration  time.Duration
+	Comment   string
+	CreatedBy string
+	Ticket    string
+	Interval  string
+	Count     int
+}
+
+func newMaintenanceFlags(cmd *kingpin.CmdClause) *maintenanceFlags {
+	f := &maintenanceFlags{}
+	cmd.Flag("matcher", "Matcher of the alerts to silence, name=value or name=~regex (repeatable).").Short('m').Required().StringsVar(&f.Matchers)
+	cmd.Flag("start", "Start of the first occurrence (RFC 3339), defaults to now.").StringVar(&f.Start)
+	cmd.Flag("end", "End of the first occurrence (RFC 3339).").StringVar(&f.End)
+	cmd.Flag("duration", "Duration of each occurrence, when no end is given.").DurationVar(&f.Duration)
+	cmd.Flag("comment", "Comment of the silences.").Required().StringVar(&f.Comment)
+	cmd.Flag("created-by", "Creator of the silences, replaced by the identity of the token on the scheduler.").Default(os.Getenv("USER")).StringVar(&f.CreatedBy)
+	cmd.Flag("ticket", "Change ticket of the maintenance.").StringVar(&f.Ticket)
+	cmd.Flag("repeat", "Interval between two occurrences: h, d or w.").Default("d").StringVar(&f.Interval)
+	cmd.Flag("count", "Number of occurrences.").Default("1").IntVar(&f.Count)
+	return f
+}
+
+// request converts the flags into a silence request, validated like the scheduler does without its policies
+func (f maintenanceFlags) request(now time.Time) (APISilenceRequest, error) {
+	r := APISilenceRequest{
+		Comment:   f.Comment,
+		CreatedBy: f.CreatedBy,
+		Ticket:    f.Ticket,
+	}
+	for _, s := range f.Matchers {
+		m, err := parseCalendarFilter(s)
+		if err != nil {
+			return r, fmt.Errorf("invalid matcher '%s', expected name=value or name=~regex", s)
+		}
+		r.Matchers = append(r.Matchers, Matcher{Name: m.Name, Value: m.Value, IsRegex: m.IsRegex})
+	}
+
+	start := now
+	if f.Start != "" {
+		parsed, err := time.Parse(time.RFC3339, f.Start)
+		if err != nil {
+			return r, fmt.Errorf("invalid start '%s': %s", f.Start, err.Error())
+		}
+		start = parsed
+	}
+	end := start.Add(f.Duration)
+	if f.End != "" {
+		parsed, err := time.Parse(time.RFC3339, f.End)
+		if err != nil {
+			return r, fmt.Errorf("invalid end '%s': %s", f.End, err.Error())
+		}
+		end = parsed
+	}
+	if !end.After(start) {
+		return r, fmt.Errorf("the end of the maintenance must be after its start, use --end or --duration")
+	}
+
+	r.Schedule = Schedule{
+		StartTime: start.UTC().Format(requestTimeLayout),
+		EndTime:   end.UTC().Format(requestTimeLayout),
+		Repeat:    Repeat{Enabled: f.Count > 1, Interval: f.Interval, Count: f.Count},
+	}
+	msg, ok := r.Valid()
+	if !ok {
+		return r, fmt.Errorf("silence request is invalid: %s", msg)
+	}
+	return r, nil
+}
+
+// clientBackend what the command line client drives: a running scheduler, or Alertmanager directly
+type clientBackend interface {
+	ListAlerts() (models.GettableAlerts, error)
+	GetSilenceWithID(uuid string) (models.GettableSilence, error)
+	ListSilences() (models.GettableSilences, error)
+	ExpireSilenceWithID(uuid string) error
+	CreateMaintenance(r APISilenceRequest) (string, error)
+}
+
+// directBackend creates the silences of the maintenances in Alertmanager, without going through the
+// policies, approvals and audit log of a scheduler
+type directBackend struct {
+	AlertmanagerAPI
+}
+
+// CreateMaintenance creates a silence per occurrence of the request
+func (b directBackend) CreateMaintenance(r APISilenceRequest) (string, error) {
+	ids, failed := (&App{client: b.AlertmanagerAPI}).createSilences(r)
+	if failed != 0 {
+		return "", fmt.Errorf("'%d' request(s) could not be completed", failed)
+	}
+	return fmt.Sprintf("%d/%d new silences created: %s", len(ids), r.Schedule.Repeat.Count, strings.Join(ids, ", ")), nil
+}
+
+// SchedulerClient calls the API of a running scheduler, authenticated with an API token
+type SchedulerClient struct {
+	URL   string
+	Token string
+}
+
+func (sc *SchedulerClient) do(method, path string, body, out interface{}) error {
+	// changes are only accepted from browsers with a CSRF token, or from clients with an API token
+	if method != "GET" && sc.Token == "" {
+		return fmt.Errorf("an API token is required to change silences, set --scheduler.token")
+	}
+
+	var payload bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&payload).Encode(body)
+		if err != nil {
+			return fmt.Errorf("unable to marshal request: %s", err.Error())
+		}
+	}
+
+	req, err := http.NewRequest(method, sc.URL+"/api/v1/"+path, &payload)
+	if err != nil {
+		return fmt.Errorf("unable to create HTTP request: %s", err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if sc.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+sc.Token)
+	}
+
+	client := &http.Client{Timeout: defaultClientTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to get response: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var apiErr APIResponse
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("scheduler returned an HTTP error code %d: %s", resp.StatusCode, strings.TrimSpace(apiErr.Message))
+		}
+		return fmt.Errorf("scheduler returned an HTTP error code: %d", resp.StatusCode)
+	}
+	if out != nil {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			return fmt.Errorf("unable to unmarshal body: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// ListAlerts returns the alerts of the Alertmanager the scheduler is connected to
+func (sc *SchedulerClient) ListAlerts() (models.GettableAlerts, error) {
+	var alerts models.GettableAlerts
+	err := sc.do("GET", "alerts", nil, &alerts)
+	return alerts, err
+}
+
+// GetSilenceWithID returns a silence
+func (sc *SchedulerClient) GetSilenceWithID(uuid string) (models.GettableSilence, error) {
+	var silence models.GettableSilence
+	err := sc.do("GET", "silence/"+url.PathEscape(uuid), nil, &silence)
+	return silence, err
+}
+
+// ListSilences returns every silence
+func (sc *SchedulerClient) ListSilences() (models.GettableSilences, error) {
+	var silences models.GettableSilences
+	err := sc.do("GET", "silences", nil, &silences)
+	return silences, err
+}
+
+// ExpireSilenceWithID expires a silence, within the limits of the token
+func (sc *SchedulerClient) ExpireSilenceWithID(uuid string) error {
+	return sc.do("DELETE", "silence/"+url.PathEscape(uuid), nil, nil)
+}
+
+// CreateMaintenance submits the request like the web form does, it may await an approval
+func (sc *SchedulerClient) CreateMaintenance(r APISilenceRequest) (string, error) {
+	var resp APIResponse
+	err := sc.do("POST", "silence", r, &resp)
+	return resp.Message, err
+}
+
+// runClient runs a command of the command line client, it returns the exit code of the process
+func runClient(command string) int {
+	var opts *clientOptions
+	switch command {
+	case createCmd.FullCommand():
+		opts = createOpts
+	case listCmd.FullCommand():
+		opts = listOpts
+	case showCmd.FullCommand():
+		opts = showOpts
+	case expireCmd.FullCommand():
+		opts = expireOpts
+	case previewCmd.FullCommand():
+		opts = previewOpts
+	}
+
+	b, err := opts.backend()
+	if err == nil {
+		err = clientCommand(command, b, os.Stdout, time.Now())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return genericError
+	}
+	return 0
+}
+
+// clientCommand runs a command against the backend, writing its output to out
+func clientCommand(command string, b clientBackend, out io.Writer, now time.Time) error {
+	switch command {
+	case createCmd.FullCommand():
+		r, err := createFlags.request(now)
+		if err != nil {
+			return err
+		}
+		msg, err := b.CreateMaintenance(r)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, msg)
+	case listCmd.FullCommand():
+		return listSilences(b, *listAll, *listJSON, out)
+	case showCmd.FullCommand():
+		s, err := b.GetSilenceWithID(*showID)
+		if err != nil {
+			return err
+		}
+		return printJSON(out, s)
+	case expireCmd.FullCommand():
+		for _, id := range *expireIDs {
+			err := b.ExpireSilenceWithID(id)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "expired silence with ID: %s\n", id)
+		}
+	case previewCmd.FullCommand():
+		r, err := previewFlags.request(now)
+		if err != nil {
+			return err
+		}
+		return preview(b, r, out)
+	default:
+		return fmt.Errorf("unknown command '%s'", command)
+	}
+	return nil
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(b))
+	return err
+}
+
+// listSilences prints the silences as a table sorted by start, expired ones only when all is set
+func listSilences(b clientBackend, all, asJSON bool, out io.Writer) error {
+	silences, err := b.ListSilences()
+	if err != nil {
+		return fmt.Errorf("unable to retrieve silences: %s", err.Error())
+	}
+	if !all {
+		silences = FilterExpired(silences)
+	}
+	sort.SliceStable(silences, func(i, j int) bool {
+		return time.Time(*silences[i].StartsAt).Before(time.Time(*silences[j].StartsAt))
+	})
+	if asJSON {
+		return printJSON(out, silences)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tSTARTS\tENDS\tCREATED BY\tCOMMENT\tMATCHERS")
+	for _, s := range silences {
+		state := ""
+		if s.Status != nil && s.Status.State != nil {
+			state = *s.Status.State
+		}
+		var matchers []string
+		for _, m := range matchersFromModel(s.Matchers) {
+			matchers = append(matchers, m.String())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", *s.ID, state,
+			time.Time(*s.StartsAt).UTC().Format(time.RFC3339), time.Time(*s.EndsAt).UTC().Format(time.RFC3339),
+			*s.CreatedBy, *s.Comment, strings.Join(matchers, ","))
+	}
+	return w.Flush()
+}
+
+// preview prints the occurrences of the request and the firing alerts its matchers select
+func preview(b clientBackend, r APISilenceRequest, out io.Writer) error {
+	occurrences, err := r.Schedule.occurrences()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d occurrence(s):\n", len(occurrences))
+	for _, o := range occurrences {
+		fmt.Fprintf(out, "  %s -> %s\n", o.Start, o.End)
+	}
+
+	alerts, err := b.ListAlerts()
+	if err != nil {
+		return fmt.Errorf("unable to retrieve alerts: %s", err.Error())
+	}
+	var matched []string
+	for _, alert := range alerts {
+		if alert == nil || !selectsAlert(r.Matchers, alert.Labels) {
+			continue
+		}
+		var labels []string
+		for name, value := range alert.Labels {
+			labels = append(labels, Matcher{Name: name, Value: value}.String())
+		}
+		sort.Strings(labels)
+		matched = append(matched, "{"+strings.Join(labels, ", ")+"}")
+	}
+	sort.Strings(matched)
+	fmt.Fprintf(out, "%d alert(s) currently matched:\n", len(matched))
+	for _, m := range matched {
+		fmt.Fprintf(out, "  %s\n", m)
+	}
+	return nil
+}
+
+// selectsAlert returns true if every matcher accepts the labels, a missing label having an empty value
+func selectsAlert(matchers []Matcher, labels models.LabelSet) bool {
+	for _, m := range matchers {
+		if !m.accepts(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestMaintenanceFlags_request(t *testing.T) {
+	now := time.Date(2021, 10, 12, 12, 0, 0, 0, time.UTC)
+	base := maintenanceFlags{Matchers: []string{"team=db", `instance=~db-.*`}, Comment: "patching", CreatedBy: "alice", Interval: "d", Count: 1}
+
+	var cases = []struct {
+		name  string
+		edit  func(f *maintenanceFlags)
+		start string
+		end   string
+		err   bool
+	}{
+		{"duration from now", func(f *maintenanceFlags) { f.Duration = time.Hour }, "2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", false},
+		{"start and end", func(f *maintenanceFlags) {
+			f.Start, f.End = "2021-10-13T02:00:00-04:00", "2021-10-13T04:00:00-04:00"
+		}, "2021-10-13T06:00:00.000Z", "2021-10-13T08:00:00.000Z", false},
+		{"no end", func(f *maintenanceFlags) {}, "", "", true},
+		{"invalid matcher", func(f *maintenanceFlags) { f.Duration, f.Matchers = time.Hour, []string{"team"} }, "", "", true},
+		{"invalid start", func(f *maintenanceFlags) { f.Duration, f.Start = time.Hour, "tomorrow" }, "", "", true},
+		{"too many occurrences", func(f *maintenanceFlags) { f.Duration, f.Count = time.Hour, 100 }, "", "", true},
+	}
+
+	for _, c := range cases {
+		f := base
+		c.edit(&f)
+		r, err := f.request(now)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err.Error())
+			continue
+		}
+		if r.Schedule.StartTime != c.start || r.Schedule.EndTime != c.end {
+			t.Errorf("%s: wrong schedule: got '%s' '%s' want '%s' '%s'", c.name, r.Schedule.StartTime, r.Schedule.EndTime, c.start, c.end)
+		}
+		if len(r.Matchers) != 2 || !r.Matchers[1].IsRegex || r.Matchers[1].Value != "db-.*" {
+			t.Errorf("%s: wrong matchers: '%v'", c.name, r.Matchers)
+		}
+	}
+}
+
+// fakeAlertsAlertmanager returns firing alerts on top of the silences of fakeAlertmanager
+type fakeAlertsAlertmanager struct {
+	*fakeAlertmanager
+	firing models.GettableAlerts
+}
+
+func (f fakeAlertsAlertmanager) ListAlerts() (models.GettableAlerts, error) {
+	return f.firing, nil
+}
+
+func TestClientCommand(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	am := fakeAlertsAlertmanager{fakeAlertmanager: newFakeAlertmanager(now), firing: models.GettableAlerts{
+		{Alert: models.Alert{Labels: models.LabelSet{"alertname": "Down", "team": "db"}}},
+		{Alert: models.Alert{Labels: models.LabelSet{"alertname": "Down", "team": "net"}}},
+	}}
+	b := directBackend{am}
+
+	run := func(args ...string) string {
+		command, err := kingpin.CommandLine.Parse(args)
+		if err != nil {
+			t.Fatalf("unable to parse '%v': %s", args, err.Error())
+		}
+		var out bytes.Buffer
+		err = clientCommand(command, b, &out, now)
+		if err != nil {
+			t.Fatalf("unexpected error running '%v': %s", args, err.Error())
+		}
+		return out.String()
+	}
+
+	out := run("create", "-m", "team=db", "--comment", "patching", "--created-by", "alice", "--duration", "1h", "--count", "2")
+	if !strings.HasPrefix(out, "2/2 new silences created") || len(am.active()) != 2 {
+		t.Errorf("unexpected create output: '%s'", out)
+	}
+
+	out = run("list")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 3 || !strings.Contains(lines[1], `team="db"`) {
+		t.Errorf("unexpected list output: '%s'", out)
+	}
+
+	out = run("show", "s1")
+	var shown models.GettableSilence
+	if err := json.Unmarshal([]byte(out), &shown); err != nil || *shown.ID != "s1" {
+		t.Errorf("unexpected show output: '%s'", out)
+	}
+
+	out = run("expire", "s1", "s2")
+	if len(am.active()) != 0 || strings.Count(out, "expired silence") != 2 {
+		t.Errorf("unexpected expire output: '%s'", out)
+	}
+
+	out = run("preview", "-m", "team=~db|web", "--comment", "patching", "--created-by", "alice", "--duration", "2h", "--count", "3", "--repeat", "w")
+	if !strings.Contains(out, "3 occurrence(s)") || !strings.Contains(out, `1 alert(s) currently matched:
+  {alertname="Down", team="db"}`) {
+		t.Errorf("unexpected preview output: '%s'", out)
+	}
+	if len(am.active()) != 0 {
+		t.Errorf("preview should not create silences")
+	}
+}
+
+func TestSchedulerClient(t *testing.T) {
+	var created APISilenceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ams_token" {
+			writeErrorWithCode("missing or invalid API token", http.StatusUnauthorized, w)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v1/silence":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(APIResponse{Status: "success", Message: "maintenance 'm1' is awaiting approval"})
+		case r.Method == "DELETE" && r.URL.Path == "/api/v1/silence/s1":
+			writeErrorWithCode("token cannot expire silence 's1'", http.StatusForbidden, w)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sc := &SchedulerClient{URL: server.URL, Token: "ams_token"}
+	msg, err := sc.CreateMaintenance(APISilenceRequest{Comment: "patching"})
+	if err != nil || msg != "maintenance 'm1' is awaiting approval" || created.Comment != "patching" {
+		t.Errorf("unexpected create result: '%s' '%v' '%+v'", msg, err, created)
+	}
+
+	err = sc.ExpireSilenceWithID("s1")
+	if err == nil || !strings.Contains(err.Error(), "token cannot expire silence 's1'") {
+		t.Errorf("expected the error of the scheduler, got '%v'", err)
+	}
+
+	sc.Token = ""
+	_, err = sc.ListSilences()
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected an unauthorized error, got '%v'", err)
+	}
+	// changes are not even sent without a token
+	created = APISilenceRequest{}
+	_, err = sc.CreateMaintenance(APISilenceRequest{Comment: "patching"})
+	if err == nil || !strings.Contains(err.Error(), "--scheduler.token") || created.Comment != "" {
+		t.Errorf("expected the token to be required, got '%v'", err)
+	}
+}
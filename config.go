@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 
 	"gopkg.in/yaml.v2"
@@ -10,7 +12,43 @@ import (
 
 // Config the configuration of the application
 type Config struct {
-	AlertmanagerURL string `yaml:"alertmanager_url"`
+	AlertmanagerURL   string                  `yaml:"alertmanager_url"`
+	Session           SessionConfig           `yaml:"session"`
+	OIDC              *OIDCConfig             `yaml:"oidc"`
+	APITokens         []APIToken              `yaml:"api_tokens"`
+	RBAC              RBACConfig              `yaml:"rbac"`
+	Policy            PolicyConfig            `yaml:"policy"`
+	Approval          ApprovalConfig          `yaml:"approval"`
+	Storage           StorageConfig           `yaml:"storage"`
+	Ticket            TicketConfig            `yaml:"ticket"`
+	Audit             AuditConfig             `yaml:"audit"`
+	Declarative       DeclarativeConfig       `yaml:"declarative"`
+	Drift             DriftConfig             `yaml:"drift"`
+	Notify            NotifyConfig            `yaml:"notify"`
+	Grafana           GrafanaConfig           `yaml:"grafana"`
+	MaintenanceAlerts MaintenanceAlertsConfig `yaml:"maintenance_alerts"`
+	Kubernetes        KubernetesConfig        `yaml:"kubernetes"`
+	Overlap           OverlapConfig           `yaml:"overlap"`
+	Freeze            FreezeConfig            `yaml:"freeze"`
+	Calendars         []CalendarConfig        `yaml:"calendars"`
+}
+
+// SessionConfig keys used to sign and encrypt the session cookie
+type SessionConfig struct {
+	AuthKey       string `yaml:"auth_key"`
+	EncryptionKey string `yaml:"encryption_key"`
+	Secure        bool   `yaml:"secure"`
+}
+
+// OIDCConfig settings of the OpenID Connect provider used to log users in
+type OIDCConfig struct {
+	IssuerURL     string   `yaml:"issuer_url"`
+	ClientID      string   `yaml:"client_id"`
+	ClientSecret  string   `yaml:"client_secret"`
+	RedirectURL   string   `yaml:"redirect_url"`
+	Scopes        []string `yaml:"scopes"`
+	UsernameClaim string   `yaml:"username_claim"`
+	GroupsClaim   string   `yaml:"groups_claim"`
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -31,7 +69,162 @@ func loadConfig(path string) (*Config, error) {
 		conf.AlertmanagerURL = envURL
 	}
 
+	envSecret := os.Getenv("OIDC_CLIENT_SECRET")
+	if envSecret != "" && conf.OIDC != nil {
+		conf.OIDC.ClientSecret = envSecret
+	}
+
+	envGrafanaKey := os.Getenv("GRAFANA_API_KEY")
+	if envGrafanaKey != "" {
+		conf.Grafana.APIKey = envGrafanaKey
+	}
+
+	err := conf.validate()
+	if err != nil {
+		return nil, err
+	}
+
 	log.Printf("Config loaded, path: %s", path)
 
 	return conf, nil
 }
+
+// validate checks the config can be used to build a working application
+func (c *Config) validate() error {
+	if c.AlertmanagerURL == "" {
+		return fmt.Errorf("alertmanager_url is mandatory")
+	}
+
+	u, err := url.Parse(c.AlertmanagerURL)
+	if err != nil {
+		return fmt.Errorf("invalid alertmanager_url: %s", err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid alertmanager_url '%s': scheme and host are required", c.AlertmanagerURL)
+	}
+
+	err = c.Session.validate()
+	if err != nil {
+		return err
+	}
+
+	if c.OIDC != nil {
+		if c.Session.AuthKey == "" || c.Session.EncryptionKey == "" {
+			return fmt.Errorf("session auth_key and encryption_key are mandatory when oidc is enabled")
+		}
+		err = c.OIDC.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	names := map[string]bool{}
+	for _, t := range c.APITokens {
+		err = t.validate()
+		if err != nil {
+			return err
+		}
+		if names[t.Name] {
+			return fmt.Errorf("api token '%s' is defined more than once", t.Name)
+		}
+		names[t.Name] = true
+	}
+
+	err = c.RBAC.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Policy.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Approval.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Ticket.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Declarative.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Notify.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Grafana.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.MaintenanceAlerts.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Kubernetes.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Overlap.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Freeze.validate(c.RBAC)
+	if err != nil {
+		return err
+	}
+
+	calendars := map[string]bool{}
+	for i := range c.Calendars {
+		err = c.Calendars[i].load()
+		if err != nil {
+			return err
+		}
+		if calendars[c.Calendars[i].Name] {
+			return fmt.Errorf("calendar '%s' is defined more than once", c.Calendars[i].Name)
+		}
+		calendars[c.Calendars[i].Name] = true
+	}
+	return nil
+}
+
+func (s SessionConfig) validate() error {
+	if s.AuthKey != "" && len(s.AuthKey) < 32 {
+		return fmt.Errorf("session auth_key must be at least 32 bytes long")
+	}
+
+	switch len(s.EncryptionKey) {
+	case 0, 16, 24, 32:
+	default:
+		return fmt.Errorf("session encryption_key must be 16, 24 or 32 bytes long")
+	}
+
+	if s.EncryptionKey != "" && s.AuthKey == "" {
+		return fmt.Errorf("session auth_key is mandatory when encryption_key is set")
+	}
+	return nil
+}
+
+func (o *OIDCConfig) validate() error {
+	if o.IssuerURL == "" {
+		return fmt.Errorf("oidc issuer_url is mandatory")
+	}
+	if o.ClientID == "" {
+		return fmt.Errorf("oidc client_id is mandatory")
+	}
+	if o.RedirectURL == "" {
+		return fmt.Errorf("oidc redirect_url is mandatory")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeTempConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "ams-config-*.yml")
+	if err != nil {
+		t.Fatalf("unable to create temp config: %s", err.Error())
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(content)
+	if err != nil {
+		t.Fatalf("unable to write temp config: %s", err.Error())
+	}
+	return f.Name()
+}
+
+func TestConfig_validate(t *testing.T) {
+	var cases = []struct {
+		url  string
+		want bool
+	}{
+		{"http://localhost:9093/", validationSuccess},
+		{"", validationError},
+		{"localhost:9093", validationError},
+		{"://localhost", validationError},
+	}
+
+	for _, c := range cases {
+		err := (&Config{AlertmanagerURL: c.url}).validate()
+		if got := err == nil; got != c.want {
+			t.Errorf("config validation not returning expected result for => '%s': %v", c.url, err)
+		}
+	}
+}
+
+func TestApp_reloadConfig(t *testing.T) {
+	path := writeTempConfig(t, `alertmanager_url: "http://first:9093/"`)
+	defer os.Remove(path)
+
+	app := &App{}
+	err := app.reloadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error on initial load: '%s'", err.Error())
+	}
+	if got := app.conf().AlertmanagerURL; got != "http://first:9093/" {
+		t.Errorf("unexpected Alertmanager URL after load\ngot: '%s'\nwant: '%s'", got, "http://first:9093/")
+	}
+
+	err = ioutil.WriteFile(path, []byte("alertmanager_url: \"http://second:9093/\"\nmaintenance_alerts:\n  enabled: true"), 0644)
+	if err != nil {
+		t.Fatalf("unable to rewrite temp config: %s", err.Error())
+	}
+	err = app.reloadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error on reload: '%s'", err.Error())
+	}
+	client := app.amClient().(*AlertmanagerClient)
+	if client.AlertManagerAPIURL != NewAlertManagerClient("http://second:9093/").AlertManagerAPIURL {
+		t.Errorf("client was not swapped on reload, got URL '%s'", client.AlertManagerAPIURL)
+	}
+	if client.ExcludedAlertName != defaultMaintenanceAlertName {
+		t.Errorf("silences should exclude the maintenance alerts, got '%s'", client.ExcludedAlertName)
+	}
+
+	err = ioutil.WriteFile(path, []byte(`alertmanager_url: [not valid`), 0644)
+	if err != nil {
+		t.Fatalf("unable to rewrite temp config: %s", err.Error())
+	}
+	err = app.reloadConfig(path)
+	if err == nil {
+		t.Errorf("expected an error reloading an invalid config")
+	}
+	if got := app.conf().AlertmanagerURL; got != "http://second:9093/" {
+		t.Errorf("failed reload replaced the config\ngot: '%s'\nwant: '%s'", got, "http://second:9093/")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	sessionCSRFKey = "csrf_token"
+)
+
+// csrfToken returns the CSRF token bound to the session, creating it if needed
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	// an undecodable cookie (eg: after a key rotation) is replaced by a new session
+	session, _ := getSession(r)
+	token, ok := session.Values[sessionCSRFKey].(string)
+	if ok && token != "" {
+		return token, nil
+	}
+
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	session.Values[sessionCSRFKey] = token
+	err = session.Save(r, w)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// validCSRF checks the token sent with a form or as a header matches the one bound to the session,
+// requests authenticated with an API token are exempt since browsers never send them on their own
+func validCSRF(r *http.Request) bool {
+	if id, ok := identityFromContext(r.Context()); ok && id.Token {
+		return true
+	}
+
+	session, err := getSession(r)
+	if err != nil {
+		return false
+	}
+	expected, ok := session.Values[sessionCSRFKey].(string)
+	if !ok || expected == "" {
+		return false
+	}
+
+	got := r.Header.Get(csrfHeaderName)
+	if got == "" && r.ParseForm() == nil {
+		got = r.PostForm.Get(csrfFieldName)
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(expected)) == 1
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/mock"
+)
+
+// newCSRFSession returns a CSRF token and the session cookies it is bound to
+func newCSRFSession(t *testing.T) (string, []*http.Cookie) {
+	rr := httptest.NewRecorder()
+	token, err := csrfToken(rr, httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("unable to create CSRF token: %s", err.Error())
+	}
+	return token, rr.Result().Cookies()
+}
+
+func TestValidCSRF(t *testing.T) {
+	token, cookies := newCSRFSession(t)
+
+	var cases = []struct {
+		name    string
+		form    string
+		header  string
+		cookies []*http.Cookie
+		id      *Identity
+		want    bool
+	}{
+		{"form token", token, "", cookies, nil, true},
+		{"header token", "", token, cookies, nil, true},
+		{"wrong token", "forged", "", cookies, nil, false},
+		{"missing token", "", "", cookies, nil, false},
+		{"no session", token, "", nil, nil, false},
+		{"api token", "", "", nil, &Identity{Name: "ci", Token: true}, true},
+	}
+
+	for _, c := range cases {
+		form := url.Values{}
+		if c.form != "" {
+			form.Add(csrfFieldName, c.form)
+		}
+		req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBufferString(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if c.header != "" {
+			req.Header.Set(csrfHeaderName, c.header)
+		}
+		for _, cookie := range c.cookies {
+			req.AddCookie(cookie)
+		}
+		if c.id != nil {
+			req = withIdentity(req, c.id)
+		}
+
+		if got := validCSRF(req); got != c.want {
+			t.Errorf("CSRF validation not returning expected result for '%s': got '%v' want '%v'", c.name, got, c.want)
+		}
+	}
+}
+
+func TestApp_createSilence_csrf(t *testing.T) {
+	client := MockAlertManagerClient{}
+	client.On("CreateSilenceWith",
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("APISilenceRequest")).Return("1234", nil)
+	client.On("ListSilences").Return(models.GettableSilences{}, nil)
+	app := App{
+		config: &Config{},
+		client: &client,
+	}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	_, cookies := newCSRFSession(t)
+
+	form := url.Values{}
+	form.Add("csrf_token", "forged")
+	form.Add("Comment", "test")
+	form.Add("CreatedBy", "test")
+	form.Add("Matchers.0.Name", "job")
+	form.Add("Matchers.0.Value", "MockApp")
+	form.Add("Schedule.StartTime", "2020-11-01T22:12:33.533Z")
+	form.Add("Schedule.EndTime", "2020-11-01T23:11:44.603Z")
+	form.Add("Schedule.Repeat.Count", "1")
+	form.Add("Schedule.Repeat.Interval", "h")
+
+	req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBufferString(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+	client.AssertNotCalled(t, "CreateSilenceWith", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApp_updateSilence_csrf(t *testing.T) {
+	client := MockAlertManagerClient{}
+	app := App{
+		config: &Config{},
+		client: &client,
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/silence/1234", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1234"})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.updateSilence).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusForbidden)
+	}
+	client.AssertNotCalled(t, "ExpireSilenceWithID", mock.Anything)
+}
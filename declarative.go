@@ -0,0 +1,441 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// managedTagPrefix tags the comment of silences managed by a declared maintenance
+	managedTagPrefix = "[ams-managed:"
+
+	defaultDeclarativeInterval = time.Minute
+	declarativeWatchInterval   = 10 * time.Second
+	declarativeActor           = "declarative"
+)
+
+var declaredNameReg = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// DeclarativeConfig directory of YAML maintenance definitions reconciled against Alertmanager
+type DeclarativeConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (c DeclarativeConfig) validate() error {
+	if c.Path == "" {
+		return nil
+	}
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return fmt.Errorf("invalid declarative path: %s", err.Error())
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("declarative path '%s' is not a directory", c.Path)
+	}
+	return nil
+}
+
+func (c DeclarativeConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultDeclarativeInterval
+	}
+	return c.Interval
+}
+
+// DeclarationFile content of a YAML file of the declarative directory
+type DeclarationFile struct {
+	Maintenances []DeclaredMaintenance `yaml:"maintenances"`
+}
+
+// DeclaredMaintenance a maintenance defined in a YAML file
+type DeclaredMaintenance struct {
+	Name     string            `yaml:"name"`
+	Owner    string            `yaml:"owner"`
+	Comment  string            `yaml:"comment"`
+	Ticket   string            `yaml:"ticket"`
+	Matchers []DeclaredMatcher `yaml:"matchers"`
+	Schedule DeclaredSchedule  `yaml:"schedule"`
+
+	file string
+}
+
+// DeclaredMatcher a matcher of a declared maintenance
+type DeclaredMatcher struct {
+	Name    string `yaml:"name"`
+	Value   string `yaml:"value"`
+	IsRegex bool   `yaml:"is_regex"`
+}
+
+// DeclaredSchedule the recurrence of a declared maintenance
+type DeclaredSchedule struct {
+	Start  time.Time `yaml:"start"`
+	End    time.Time `yaml:"end"`
+	Repeat Repeat    `yaml:"repeat"`
+}
+
+// request converts the declaration into the silence request it stands for
+func (d DeclaredMaintenance) request() APISilenceRequest {
+	var matchers []Matcher
+	for _, m := range d.Matchers {
+		matchers = append(matchers, Matcher{Name: m.Name, Value: m.Value, IsRegex: m.IsRegex})
+	}
+
+	repeat := d.Schedule.Repeat
+	if repeat.Count == 0 {
+		repeat.Count = 1
+	}
+	return APISilenceRequest{
+		ID:        d.Name,
+		Comment:   d.Comment,
+		CreatedBy: d.Owner,
+		Matchers:  matchers,
+		Ticket:    d.Ticket,
+		Managed:   d.Name,
+		Schedule: Schedule{
+			StartTime: d.Schedule.Start.UTC().Format(requestTimeLayout),
+			EndTime:   d.Schedule.End.UTC().Format(requestTimeLayout),
+			Repeat:    repeat,
+		},
+	}
+}
+
+// declarationFiles returns the YAML files of the directory, sorted by name
+func declarationFiles(dir string) ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read declarative directory: %s", err.Error())
+	}
+
+	var files []os.FileInfo
+	for _, info := range infos {
+		ext := filepath.Ext(info.Name())
+		if info.IsDir() || (ext != ".yml" && ext != ".yaml") {
+			continue
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// loadDeclarations reads every maintenance declared in the directory, nothing is returned if a
+// single file cannot be read so that a broken file never gets its silences expired
+func loadDeclarations(dir string) ([]DeclaredMaintenance, error) {
+	files, err := declarationFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []DeclaredMaintenance
+	declaredIn := map[string]string{}
+	for _, info := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read '%s': %s", info.Name(), err.Error())
+		}
+
+		var f DeclarationFile
+		err = yaml.UnmarshalStrict(b, &f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse '%s': %s", info.Name(), err.Error())
+		}
+
+		for _, d := range f.Maintenances {
+			if !declaredNameReg.MatchString(d.Name) || strings.HasPrefix(d.Name, kubernetesManagedPrefix) {
+				return nil, fmt.Errorf("invalid maintenance name '%s' in '%s'", d.Name, info.Name())
+			}
+			if other, ok := declaredIn[d.Name]; ok {
+				return nil, fmt.Errorf("maintenance '%s' is declared in both '%s' and '%s'", d.Name, other, info.Name())
+			}
+			declaredIn[d.Name] = info.Name()
+			d.file = info.Name()
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// declarationsFingerprint summarizes the files of the directory, it changes whenever a file is
+// added, removed or modified
+func declarationsFingerprint(dir string) (string, error) {
+	files, err := declarationFiles(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, info := range files {
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", info.Name(), info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(parts, "|"), nil
+}
+
+// managedName returns the name of the declared maintenance managing a silence, from its comment
+func managedName(comment string) (string, bool) {
+	i := strings.LastIndex(comment, managedTagPrefix)
+	if i < 0 || !strings.HasSuffix(comment, "]") {
+		return "", false
+	}
+	name := comment[i+len(managedTagPrefix) : len(comment)-1]
+	if !declaredNameReg.MatchString(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// managedSilences returns the silences which are not expired yet, grouped by the declared maintenance managing them
+func managedSilences(silences models.GettableSilences) map[string][]*models.GettableSilence {
+	out := map[string][]*models.GettableSilence{}
+	for _, s := range silences {
+		if s == nil || s.Comment == nil || s.ID == nil {
+			continue
+		}
+		if s.Status != nil && s.Status.State != nil && *s.Status.State == models.SilenceStatusStateExpired {
+			continue
+		}
+		if name, ok := managedName(*s.Comment); ok {
+			out[name] = append(out[name], s)
+		}
+	}
+	return out
+}
+
+// matchesOccurrence returns true if the silence already implements the occurrence of the request,
+// the start of silences already active is ignored as Alertmanager moves it to their creation time
+func matchesOccurrence(s *models.GettableSilence, o occurrence, r APISilenceRequest, now time.Time) bool {
+	if s.Comment == nil || *s.Comment != r.silenceComment() {
+		return false
+	}
+	if s.CreatedBy == nil || *s.CreatedBy != r.CreatedBy {
+		return false
+	}
+
+	start, err := time.Parse(requestTimeLayout, o.Start)
+	if err != nil {
+		return false
+	}
+	if start.After(now) && (s.StartsAt == nil || !time.Time(*s.StartsAt).Equal(start)) {
+		return false
+	}
+
+	return sameMatchers(matchersFromModel(s.Matchers), r.Matchers)
+}
+
+// ReconcileResult what a reconciliation of declared maintenances changed in Alertmanager
+type ReconcileResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Expired int `json:"expired"`
+	Errors  int `json:"errors"`
+}
+
+// declarationRules returns the rules applied to declared maintenances, no caller can override them:
+// a declaration touched by a freeze is invalid and keeps its silences untouched until the freeze is over
+func (a *App) declarationRules(now time.Time) []ValidationRule {
+	return []ValidationRule{
+		a.conf().Policy.rule(access{}, nil),
+		a.conf().Freeze.rule(access{}, now, nil),
+		a.conf().Ticket.rule(),
+	}
+}
+
+// reconcileDeclarations creates the missing silences of the declared maintenances, updates the ones
+// which changed and expires the ones no longer declared. Silences without the managed tag are never touched.
+func (a *App) reconcileDeclarations(dir string, now time.Time) (ReconcileResult, error) {
+	var res ReconcileResult
+
+	declarations, err := loadDeclarations(dir)
+	if err != nil {
+		return res, err
+	}
+
+	silences, err := a.amClient().ListSilences()
+	if err != nil {
+		return res, fmt.Errorf("unable to retrieve silences: %s", err.Error())
+	}
+	managed := managedSilences(silences)
+
+	// declarations cannot go through the approval flow, unless explicitly exempted the ones needing
+	// an approval are invalid
+	rules := a.declarationRules(now)
+	if c := a.conf().Approval; !c.ExemptDeclarative {
+		rules = append(rules, c.rule("declared maintenances"))
+	}
+
+	valid := map[string]APISilenceRequest{}
+	for _, d := range declarations {
+		// an invalid declaration keeps its silences untouched until it is fixed
+		err = a.reconcileDeclared(d, rules, managed[d.Name], now, &res)
+		if err != nil {
+			log.Println(err)
+			res.Errors++
+		} else {
+			valid[d.Name], _ = a.conf().applyCalendar(d.request())
+		}
+		delete(managed, d.Name)
+	}
+	a.setManagedWindows(declarativeActor, valid)
+
+	// maintenances no longer declared, the ones of MaintenanceWindow resources are left to the controller
+	for name, list := range managed {
+		if strings.HasPrefix(name, kubernetesManagedPrefix) {
+			continue
+		}
+		for _, s := range list {
+			res.add(a.expireDeclared(name, s))
+		}
+	}
+	return res, nil
+}
+
+// reconcileDeclared brings the existing silences of a declared maintenance in line with its occurrences,
+// it returns an error without changing anything if the declaration is invalid or breaks one of the rules
+func (a *App) reconcileDeclared(d DeclaredMaintenance, rules []ValidationRule, existing []*models.GettableSilence, now time.Time, res *ReconcileResult) error {
+	r, err := a.conf().applyCalendar(d.request())
+	if err != nil {
+		return fmt.Errorf("declared maintenance '%s' in '%s' is invalid: %s", d.Name, d.file, err.Error())
+	}
+	msg, ok := r.Valid(rules...)
+	if !ok {
+		return fmt.Errorf("declared maintenance '%s' in '%s' is invalid: %s", d.Name, d.file, msg)
+	}
+	occurrences, err := r.Schedule.occurrences()
+	if err != nil {
+		return fmt.Errorf("declared maintenance '%s' in '%s' is invalid: %s", d.Name, d.file, err.Error())
+	}
+
+	// silences are paired with occurrences by their end, which Alertmanager never changes
+	byEnd := map[string]*models.GettableSilence{}
+	for _, s := range existing {
+		if s.EndsAt != nil {
+			byEnd[time.Time(*s.EndsAt).UTC().Format(requestTimeLayout)] = s
+		}
+	}
+
+	for _, o := range occurrences {
+		end, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil || !end.After(now) {
+			continue
+		}
+
+		s, found := byEnd[o.End]
+		delete(byEnd, o.End)
+		if found && matchesOccurrence(s, o, r, now) {
+			continue
+		}
+
+		if found {
+			id, err := a.amClient().UpdateSilenceWith(*s.ID, o.Start, o.End, r)
+			if err != nil {
+				log.Printf("unable to update silence '%s' of declared maintenance '%s': %s\n", *s.ID, d.Name, err.Error())
+				res.Errors++
+				continue
+			}
+			a.recordDeclared(actionUpdate, d.Name, []string{*s.ID, id}, s, r)
+			res.Updated++
+			continue
+		}
+
+		id, err := a.amClient().CreateSilenceWith(o.Start, o.End, r)
+		if err != nil {
+			log.Printf("unable to create silence of declared maintenance '%s': %s\n", d.Name, err.Error())
+			res.Errors++
+			continue
+		}
+		a.recordDeclared(actionCreate, d.Name, []string{id}, nil, r)
+		res.Created++
+	}
+
+	// occurrences which were moved or dropped from the schedule
+	for _, s := range byEnd {
+		res.add(a.expireDeclared(d.Name, s))
+	}
+	return nil
+}
+
+func (r *ReconcileResult) add(err error) {
+	if err != nil {
+		log.Println(err)
+		r.Errors++
+		return
+	}
+	r.Expired++
+}
+
+func (a *App) expireDeclared(name string, s *models.GettableSilence) error {
+	err := a.amClient().ExpireSilenceWithID(*s.ID)
+	if err != nil {
+		return fmt.Errorf("unable to expire silence '%s' of declared maintenance '%s': %s", *s.ID, name, err.Error())
+	}
+	a.recordDeclared(actionExpire, name, []string{*s.ID}, s, APISilenceRequest{})
+	return nil
+}
+
+// managedBy returns the reconciler managing the silences of the declared maintenance
+func managedBy(name string) string {
+	if strings.HasPrefix(name, kubernetesManagedPrefix) {
+		return kubernetesActor
+	}
+	return declarativeActor
+}
+
+// recordDeclared records a change made by the reconciliation of a declared maintenance in the audit log
+func (a *App) recordDeclared(action, name string, silenceIDs []string, before *models.GettableSilence, after APISilenceRequest) {
+	e := AuditEntry{
+		Action:     action,
+		Actor:      managedBy(name),
+		SilenceIDs: silenceIDs,
+		Details:    fmt.Sprintf("declared maintenance '%s'", name),
+	}
+	if before != nil {
+		e.Before = auditState(before)
+	}
+	if after.Managed != "" {
+		e.After = auditState(after)
+	}
+	a.record(e)
+}
+
+// watchDeclarations reconciles the declared maintenances whenever the directory changes,
+// and at least once per configured interval
+func (a *App) watchDeclarations(tick time.Duration) {
+	var last string
+	var lastRun time.Time
+
+	for now := range time.Tick(tick) {
+		c := a.conf().Declarative
+		if c.Path == "" {
+			a.setManagedWindows(declarativeActor, nil)
+			continue
+		}
+
+		fingerprint, err := declarationsFingerprint(c.Path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if fingerprint == last && now.Sub(lastRun) < c.interval() {
+			continue
+		}
+
+		res, err := a.reconcileDeclarations(c.Path, now)
+		if err != nil {
+			log.Printf("unable to reconcile declared maintenances: %s\n", err.Error())
+			continue
+		}
+		last, lastRun = fingerprint, now
+		if res != (ReconcileResult{}) {
+			log.Printf("declared maintenances reconciled: %d created, %d updated, %d expired, %d errors\n",
+				res.Created, res.Updated, res.Expired, res.Errors)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// fakeAlertmanager keeps silences in memory, moving the start of silences created in the past
+// to their creation time like Alertmanager does
+type fakeAlertmanager struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	next     int
+	silences map[string]*models.GettableSilence
+	alerts   []models.PostableAlerts
+}
+
+func newFakeAlertmanager(now time.Time) *fakeAlertmanager {
+	return &fakeAlertmanager{
+		now:      func() time.Time { return now },
+		silences: map[string]*models.GettableSilence{},
+	}
+}
+
+func (f *fakeAlertmanager) ListAlerts() (models.GettableAlerts, error) {
+	return models.GettableAlerts{}, nil
+}
+
+func (f *fakeAlertmanager) CreateSilenceWith(start, end string, request APISilenceRequest) (string, error) {
+	s, err := constructSilence(start, end, request)
+	if err != nil {
+		return "", err
+	}
+	if time.Time(*s.StartsAt).Before(f.now()) {
+		now := strfmt.DateTime(f.now())
+		s.StartsAt = &now
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next++
+	id := fmt.Sprintf("s%d", f.next)
+	state := models.SilenceStatusStatePending
+	if !time.Time(*s.StartsAt).After(f.now()) {
+		state = models.SilenceStatusStateActive
+	}
+	f.silences[id] = &models.GettableSilence{ID: &id, Silence: s, Status: &models.SilenceStatus{State: &state}}
+	return id, nil
+}
+
+func (f *fakeAlertmanager) UpdateSilenceWith(uuid, start, end string, request APISilenceRequest) (string, error) {
+	err := f.ExpireSilenceWithID(uuid)
+	if err != nil {
+		return "", err
+	}
+	return f.CreateSilenceWith(start, end, request)
+}
+
+func (f *fakeAlertmanager) GetSilenceWithID(uuid string) (models.GettableSilence, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.silences[uuid]
+	if !ok {
+		return models.GettableSilence{}, fmt.Errorf("silence '%s' not found", uuid)
+	}
+	return *s, nil
+}
+
+func (f *fakeAlertmanager) ListSilences() (models.GettableSilences, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out models.GettableSilences
+	for _, s := range f.silences {
+		c := *s
+		out = append(out, &c)
+	}
+	sort.Slice(out, func(i, j int) bool { return *out[i].ID < *out[j].ID })
+	return out, nil
+}
+
+func (f *fakeAlertmanager) ExpireSilenceWithID(uuid string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.silences[uuid]
+	if !ok {
+		return fmt.Errorf("silence '%s' not found", uuid)
+	}
+	state := models.SilenceStatusStateExpired
+	s.Status = &models.SilenceStatus{State: &state}
+	now := strfmt.DateTime(f.now())
+	s.EndsAt = &now
+	return nil
+}
+
+func (f *fakeAlertmanager) PostAlerts(alerts models.PostableAlerts) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.alerts = append(f.alerts, alerts)
+	return nil
+}
+
+// active returns the silences which are not expired
+func (f *fakeAlertmanager) active() []*models.GettableSilence {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*models.GettableSilence
+	for _, s := range f.silences {
+		if *s.Status.State != models.SilenceStatusStateExpired {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func writeDeclaration(t *testing.T, dir, name, content string) {
+	err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("unable to write declaration: %s", err.Error())
+	}
+}
+
+const testDeclaration = `
+maintenances:
+  - name: db-patching
+    owner: dba
+    comment: %s
+    matchers:
+      - name: team
+        value: db
+    schedule:
+      start: 2021-10-13T02:00:00Z
+      end: 2021-10-13T04:00:00Z
+      repeat:
+        interval: d
+        count: 3
+`
+
+func TestManagedName(t *testing.T) {
+	var cases = []struct {
+		comment string
+		want    string
+		ok      bool
+	}{
+		{"weekly patching [ams-managed:db-patching]", "db-patching", true},
+		{"[CHG0001234] weekly patching [ams-managed:db.patching_2]", "db.patching_2", true},
+		{"weekly patching", "", false},
+		{"weekly patching [ams-managed:db patching]", "", false},
+		{"[ams-managed:db-patching] edited by hand", "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := managedName(c.comment)
+		if got != c.want || ok != c.ok {
+			t.Errorf("managedName not returning expected result for => '%s': got '%s' '%t'", c.comment, got, ok)
+		}
+	}
+
+	// users cannot tag their own silences as managed
+	r := APISilenceRequest{Comment: "fake [ams-managed:db-patching]", CreatedBy: "mallory"}
+	if _, ok := r.Valid(); ok {
+		t.Errorf("comments containing the managed tag should be rejected")
+	}
+}
+
+func TestLoadDeclarations_invalid(t *testing.T) {
+	var cases = []struct {
+		name  string
+		files map[string]string
+	}{
+		{"duplicate name", map[string]string{
+			"a.yml":  fmt.Sprintf(testDeclaration, "first"),
+			"b.yaml": fmt.Sprintf(testDeclaration, "second"),
+		}},
+		{"unknown field", map[string]string{"a.yml": "maintenances:\n  - name: x\n    owners: dba\n"}},
+		{"invalid name", map[string]string{"a.yml": "maintenances:\n  - name: db patching\n"}},
+		{"kubernetes name", map[string]string{"a.yml": "maintenances:\n  - name: k8s.db.patching\n"}},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "ams-declarative")
+		if err != nil {
+			t.Fatalf("unable to create temp dir: %s", err.Error())
+		}
+		for name, content := range c.files {
+			writeDeclaration(t, dir, name, content)
+		}
+
+		if _, err := loadDeclarations(dir); err == nil {
+			t.Errorf("expected an error for '%s'", c.name)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestApp_reconcileDeclarations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ams-declarative")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// the first occurrence is in progress
+	now := time.Date(2021, 10, 13, 3, 0, 0, 0, time.UTC)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	manual, err := am.CreateSilenceWith("2021-10-13T00:00:00.000Z", "2021-10-20T00:00:00.000Z",
+		APISilenceRequest{Comment: "manual", CreatedBy: "alice", Matchers: []Matcher{{Name: "team", Value: "db"}}})
+	if err != nil {
+		t.Fatalf("unable to create manual silence: %s", err.Error())
+	}
+
+	var steps = []struct {
+		name    string
+		content string
+		want    ReconcileResult
+		windows int
+	}{
+		{"created", fmt.Sprintf(testDeclaration, "weekly patching"), ReconcileResult{Created: 3}, 1},
+		{"unchanged", fmt.Sprintf(testDeclaration, "weekly patching"), ReconcileResult{}, 1},
+		{"comment changed", fmt.Sprintf(testDeclaration, "nightly patching"), ReconcileResult{Updated: 3}, 1},
+		{"removed", "", ReconcileResult{Expired: 3}, 0},
+	}
+
+	for _, s := range steps {
+		if s.content == "" {
+			os.Remove(filepath.Join(dir, "db.yml"))
+		} else {
+			writeDeclaration(t, dir, "db.yml", s.content)
+		}
+
+		got, err := app.reconcileDeclarations(dir, now)
+		if err != nil {
+			t.Fatalf("unable to reconcile '%s': %s", s.name, err.Error())
+		}
+		if got != s.want {
+			t.Errorf("unexpected reconciliation for '%s'\ngot: '%+v'\nwant: '%+v'", s.name, got, s.want)
+		}
+		// declared maintenances are exposed as windows
+		if got := len(app.windowMaintenances()); got != s.windows {
+			t.Errorf("wrong number of windows for '%s': got '%d' want '%d'", s.name, got, s.windows)
+		}
+	}
+
+	// manual silences are never touched
+	active := am.active()
+	if len(active) != 1 || *active[0].ID != manual {
+		t.Errorf("only the manual silence should be left, got %d silence(s)", len(active))
+	}
+
+	if got := app.auditLog().Query(AuditFilter{Actor: declarativeActor}); len(got) != 9 {
+		t.Errorf("wrong number of audit entries: got '%d' want '%d'", len(got), 9)
+	}
+}
+
+func TestApp_reconcileDeclarations_brokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ams-declarative")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2021, 10, 12, 0, 0, 0, 0, time.UTC)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	writeDeclaration(t, dir, "db.yml", fmt.Sprintf(testDeclaration, "weekly patching"))
+	if _, err := app.reconcileDeclarations(dir, now); err != nil {
+		t.Fatalf("unable to reconcile: %s", err.Error())
+	}
+
+	// a file which cannot be parsed must not get its silences expired
+	writeDeclaration(t, dir, "db.yml", "maintenances: [")
+	if _, err := app.reconcileDeclarations(dir, now); err == nil {
+		t.Errorf("expected an error for a broken file")
+	}
+	if got := len(am.active()); got != 3 {
+		t.Errorf("silences should be kept when a file is broken: got '%d' want '%d'", got, 3)
+	}
+}
+
+func TestApp_reconcileDeclarations_freeze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ams-declarative")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2021, 10, 12, 0, 0, 0, 0, time.UTC)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	writeDeclaration(t, dir, "db.yml", fmt.Sprintf(testDeclaration, "weekly patching"))
+	if _, err := app.reconcileDeclarations(dir, now); err != nil {
+		t.Fatalf("unable to reconcile: %s", err.Error())
+	}
+
+	// a freeze holds the declaration, its silences are left as they are
+	app.config = &Config{Freeze: FreezeConfig{Periods: []FreezePeriod{
+		{Name: "audit", Start: now.Add(-time.Hour), End: now.AddDate(0, 0, 7), Scopes: []LabelScope{{"team": "db"}}},
+	}}}
+	writeDeclaration(t, dir, "db.yml", fmt.Sprintf(testDeclaration, "nightly patching"))
+	got, err := app.reconcileDeclarations(dir, now)
+	if err != nil {
+		t.Fatalf("unable to reconcile: %s", err.Error())
+	}
+	if got != (ReconcileResult{Errors: 1}) {
+		t.Errorf("unexpected reconciliation during the freeze: '%+v'", got)
+	}
+	for _, s := range am.active() {
+		if !strings.HasPrefix(*s.Comment, "weekly patching") {
+			t.Errorf("silence '%s' should not be updated during the freeze: '%s'", *s.ID, *s.Comment)
+		}
+	}
+}
+
+func TestApp_reconcileDeclarations_approval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ams-declarative")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2021, 10, 12, 0, 0, 0, 0, time.UTC)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{Approval: testApproval}, client: am}
+
+	// a declaration needing an approval creates no silence
+	writeDeclaration(t, dir, "db.yml", fmt.Sprintf(testDeclaration, "weekly patching"))
+	got, err := app.reconcileDeclarations(dir, now)
+	if err != nil || got != (ReconcileResult{Errors: 1}) || len(am.active()) != 0 {
+		t.Errorf("unexpected reconciliation of a declaration needing approval: '%+v' '%v' '%d' silences", got, err, len(am.active()))
+	}
+
+	// unless declared maintenances are exempted
+	exempt := testApproval
+	exempt.ExemptDeclarative = true
+	app.config = &Config{Approval: exempt}
+	got, err = app.reconcileDeclarations(dir, now)
+	if err != nil || got != (ReconcileResult{Created: 3}) {
+		t.Errorf("unexpected reconciliation of an exempted declaration: '%+v' '%v'", got, err)
+	}
+}
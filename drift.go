@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	driftMissing  = "missing"
+	driftModified = "modified"
+	driftExpired  = "expired"
+
+	defaultDriftInterval = 5 * time.Minute
+	driftActor           = "drift"
+)
+
+var (
+	driftSilences = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ams",
+		Name:      "drift_silences",
+		Help:      "Number of occurrences of stored maintenances drifting from Alertmanager, by kind of drift.",
+	}, []string{"kind"})
+	driftLastCheckSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ams",
+		Name:      "drift_last_check_timestamp_seconds",
+		Help:      "Timestamp of the last successful drift check.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(driftSilences, driftLastCheckSeconds)
+}
+
+// DriftConfig how often stored maintenances are compared against Alertmanager
+type DriftConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	// Repair re-creates drifting occurrences automatically after each check
+	Repair bool `yaml:"repair"`
+}
+
+func (c DriftConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultDriftInterval
+	}
+	return c.Interval
+}
+
+// ExpiredSilence a silence of a maintenance expired on purpose through the scheduler
+type ExpiredSilence struct {
+	ID string `json:"id"`
+	// End the end of the occurrence the silence implemented, empty if unknown
+	End string `json:"end,omitempty"`
+}
+
+// DriftItem an occurrence of a maintenance which is not implemented as expected in Alertmanager
+type DriftItem struct {
+	MaintenanceID string `json:"maintenanceID"`
+	Kind          string `json:"kind"`
+	SilenceID     string `json:"silenceID,omitempty"`
+	Start         string `json:"start,omitempty"`
+	End           string `json:"end,omitempty"`
+}
+
+// expiredOnPurpose returns the silences of the maintenance expired through the scheduler, by ID
+func (m Maintenance) expiredOnPurpose() (map[string]bool, map[string]bool) {
+	ids, ends := map[string]bool{}, map[string]bool{}
+	for _, e := range m.Expired {
+		ids[e.ID] = true
+		if e.End != "" {
+			ends[e.End] = true
+		}
+	}
+	return ids, ends
+}
+
+// endOf returns the end of a silence in the request time layout
+func endOf(s *models.GettableSilence) string {
+	if s.EndsAt == nil {
+		return ""
+	}
+	return time.Time(*s.EndsAt).UTC().Format(requestTimeLayout)
+}
+
+// maintenanceDrift compares the silences of a scheduled maintenance with the ones of Alertmanager.
+// Occurrences already over are ignored, as are the ones whose silence was expired through the scheduler.
+func maintenanceDrift(m Maintenance, silences map[string]*models.GettableSilence, now time.Time) ([]DriftItem, error) {
+	occurrences, err := m.Request.Schedule.occurrences()
+	if err != nil {
+		return nil, err
+	}
+	expiredIDs, cancelled := m.expiredOnPurpose()
+
+	all := map[string]bool{}
+	expected := map[string]occurrence{}
+	for _, o := range occurrences {
+		all[o.End] = true
+		end, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil || !end.After(now) || cancelled[o.End] {
+			continue
+		}
+		expected[o.End] = o
+	}
+
+	var items []DriftItem
+	flagged := map[string]bool{}
+	for _, id := range m.SilenceIDs {
+		if expiredIDs[id] {
+			continue
+		}
+		s, ok := silences[id]
+		if !ok {
+			// silences are garbage collected once expired, a missing occurrence is reported below
+			continue
+		}
+
+		end := endOf(s)
+		o, pending := expected[end]
+		if all[end] && !pending {
+			// its occurrence is over
+			continue
+		}
+		if s.Status != nil && s.Status.State != nil && *s.Status.State == models.SilenceStatusStateExpired {
+			items = append(items, DriftItem{MaintenanceID: m.ID, Kind: driftExpired, SilenceID: id})
+			continue
+		}
+
+		if pending && matchesOccurrence(s, o, m.Request, now) {
+			flagged[end] = true
+			continue
+		}
+		item := DriftItem{MaintenanceID: m.ID, Kind: driftModified, SilenceID: id}
+		if pending {
+			item.Start, item.End = o.Start, o.End
+			flagged[end] = true
+		}
+		items = append(items, item)
+	}
+
+	for _, o := range occurrences {
+		if _, ok := expected[o.End]; !ok || flagged[o.End] {
+			continue
+		}
+		items = append(items, DriftItem{MaintenanceID: m.ID, Kind: driftMissing, Start: o.Start, End: o.End})
+	}
+	return items, nil
+}
+
+// checkDrift compares every scheduled maintenance with the silences of Alertmanager
+func (a *App) checkDrift(now time.Time) ([]DriftItem, error) {
+	items := []DriftItem{}
+
+	var scheduled []Maintenance
+	for _, m := range a.maintenanceStore().List() {
+		if m.State == stateScheduled {
+			scheduled = append(scheduled, m)
+		}
+	}
+	if len(scheduled) == 0 {
+		return items, nil
+	}
+
+	list, err := a.amClient().ListSilences()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve silences: %s", err.Error())
+	}
+	silences := map[string]*models.GettableSilence{}
+	for _, s := range list {
+		if s != nil && s.ID != nil {
+			silences[*s.ID] = s
+		}
+	}
+
+	for _, m := range scheduled {
+		drift, err := maintenanceDrift(m, silences, now)
+		if err != nil {
+			log.Printf("unable to check drift of maintenance '%s': %s\n", m.ID, err.Error())
+			continue
+		}
+		items = append(items, drift...)
+	}
+	return items, nil
+}
+
+// repairDrift brings the drifting occurrences back in line with their maintenance: modified silences
+// are replaced, missing occurrences are re-created and unexpectedly expired silences are forgotten.
+// Occurrences left without a silence once these are applied are re-created in the same pass.
+func (a *App) repairDrift(items []DriftItem, now time.Time) int {
+	byMaintenance := map[string][]DriftItem{}
+	for _, i := range items {
+		byMaintenance[i.MaintenanceID] = append(byMaintenance[i.MaintenanceID], i)
+	}
+
+	failed := 0
+	for id, drift := range byMaintenance {
+		m, ok := a.maintenanceStore().Get(id)
+		if !ok {
+			continue
+		}
+
+		dropped := map[string]bool{}
+		var created []string
+		for _, i := range drift {
+			switch {
+			case i.Kind == driftModified && i.End != "":
+				newID, err := a.amClient().UpdateSilenceWith(i.SilenceID, i.Start, i.End, m.Request)
+				if err != nil {
+					log.Printf("unable to repair silence '%s' of maintenance '%s': %s\n", i.SilenceID, id, err.Error())
+					failed++
+					continue
+				}
+				dropped[i.SilenceID] = true
+				created = append(created, newID)
+			case i.Kind == driftModified:
+				// the silence does not implement any occurrence anymore
+				err := a.amClient().ExpireSilenceWithID(i.SilenceID)
+				if err != nil {
+					log.Printf("unable to expire silence '%s' of maintenance '%s': %s\n", i.SilenceID, id, err.Error())
+					failed++
+					continue
+				}
+				dropped[i.SilenceID] = true
+			case i.Kind == driftExpired:
+				dropped[i.SilenceID] = true
+			case i.Kind == driftMissing:
+				newID, err := a.amClient().CreateSilenceWith(i.Start, i.End, m.Request)
+				if err != nil {
+					log.Printf("unable to re-create silence of maintenance '%s': %s\n", id, err.Error())
+					failed++
+					continue
+				}
+				created = append(created, newID)
+			}
+		}
+
+		repaired := m
+		repaired.SilenceIDs = nil
+		for _, s := range m.SilenceIDs {
+			if !dropped[s] {
+				repaired.SilenceIDs = append(repaired.SilenceIDs, s)
+			}
+		}
+		repaired.SilenceIDs = append(repaired.SilenceIDs, created...)
+		recreated, n := a.createMissing(repaired, now)
+		created = append(created, recreated...)
+		failed += n
+
+		updated, err := a.maintenanceStore().Update(id, func(m *Maintenance) error {
+			var ids []string
+			for _, s := range m.SilenceIDs {
+				if !dropped[s] {
+					ids = append(ids, s)
+				}
+			}
+			m.SilenceIDs = append(ids, created...)
+			return nil
+		})
+		if err != nil {
+			log.Printf("unable to store repaired silences of maintenance '%s': %s\n", id, err.Error())
+			failed++
+			continue
+		}
+		a.record(AuditEntry{
+			Action:        actionUpdate,
+			Actor:         driftActor,
+			MaintenanceID: id,
+			SilenceIDs:    updated.SilenceIDs,
+			Before:        auditState(m),
+			After:         auditState(updated),
+			Details:       fmt.Sprintf("repaired %d drifting occurrence(s)", len(drift)),
+		})
+	}
+	return failed
+}
+
+// createMissing creates the silences of the occurrences of the maintenance which are still missing
+func (a *App) createMissing(m Maintenance, now time.Time) ([]string, int) {
+	list, err := a.amClient().ListSilences()
+	if err != nil {
+		log.Printf("unable to retrieve silences: %s\n", err.Error())
+		return nil, 1
+	}
+	silences := map[string]*models.GettableSilence{}
+	for _, s := range list {
+		if s != nil && s.ID != nil {
+			silences[*s.ID] = s
+		}
+	}
+
+	drift, err := maintenanceDrift(m, silences, now)
+	if err != nil {
+		log.Printf("unable to check drift of maintenance '%s': %s\n", m.ID, err.Error())
+		return nil, 1
+	}
+
+	var created []string
+	failed := 0
+	for _, i := range drift {
+		if i.Kind != driftMissing {
+			continue
+		}
+		newID, err := a.amClient().CreateSilenceWith(i.Start, i.End, m.Request)
+		if err != nil {
+			log.Printf("unable to re-create silence of maintenance '%s': %s\n", m.ID, err.Error())
+			failed++
+			continue
+		}
+		created = append(created, newID)
+	}
+	return created, failed
+}
+
+func setDriftMetrics(items []DriftItem) {
+	counts := map[string]float64{driftMissing: 0, driftModified: 0, driftExpired: 0}
+	for _, i := range items {
+		counts[i.Kind]++
+	}
+	for kind, n := range counts {
+		driftSilences.WithLabelValues(kind).Set(n)
+	}
+	driftLastCheckSeconds.SetToCurrentTime()
+}
+
+// markExpired remembers a silence of a maintenance was expired on purpose, so it is not reported as drifting
+func (a *App) markExpired(id string, before *models.GettableSilence) {
+	m, ok := a.maintenanceStore().FindBySilenceID(id)
+	if !ok {
+		return
+	}
+
+	e := ExpiredSilence{ID: id}
+	if before != nil {
+		e.End = endOf(before)
+	}
+	updated, err := a.maintenanceStore().Update(m.ID, func(m *Maintenance) error {
+		m.Expired = append(m.Expired, e)
+		return nil
+	})
+	if err != nil {
+		log.Printf("unable to store expiration of silence '%s': %s\n", id, err.Error())
+		return
+	}
+	if before != nil && before.StartsAt != nil {
+		start := time.Time(*before.StartsAt).UTC().Format(requestTimeLayout)
+		a.notify(eventCancelled, updated, &occurrence{Start: start, End: e.End})
+	}
+}
+
+// driftLoop periodically checks for drift, repairing it if configured to
+func (a *App) driftLoop() {
+	for {
+		c := a.conf().Drift
+		time.Sleep(c.interval())
+
+		items, err := a.checkDrift(time.Now())
+		if err != nil {
+			log.Printf("unable to check drift: %s\n", err.Error())
+			continue
+		}
+		setDriftMetrics(items)
+		if len(items) == 0 {
+			continue
+		}
+
+		log.Printf("%d drifting occurrence(s) found\n", len(items))
+		if a.conf().Drift.Repair {
+			a.repairDrift(items, time.Now())
+		}
+	}
+}
+
+func (a *App) getDrift(w http.ResponseWriter, r *http.Request) {
+	items, err := a.checkDrift(time.Now())
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadGateway, w)
+		return
+	}
+	setDriftMetrics(items)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(items)
+}
+
+func (a *App) repairDriftHandler(w http.ResponseWriter, r *http.Request) {
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	items, err := a.checkDrift(time.Now())
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadGateway, w)
+		return
+	}
+
+	failed := a.repairDrift(items, time.Now())
+	if failed != 0 {
+		writeError(fmt.Sprintf("'%d' occurrence(s) could not be repaired", failed), w)
+		return
+	}
+
+	resp := APIResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("%d drifting occurrence(s) repaired", len(items)),
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
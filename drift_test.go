@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newScheduledMaintenance creates the silences of a daily maintenance in the fake Alertmanager and stores it
+func newScheduledMaintenance(t *testing.T, app *App, am *fakeAlertmanager, start time.Time, count int) Maintenance {
+	m := Maintenance{
+		ID:    newID(),
+		State: stateScheduled,
+		Request: APISilenceRequest{
+			Comment:   "db patching",
+			CreatedBy: "alice",
+			Matchers:  []Matcher{{Name: "team", Value: "db"}},
+			Schedule: Schedule{
+				StartTime: start.Format(requestTimeLayout),
+				EndTime:   start.Add(time.Hour).Format(requestTimeLayout),
+				Repeat:    Repeat{Interval: "d", Count: count},
+			},
+		},
+	}
+
+	ids, failed := app.createSilences(m.Request)
+	if failed != 0 {
+		t.Fatalf("unable to create silences")
+	}
+	m.SilenceIDs = ids
+	err := app.maintenanceStore().Add(m)
+	if err != nil {
+		t.Fatalf("unable to add maintenance: %s", err.Error())
+	}
+	return m
+}
+
+func driftKinds(items []DriftItem) []string {
+	var kinds []string
+	for _, i := range items {
+		kinds = append(kinds, i.Kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func TestApp_checkDrift(t *testing.T) {
+	now := time.Date(2021, 10, 12, 0, 0, 0, 0, time.UTC)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	m := newScheduledMaintenance(t, &app, am, now.Add(2*time.Hour), 4)
+
+	items, err := app.checkDrift(now)
+	if err != nil {
+		t.Fatalf("unable to check drift: %s", err.Error())
+	}
+	if len(items) != 0 {
+		t.Fatalf("no drift expected right after creation, got '%+v'", items)
+	}
+
+	// garbage collected, expired and edited outside of the scheduler
+	delete(am.silences, m.SilenceIDs[0])
+	am.ExpireSilenceWithID(m.SilenceIDs[1])
+	edited := "db patching, extended"
+	am.silences[m.SilenceIDs[2]].Comment = &edited
+
+	items, err = app.checkDrift(now)
+	if err != nil {
+		t.Fatalf("unable to check drift: %s", err.Error())
+	}
+	got := driftKinds(items)
+	want := []string{driftExpired, driftMissing, driftMissing, driftModified}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected drift\ngot: '%v'\nwant: '%v'", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("unexpected drift\ngot: '%v'\nwant: '%v'", got, want)
+			break
+		}
+	}
+
+	if failed := app.repairDrift(items, now); failed != 0 {
+		t.Fatalf("'%d' occurrence(s) could not be repaired", failed)
+	}
+	items, err = app.checkDrift(now)
+	if err != nil {
+		t.Fatalf("unable to check drift: %s", err.Error())
+	}
+	if len(items) != 0 {
+		t.Errorf("no drift expected after repair, got '%+v'", items)
+	}
+	if got := len(am.active()); got != 4 {
+		t.Errorf("wrong number of active silences after repair: got '%d' want '%d'", got, 4)
+	}
+
+	// occurrences over are not expected anymore
+	items, err = app.checkDrift(now.Add(30 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("unable to check drift: %s", err.Error())
+	}
+	if len(items) != 0 {
+		t.Errorf("no drift expected once the maintenance is over, got '%+v'", items)
+	}
+}
+
+func TestApp_checkDrift_expiredThroughScheduler(t *testing.T) {
+	now := time.Date(2021, 10, 12, 0, 0, 0, 0, time.UTC)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	m := newScheduledMaintenance(t, &app, am, now.Add(2*time.Hour), 2)
+
+	req := httptest.NewRequest("DELETE", "/api/v1/silence/"+m.SilenceIDs[1], nil)
+	req = mux.SetURLVars(req, map[string]string{"id": m.SilenceIDs[1]})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.expireSilence).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusOK)
+	}
+
+	items, err := app.checkDrift(now)
+	if err != nil {
+		t.Fatalf("unable to check drift: %s", err.Error())
+	}
+	if len(items) != 0 {
+		t.Errorf("silences expired through the scheduler are not drifting, got '%+v'", items)
+	}
+}
+
+func TestApp_repairDrift_singlePass(t *testing.T) {
+	now := time.Date(2021, 10, 12, 0, 0, 0, 0, time.UTC)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	m := newScheduledMaintenance(t, &app, am, now.Add(2*time.Hour), 3)
+
+	// a silence expired before it started, which Alertmanager turns into an empty window
+	am.ExpireSilenceWithID(m.SilenceIDs[0])
+	started := *am.silences[m.SilenceIDs[0]].EndsAt
+	am.silences[m.SilenceIDs[0]].StartsAt = &started
+	delete(am.silences, m.SilenceIDs[2])
+
+	items, err := app.checkDrift(now)
+	if err != nil {
+		t.Fatalf("unable to check drift: %s", err.Error())
+	}
+	if failed := app.repairDrift(items, now); failed != 0 {
+		t.Fatalf("'%d' occurrence(s) could not be repaired", failed)
+	}
+
+	// a single repair is enough
+	items, err = app.checkDrift(now)
+	if err != nil {
+		t.Fatalf("unable to check drift: %s", err.Error())
+	}
+	if len(items) != 0 {
+		t.Errorf("no drift expected after a single repair, got '%+v'", items)
+	}
+	if got := len(am.active()); got != 3 {
+		t.Errorf("wrong number of active silences after repair: got '%d' want '%d'", got, 3)
+	}
+	updated, _ := app.maintenanceStore().Get(m.ID)
+	ids := map[string]bool{}
+	for _, id := range updated.SilenceIDs {
+		ids[id] = true
+	}
+	for _, s := range am.active() {
+		if !ids[*s.ID] {
+			t.Errorf("silence '%s' is missing from the repaired maintenance: '%v'", *s.ID, updated.SilenceIDs)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// freezeRecurrences how often a recurring freeze period comes back, and the longest it can last
+var freezeRecurrences = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 28 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+}
+
+// FreezeConfig periods during which maintenances touching their scopes cannot be created or edited
+type FreezeConfig struct {
+	Periods []FreezePeriod `yaml:"periods"`
+	// OverrideRoles names of the rbac roles allowed to create maintenances during a freeze
+	OverrideRoles []string `yaml:"override_roles"`
+}
+
+// FreezePeriod a one-off period, or the first one of a series repeated every day, week, month or year
+type FreezePeriod struct {
+	Name   string       `yaml:"name"`
+	Start  time.Time    `yaml:"start"`
+	End    time.Time    `yaml:"end"`
+	Every  string       `yaml:"every"`
+	Scopes []LabelScope `yaml:"scopes"`
+}
+
+func (c FreezeConfig) validate(rbac RBACConfig) error {
+	for _, p := range c.Periods {
+		if p.Name == "" {
+			return fmt.Errorf("freeze period name is mandatory")
+		}
+		if p.Start.IsZero() || !p.End.After(p.Start) {
+			return fmt.Errorf("freeze period '%s' needs a start before its end", p.Name)
+		}
+		if p.Every != "" {
+			max, ok := freezeRecurrences[p.Every]
+			if !ok {
+				return fmt.Errorf("invalid freeze period '%s' recurrence '%s', expected day, week, month or year", p.Name, p.Every)
+			}
+			if p.End.Sub(p.Start) > max {
+				return fmt.Errorf("freeze period '%s' lasts longer than its recurrence", p.Name)
+			}
+		}
+		for _, s := range p.Scopes {
+			if len(s) == 0 {
+				return fmt.Errorf("freeze period '%s' has an empty scope", p.Name)
+			}
+		}
+	}
+
+	for _, name := range c.OverrideRoles {
+		found := false
+		for _, r := range rbac.Roles {
+			if r.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("freeze override role '%s' is not an rbac role", name)
+		}
+	}
+	return nil
+}
+
+// window returns the k-th period of the series
+func (p FreezePeriod) window(k int) (time.Time, time.Time) {
+	switch p.Every {
+	case "day":
+		return p.Start.AddDate(0, 0, k), p.End.AddDate(0, 0, k)
+	case "week":
+		return p.Start.AddDate(0, 0, 7*k), p.End.AddDate(0, 0, 7*k)
+	case "month":
+		return p.Start.AddDate(0, k, 0), p.End.AddDate(0, k, 0)
+	case "year":
+		return p.Start.AddDate(k, 0, 0), p.End.AddDate(k, 0, 0)
+	}
+	return p.Start, p.End
+}
+
+// index returns about how many periods of the series started before the time
+func (p FreezePeriod) index(t time.Time) int {
+	switch p.Every {
+	case "month":
+		return (t.Year()-p.Start.Year())*12 + int(t.Month()) - int(p.Start.Month())
+	case "year":
+		return t.Year() - p.Start.Year()
+	case "day", "week":
+		return int(t.Sub(p.Start) / freezeRecurrences[p.Every])
+	}
+	return 0
+}
+
+// overlaps returns the first period overlapping the window, a zero length window overlaps the period it is in
+func (p FreezePeriod) overlaps(from, to time.Time) (time.Time, time.Time, bool) {
+	if !to.After(from) {
+		to = from.Add(time.Nanosecond)
+	}
+
+	k := p.index(from) - 1
+	if k < 0 {
+		k = 0
+	}
+	for {
+		start, end := p.window(k)
+		if !start.Before(to) {
+			return start, end, false
+		}
+		if from.Before(end) {
+			return start, end, true
+		}
+		if p.Every == "" {
+			return start, end, false
+		}
+		k++
+	}
+}
+
+// touchedBy returns true if the matchers may silence alerts within the scopes of the period
+func (p FreezePeriod) touchedBy(matchers []Matcher) bool {
+	if len(p.Scopes) == 0 {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s.touchedBy(matchers) {
+			return true
+		}
+	}
+	return false
+}
+
+// frozen returns a message for each freeze period the request is submitted during, or one of its
+// occurrences overlaps
+func (c FreezeConfig) frozen(r APISilenceRequest, now time.Time) []string {
+	var out []string
+	occurrences, _ := r.Schedule.occurrences()
+
+	for _, p := range c.Periods {
+		if !p.touchedBy(r.Matchers) {
+			continue
+		}
+		if _, end, ok := p.overlaps(now, now); ok {
+			out = append(out, fmt.Sprintf("freeze '%s' is in effect until %s", p.Name, end.UTC().Format(requestTimeLayout)))
+			continue
+		}
+
+		for _, o := range occurrences {
+			oStart, err := time.Parse(requestTimeLayout, o.Start)
+			if err != nil {
+				continue
+			}
+			oEnd, err := time.Parse(requestTimeLayout, o.End)
+			if err != nil {
+				continue
+			}
+			if start, end, ok := p.overlaps(oStart, oEnd); ok {
+				out = append(out, fmt.Sprintf("occurrence starting %s overlaps freeze '%s' (%s to %s)", o.Start, p.Name,
+					start.UTC().Format(requestTimeLayout), end.UTC().Format(requestTimeLayout)))
+				break
+			}
+		}
+	}
+	return out
+}
+
+// canOverride returns true if the caller holds one of the override roles
+func (c FreezeConfig) canOverride(ac access) bool {
+	for _, name := range c.OverrideRoles {
+		for _, r := range ac.roles {
+			if r == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rule returns a validation rule rejecting requests during a freeze, callers with an override role
+// can create them anyway by providing a justification
+func (c FreezeConfig) rule(ac access, now time.Time, onOverride func(r APISilenceRequest, freezes []string)) ValidationRule {
+	return func(r APISilenceRequest) (string, bool) {
+		freezes := c.frozen(r, now)
+		if len(freezes) == 0 {
+			return "", true
+		}
+
+		override := c.canOverride(ac)
+		if override && r.Justification != "" {
+			log.Printf("freeze overridden by '%s', justification: '%s', freezes: %s\n",
+				r.CreatedBy, r.Justification, strings.Join(freezes, "; "))
+			if onOverride != nil {
+				onOverride(r, freezes)
+			}
+			return "", true
+		}
+
+		msg := fmt.Sprintf("maintenance freeze: %s", strings.Join(freezes, "; "))
+		if override {
+			msg += " (provide a justification to override)"
+		}
+		return msg, false
+	}
+}
+
+// freezeRule returns the freeze rule for the caller of the request, overrides are collected in the
+// override log, to be recorded once the maintenance is created
+func (a *App) freezeRule(r *http.Request, maintenanceID string, overrides *overrideLog) ValidationRule {
+	onOverride := func(req APISilenceRequest, freezes []string) {
+		overrides.add(AuditEntry{
+			Action:        actionFreezeOverride,
+			Actor:         req.CreatedBy,
+			MaintenanceID: maintenanceID,
+			After:         auditState(req),
+			Justification: req.Justification,
+			Details:       strings.Join(freezes, "; "),
+		})
+	}
+	return a.conf().Freeze.rule(a.accessFor(r), time.Now(), onOverride)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFreezeConfig_validate(t *testing.T) {
+	start := time.Date(2021, 12, 20, 0, 0, 0, 0, time.UTC)
+	var cases = []struct {
+		name  string
+		conf  FreezeConfig
+		valid bool
+	}{
+		{"valid", FreezeConfig{Periods: []FreezePeriod{{Name: "holidays", Start: start, End: start.AddDate(0, 0, 14), Every: "year"}},
+			OverrideRoles: []string{"sre"}}, true},
+		{"no name", FreezeConfig{Periods: []FreezePeriod{{Start: start, End: start.Add(time.Hour)}}}, false},
+		{"end before start", FreezeConfig{Periods: []FreezePeriod{{Name: "audit", Start: start, End: start}}}, false},
+		{"invalid recurrence", FreezeConfig{Periods: []FreezePeriod{{Name: "audit", Start: start, End: start.Add(time.Hour), Every: "quarter"}}}, false},
+		{"longer than recurrence", FreezeConfig{Periods: []FreezePeriod{{Name: "audit", Start: start, End: start.AddDate(0, 0, 8), Every: "week"}}}, false},
+		{"empty scope", FreezeConfig{Periods: []FreezePeriod{{Name: "audit", Start: start, End: start.Add(time.Hour), Scopes: []LabelScope{{}}}}}, false},
+		{"unknown override role", FreezeConfig{OverrideRoles: []string{"incident-commanders"}}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.conf.validate(testRBAC); (err == nil) != c.valid {
+			t.Errorf("%s: unexpected validation result: '%v'", c.name, err)
+		}
+	}
+}
+
+func TestFreezePeriod_overlaps(t *testing.T) {
+	holidays := FreezePeriod{
+		Start: time.Date(2021, 12, 20, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC),
+		Every: "year",
+	}
+	weekend := FreezePeriod{
+		Start: time.Date(2021, 10, 16, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2021, 10, 18, 0, 0, 0, 0, time.UTC),
+		Every: "week",
+	}
+	audit := FreezePeriod{
+		Start: time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2021, 11, 5, 0, 0, 0, 0, time.UTC),
+	}
+
+	var cases = []struct {
+		name   string
+		period FreezePeriod
+		from   time.Time
+		to     time.Time
+		want   bool
+	}{
+		{"within the first period", holidays, time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC), time.Time{}, true},
+		{"period across the new year", holidays, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), time.Time{}, true},
+		{"between two periods", holidays, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Time{}, false},
+		{"before the first period", holidays, time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), time.Time{}, false},
+		{"window ending in a period", holidays, time.Date(2023, 12, 19, 22, 0, 0, 0, time.UTC), time.Date(2023, 12, 20, 2, 0, 0, 0, time.UTC), true},
+		{"window ending at the start of a period", holidays, time.Date(2023, 12, 19, 22, 0, 0, 0, time.UTC), time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC), false},
+		{"weekend", weekend, time.Date(2022, 3, 6, 12, 0, 0, 0, time.UTC), time.Time{}, true},
+		{"week day", weekend, time.Date(2022, 3, 7, 12, 0, 0, 0, time.UTC), time.Time{}, false},
+		{"one-off", audit, time.Date(2021, 11, 4, 0, 0, 0, 0, time.UTC), time.Time{}, true},
+		{"after one-off", audit, time.Date(2022, 11, 4, 0, 0, 0, 0, time.UTC), time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		if _, _, got := c.period.overlaps(c.from, c.to); got != c.want {
+			t.Errorf("%s: got '%t' want '%t'", c.name, got, c.want)
+		}
+	}
+}
+
+func TestApp_createSilence_freeze(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	rbac := RBACConfig{Roles: append([]Role{
+		{Name: "incident-commanders", Users: []string{"ic"}, Scopes: []LabelScope{{"env": "prod"}}},
+	}, testRBAC.Roles...)}
+	app := &App{
+		config: &Config{
+			RBAC: rbac,
+			Freeze: FreezeConfig{
+				Periods: []FreezePeriod{
+					{Name: "audit", Start: now.Add(-time.Hour), End: now.Add(24 * time.Hour), Scopes: []LabelScope{{"env": "prod"}}},
+					{Name: "holidays", Start: now.AddDate(0, 0, 10), End: now.AddDate(0, 0, 12), Every: "year"},
+				},
+				OverrideRoles: []string{"incident-commanders"},
+			},
+		},
+		client: am,
+	}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	submit := func(id *Identity, matchers []Matcher, start time.Time, justification string) (int, string) {
+		body, _ := json.Marshal(APISilenceRequest{
+			Comment:       "patching",
+			Matchers:      matchers,
+			Justification: justification,
+			Schedule: Schedule{
+				StartTime: start.Format(requestTimeLayout),
+				EndTime:   start.Add(time.Hour).Format(requestTimeLayout),
+				Repeat:    Repeat{Interval: "h", Count: 1},
+			},
+		})
+		req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = withIdentity(req, id)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+		var resp APIResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp.Message
+	}
+
+	alice := &Identity{Name: "alice", Groups: []string{"payments-team"}, Token: true}
+	prod := []Matcher{{Name: "team", Value: "payments"}, {Name: "env", Value: "prod"}}
+	staging := []Matcher{{Name: "team", Value: "payments"}, {Name: "env", Value: "staging"}}
+
+	code, msg := submit(alice, prod, now.Add(2*time.Hour), "")
+	if code != http.StatusBadRequest || !strings.Contains(msg, "freeze 'audit' is in effect") || strings.Contains(msg, "justification") {
+		t.Errorf("expected the request to be frozen, got '%d' '%s'", code, msg)
+	}
+	if code, msg = submit(alice, staging, now.Add(2*time.Hour), ""); code != http.StatusOK {
+		t.Errorf("a request outside of the freeze scopes should be accepted, got '%d' '%s'", code, msg)
+	}
+
+	// a window during the next holidays is frozen before they start
+	code, msg = submit(alice, staging, now.AddDate(0, 0, 11), "")
+	if code != http.StatusBadRequest || !strings.Contains(msg, "overlaps freeze 'holidays'") {
+		t.Errorf("expected the occurrence to be frozen, got '%d' '%s'", code, msg)
+	}
+
+	ic := &Identity{Name: "ic", Token: true}
+	code, msg = submit(ic, prod, now.Add(2*time.Hour), "")
+	if code != http.StatusBadRequest || !strings.Contains(msg, "provide a justification") {
+		t.Errorf("expected a justification to be asked for, got '%d' '%s'", code, msg)
+	}
+	if code, msg = submit(ic, prod, now.Add(2*time.Hour), "incident 42"); code != http.StatusOK {
+		t.Fatalf("expected the freeze to be overridden, got '%d' '%s'", code, msg)
+	}
+
+	overrides := app.auditLog().Query(AuditFilter{Action: actionFreezeOverride})
+	if len(overrides) != 1 || overrides[0].Actor != "ic" || overrides[0].Justification != "incident 42" ||
+		!strings.Contains(overrides[0].Details, "audit") || overrides[0].MaintenanceID == "" {
+		t.Errorf("unexpected override entries: '%+v'", overrides)
+	}
+	if len(am.active()) != 2 {
+		t.Errorf("wrong number of silences: got '%d' want '2'", len(am.active()))
+	}
+}
@@ -3,13 +3,22 @@ module github.com/FXinnovation/alertmanager-maintenance-scheduler
 go 1.12
 
 require (
+	github.com/coreos/go-oidc v2.2.1+incompatible
 	github.com/go-openapi/strfmt v0.19.2
 	github.com/gorilla/mux v1.7.3
 	github.com/gorilla/schema v1.1.0
 	github.com/gorilla/sessions v1.2.0
+	github.com/pquerna/cachecontrol v0.0.0-20180517163645-1555304b9b35 // indirect
 	github.com/prometheus/alertmanager v0.19.0
 	github.com/prometheus/client_golang v1.1.0
-	github.com/stretchr/testify v1.3.0
+	github.com/stretchr/testify v1.6.1
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
-	gopkg.in/yaml.v2 v2.2.4
+	gopkg.in/square/go-jose.v2 v2.3.1
+	gopkg.in/yaml.v2 v2.2.8
+	k8s.io/apimachinery v0.20.0
+	k8s.io/client-go v12.0.0+incompatible
 )
+
+// client-go is required as v12.0.0+incompatible through Prometheus, which sorts after the module versions
+replace k8s.io/client-go => k8s.io/client-go v0.20.0
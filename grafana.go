@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGrafanaInterval = time.Minute
+	defaultGrafanaTimeout  = 10 * time.Second
+	defaultGrafanaTag      = "maintenance"
+	// grafanaManagedTag tags the annotations of declared maintenances and MaintenanceWindow resources, which
+	// are found back by their tags as they are not stored
+	grafanaManagedTag = "ams-managed"
+)
+
+// GrafanaConfig Grafana instance the occurrences of the maintenances are annotated in
+type GrafanaConfig struct {
+	URL      string        `yaml:"url"`
+	APIKey   string        `yaml:"api_key"`
+	Tags     []string      `yaml:"tags"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// Annotation a region annotation created in Grafana for an occurrence of a maintenance
+type Annotation struct {
+	ID    int64  `json:"id"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// grafanaAnnotation body of the annotation API of Grafana
+type grafanaAnnotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd"`
+	Tags    []string `json:"tags"`
+	Text    string   `json:"text"`
+}
+
+func (c GrafanaConfig) validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid grafana url '%s'", c.URL)
+	}
+	return nil
+}
+
+func (c GrafanaConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultGrafanaInterval
+	}
+	return c.Interval
+}
+
+// tags returns the tags of the annotations of a maintenance: the configured ones and one per matcher
+func (c GrafanaConfig) tags(r APISilenceRequest) []string {
+	tags := c.Tags
+	if len(tags) == 0 {
+		tags = []string{defaultGrafanaTag}
+	}
+	tags = append([]string(nil), tags...)
+	for _, m := range r.Matchers {
+		op := "="
+		if m.IsRegex {
+			op = "=~"
+		}
+		tags = append(tags, m.Name+op+m.Value)
+	}
+	if r.Managed != "" {
+		tags = append(tags, grafanaManagedTag, grafanaManagedTag+":"+r.Managed)
+	}
+	return tags
+}
+
+// call sends a request to the Grafana HTTP API, decoding the response in out when set
+func (c GrafanaConfig) call(method, path string, body, out interface{}) (int, error) {
+	var payload bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&payload).Encode(body)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.URL, "/")+path, &payload)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultGrafanaTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, fmt.Errorf("grafana returned an HTTP error code: %d", resp.StatusCode)
+	}
+	if out != nil {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("unable to unmarshal body: %s", err.Error())
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// createAnnotation creates the region annotation of an occurrence of the maintenance
+func (c GrafanaConfig) createAnnotation(m Maintenance, o occurrence) (int64, error) {
+	start, err := time.Parse(requestTimeLayout, o.Start)
+	if err != nil {
+		return 0, err
+	}
+	end, err := time.Parse(requestTimeLayout, o.End)
+	if err != nil {
+		return 0, err
+	}
+
+	text := fmt.Sprintf("Maintenance: %s (created by %s)", m.Request.silenceComment(), m.Request.CreatedBy)
+	body := grafanaAnnotation{
+		Time:    start.UnixNano() / int64(time.Millisecond),
+		TimeEnd: end.UnixNano() / int64(time.Millisecond),
+		Tags:    c.tags(m.Request),
+		Text:    text,
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	_, err = c.call("POST", "/api/annotations", body, &created)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create annotation of maintenance '%s': %s", m.ID, err.Error())
+	}
+	return created.ID, nil
+}
+
+// managedAnnotations returns the annotations of the declared maintenances and MaintenanceWindow resources,
+// by managed name
+func (c GrafanaConfig) managedAnnotations() (map[string][]Annotation, error) {
+	var list []struct {
+		ID int64 `json:"id"`
+		grafanaAnnotation
+	}
+	_, err := c.call("GET", "/api/annotations?type=annotation&limit=10000&tags="+url.QueryEscape(grafanaManagedTag), nil, &list)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list annotations of declared maintenances: %s", err.Error())
+	}
+
+	out := map[string][]Annotation{}
+	for _, an := range list {
+		for _, tag := range an.Tags {
+			if !strings.HasPrefix(tag, grafanaManagedTag+":") {
+				continue
+			}
+			name := strings.TrimPrefix(tag, grafanaManagedTag+":")
+			out[name] = append(out[name], Annotation{
+				ID:    an.ID,
+				Start: time.Unix(0, an.Time*int64(time.Millisecond)).UTC().Format(requestTimeLayout),
+				End:   time.Unix(0, an.TimeEnd*int64(time.Millisecond)).UTC().Format(requestTimeLayout),
+			})
+		}
+	}
+	return out, nil
+}
+
+// deleteAnnotation deletes an annotation, annotations already deleted in Grafana are ignored
+func (c GrafanaConfig) deleteAnnotation(id int64) error {
+	code, err := c.call("DELETE", fmt.Sprintf("/api/annotations/%d", id), nil, nil)
+	if err != nil && code != http.StatusNotFound {
+		return fmt.Errorf("unable to delete annotation '%d': %s", id, err.Error())
+	}
+	return nil
+}
+
+// annotatedOccurrences returns the occurrences of the maintenance which should be annotated: all of them
+// but the ones expired through the scheduler for scheduled maintenances, none otherwise
+func annotatedOccurrences(m Maintenance) ([]occurrence, error) {
+	if m.State != stateScheduled {
+		return nil, nil
+	}
+	occurrences, err := m.Request.Schedule.occurrences()
+	if err != nil {
+		return nil, err
+	}
+	_, cancelled := m.expiredOnPurpose()
+
+	var out []occurrence
+	for _, o := range occurrences {
+		if !cancelled[o.End] {
+			out = append(out, o)
+		}
+	}
+	return out, nil
+}
+
+// syncAnnotations creates the missing annotations of the maintenance and deletes the ones of occurrences
+// which were cancelled or changed, it returns the number of annotations which could not be synced
+func (a *App) syncAnnotations(m Maintenance) (int, error) {
+	occurrences, err := annotatedOccurrences(m)
+	if err != nil {
+		return 0, err
+	}
+
+	kept, failed, changed := a.conf().Grafana.syncOccurrences(m, m.Annotations, occurrences)
+	if !changed {
+		return failed, nil
+	}
+	_, err = a.maintenanceStore().Update(m.ID, func(m *Maintenance) error {
+		m.Annotations = kept
+		return nil
+	})
+	return failed, err
+}
+
+// syncOccurrences creates the missing annotations of the occurrences and deletes the other existing ones,
+// it returns the annotations left, the number which could not be synced and whether any changed
+func (c GrafanaConfig) syncOccurrences(m Maintenance, existing []Annotation, occurrences []occurrence) ([]Annotation, int, bool) {
+	wanted := map[string]bool{}
+	for _, o := range occurrences {
+		wanted[o.Start+"/"+o.End] = true
+	}
+
+	failed := 0
+	changed := false
+	annotated := map[string]bool{}
+	var kept []Annotation
+	for _, an := range existing {
+		key := an.Start + "/" + an.End
+		if wanted[key] && !annotated[key] {
+			annotated[key] = true
+			kept = append(kept, an)
+			continue
+		}
+		err := c.deleteAnnotation(an.ID)
+		if err != nil {
+			log.Println(err)
+			failed++
+			kept = append(kept, an)
+			continue
+		}
+		changed = true
+	}
+	for _, o := range occurrences {
+		if annotated[o.Start+"/"+o.End] {
+			continue
+		}
+		id, err := c.createAnnotation(m, o)
+		if err != nil {
+			log.Println(err)
+			failed++
+			continue
+		}
+		kept = append(kept, Annotation{ID: id, Start: o.Start, End: o.End})
+		changed = true
+	}
+	return kept, failed, changed
+}
+
+// syncManagedAnnotations syncs the annotations of the valid declared maintenances and MaintenanceWindow
+// resources, and deletes the ones of maintenances no longer declared once their reconciler has run
+func (a *App) syncManagedAnnotations() error {
+	c := a.conf().Grafana
+	existing, err := c.managedAnnotations()
+	if err != nil {
+		return err
+	}
+
+	windows := map[string]Maintenance{}
+	for _, m := range a.windowMaintenances() {
+		if m.Request.Managed != "" {
+			windows[m.ID] = m
+		}
+	}
+	for name := range existing {
+		if _, ok := windows[name]; !ok && a.managedReported(managedBy(name)) {
+			windows[name] = Maintenance{ID: name}
+		}
+	}
+
+	for name, m := range windows {
+		occurrences, err := annotatedOccurrences(m)
+		if err != nil {
+			log.Printf("unable to list occurrences of declared maintenance '%s': %s\n", name, err.Error())
+			continue
+		}
+		_, failed, _ := c.syncOccurrences(m, existing[name], occurrences)
+		if failed != 0 {
+			log.Printf("'%d' annotation(s) of declared maintenance '%s' could not be synced\n", failed, name)
+		}
+	}
+	return nil
+}
+
+// syncAllAnnotations syncs the annotations of every maintenance which has or should have some
+func (a *App) syncAllAnnotations() {
+	for _, m := range a.maintenanceStore().List() {
+		if m.State != stateScheduled && len(m.Annotations) == 0 {
+			continue
+		}
+		failed, err := a.syncAnnotations(m)
+		if err != nil {
+			log.Printf("unable to sync annotations of maintenance '%s': %s\n", m.ID, err.Error())
+			continue
+		}
+		if failed != 0 {
+			log.Printf("'%d' annotation(s) of maintenance '%s' could not be synced\n", failed, m.ID)
+		}
+	}
+
+	err := a.syncManagedAnnotations()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// grafanaLoop periodically syncs the annotations when a Grafana instance is configured
+func (a *App) grafanaLoop() {
+	for {
+		time.Sleep(a.conf().Grafana.interval())
+		if a.conf().Grafana.URL != "" {
+			a.syncAllAnnotations()
+		}
+	}
+}
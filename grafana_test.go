@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGrafana implements the annotation API of Grafana in memory
+type fakeGrafana struct {
+	mu          sync.Mutex
+	next        int64
+	annotations map[int64]grafanaAnnotation
+}
+
+func (f *fakeGrafana) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/annotations":
+		var a grafanaAnnotation
+		err := json.NewDecoder(r.Body).Decode(&a)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.next++
+		f.annotations[f.next] = a
+		fmt.Fprintf(w, `{"message":"Annotation added","id":%d}`, f.next)
+	case r.Method == "GET" && r.URL.Path == "/api/annotations":
+		var list []map[string]interface{}
+		for id, a := range f.annotations {
+			if hasTags(a.Tags, r.URL.Query()["tags"]) {
+				list = append(list, map[string]interface{}{"id": id, "time": a.Time, "timeEnd": a.TimeEnd, "tags": a.Tags, "text": a.Text})
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/api/annotations/"):
+		var id int64
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/api/annotations/"), "%d", &id)
+		if _, ok := f.annotations[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.annotations, id)
+		fmt.Fprint(w, `{"message":"Annotation deleted"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// hasTags returns true if all the wanted tags are set, like the tags filter of Grafana
+func hasTags(tags, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, t := range tags {
+			found = found || t == w
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeGrafana) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.annotations)
+}
+
+func TestApp_syncAnnotations(t *testing.T) {
+	grafana := &fakeGrafana{annotations: map[int64]grafanaAnnotation{}}
+	server := httptest.NewServer(grafana)
+	defer server.Close()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{Grafana: GrafanaConfig{URL: server.URL, APIKey: "secret", Tags: []string{"ams"}}}, client: am}
+	m := newScheduledMaintenance(t, &app, am, now.Add(24*time.Hour), 3)
+
+	resync := func() Maintenance {
+		app.syncAllAnnotations()
+		m, _ := app.maintenanceStore().Get(m.ID)
+		return m
+	}
+
+	synced := resync()
+	if len(synced.Annotations) != 3 || grafana.count() != 3 {
+		t.Fatalf("wrong number of annotations: got '%d' '%d' want '%d'", len(synced.Annotations), grafana.count(), 3)
+	}
+	first := grafana.annotations[synced.Annotations[0].ID]
+	if want := []string{"ams", `team=db`}; !reflect.DeepEqual(first.Tags, want) {
+		t.Errorf("wrong tags: got '%v' want '%v'", first.Tags, want)
+	}
+	if got := time.Duration(first.TimeEnd-first.Time) * time.Millisecond; got != time.Hour {
+		t.Errorf("annotation should be a region of the occurrence, got '%s'", got)
+	}
+
+	// syncing again creates nothing
+	if synced = resync(); len(synced.Annotations) != 3 || grafana.count() != 3 {
+		t.Errorf("annotations should not be created twice, got '%d'", grafana.count())
+	}
+
+	// the annotation of an occurrence expired through the scheduler is removed, even if already deleted in Grafana
+	delete(grafana.annotations, synced.Annotations[1].ID)
+	app.markExpired(synced.SilenceIDs[1], app.silenceSnapshot(synced.SilenceIDs[1]))
+	if synced = resync(); len(synced.Annotations) != 2 || grafana.count() != 2 {
+		t.Errorf("wrong number of annotations after expiration: got '%d' '%d' want '%d'", len(synced.Annotations), grafana.count(), 2)
+	}
+
+	// all of them are removed once the maintenance is cancelled
+	app.maintenanceStore().Update(m.ID, func(m *Maintenance) error {
+		m.State = stateCancelled
+		return nil
+	})
+	if synced = resync(); len(synced.Annotations) != 0 || grafana.count() != 0 {
+		t.Errorf("annotations should be removed, got '%d'", grafana.count())
+	}
+}
+
+func TestApp_syncAnnotations_unavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{Grafana: GrafanaConfig{URL: server.URL}}, client: am}
+	m := newScheduledMaintenance(t, &app, am, now.Add(24*time.Hour), 2)
+
+	failed, err := app.syncAnnotations(m)
+	if err != nil || failed != 2 {
+		t.Errorf("unexpected result: '%d' '%v'", failed, err)
+	}
+	if m, _ = app.maintenanceStore().Get(m.ID); len(m.Annotations) != 0 {
+		t.Errorf("no annotation should be recorded, got '%+v'", m.Annotations)
+	}
+}
+
+func TestApp_syncAnnotations_managed(t *testing.T) {
+	grafana := &fakeGrafana{annotations: map[int64]grafanaAnnotation{}}
+	server := httptest.NewServer(grafana)
+	defer server.Close()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	app := App{config: &Config{Grafana: GrafanaConfig{URL: server.URL, APIKey: "secret"}}, client: newFakeAlertmanager(now)}
+	declared := DeclaredMaintenance{Name: "backup", Owner: "ops", Comment: "Backup", Matchers: []DeclaredMatcher{{Name: "job", Value: "backup"}},
+		Schedule: DeclaredSchedule{Start: now.Add(time.Hour), End: now.Add(2 * time.Hour), Repeat: Repeat{Interval: "d", Count: 2}}}
+	app.setManagedWindows(declarativeActor, map[string]APISilenceRequest{declared.Name: declared.request()})
+
+	// a resource whose controller has not run yet keeps its annotations
+	grafana.annotations[100] = grafanaAnnotation{Time: 1, TimeEnd: 2, Tags: []string{grafanaManagedTag, grafanaManagedTag + ":k8s.db.patching"}}
+
+	app.syncAllAnnotations()
+	if got := grafana.count(); got != 3 {
+		t.Fatalf("wrong number of annotations: got '%d' want '%d'", got, 3)
+	}
+	for id, an := range grafana.annotations {
+		if id != 100 && !hasTags(an.Tags, []string{defaultGrafanaTag, "job=backup", grafanaManagedTag + ":backup"}) {
+			t.Errorf("wrong tags: '%v'", an.Tags)
+		}
+	}
+
+	// the annotations are found back in Grafana
+	app.syncAllAnnotations()
+	if got := grafana.count(); got != 3 {
+		t.Errorf("annotations should not be created twice, got '%d'", got)
+	}
+
+	// and deleted with the declaration
+	app.setManagedWindows(declarativeActor, nil)
+	app.setManagedWindows(kubernetesActor, nil)
+	app.syncAllAnnotations()
+	if got := grafana.count(); got != 0 {
+		t.Errorf("annotations should be removed, got '%d'", got)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+const (
+	icsTimeLayout = "20060102T150405Z"
+	icsUIDDomain  = "alertmanager-maintenance-scheduler"
+	icsLineLength = 75
+)
+
+var (
+	icsEscaper       = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	calendarFilterRe = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|=)\s*"?(.*?)"?\s*$`)
+)
+
+// CalendarEvent a single occurrence of a maintenance in the calendar feed
+type CalendarEvent struct {
+	UID       string
+	Start     time.Time
+	End       time.Time
+	Stamp     time.Time
+	Comment   string
+	CreatedBy string
+	Matchers  []Matcher
+}
+
+// calendarFilter a label matcher events must satisfy to be part of the feed
+type calendarFilter struct {
+	Name    string
+	Value   string
+	IsRegex bool
+	reg     *regexp.Regexp
+}
+
+func parseCalendarFilter(s string) (calendarFilter, error) {
+	parts := calendarFilterRe.FindStringSubmatch(s)
+	if parts == nil {
+		return calendarFilter{}, fmt.Errorf("invalid filter '%s', expected name=value or name=~regex", s)
+	}
+
+	f := calendarFilter{Name: parts[1], Value: parts[3], IsRegex: parts[2] == "=~"}
+	if f.IsRegex {
+		reg, err := regexp.Compile("^(?:" + f.Value + ")$")
+		if err != nil {
+			return f, fmt.Errorf("invalid filter '%s': %s", s, err.Error())
+		}
+		f.reg = reg
+	}
+	return f, nil
+}
+
+// selects returns true if the matchers silence alerts the filter selects: one of them must be on the
+// filter label, and accept the filter value or, for regex filters, a value matched by the filter
+func (f calendarFilter) selects(matchers []Matcher) bool {
+	for _, m := range matchers {
+		if m.Name != f.Name {
+			continue
+		}
+		if !f.IsRegex && m.accepts(f.Value) {
+			return true
+		}
+		if f.IsRegex && (m.IsRegex && m.Value == f.Value || !m.IsRegex && f.reg.MatchString(m.Value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceEvents returns the occurrences of a scheduled maintenance which are not over,
+// their UID only depends on the maintenance and the start of the occurrence
+func maintenanceEvents(m Maintenance, now time.Time) ([]CalendarEvent, error) {
+	occurrences, err := m.Request.Schedule.occurrences()
+	if err != nil {
+		return nil, err
+	}
+	_, cancelled := m.expiredOnPurpose()
+
+	var events []CalendarEvent
+	for _, o := range occurrences {
+		start, err := time.Parse(requestTimeLayout, o.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil {
+			return nil, err
+		}
+		if !end.After(now) || cancelled[o.End] {
+			continue
+		}
+		events = append(events, CalendarEvent{
+			UID:       fmt.Sprintf("%s-%d@%s", m.ID, start.Unix(), icsUIDDomain),
+			Start:     start,
+			End:       end,
+			Stamp:     m.UpdatedAt,
+			Comment:   m.Request.silenceComment(),
+			CreatedBy: m.Request.CreatedBy,
+			Matchers:  m.Request.Matchers,
+		})
+	}
+	return events, nil
+}
+
+// silenceEvent returns the event of a silence which does not belong to a stored maintenance
+func silenceEvent(s *models.GettableSilence) CalendarEvent {
+	e := CalendarEvent{
+		UID:      fmt.Sprintf("%s@%s", *s.ID, icsUIDDomain),
+		Start:    time.Time(*s.StartsAt),
+		End:      time.Time(*s.EndsAt),
+		Matchers: matchersFromModel(s.Matchers),
+	}
+	if s.UpdatedAt != nil {
+		e.Stamp = time.Time(*s.UpdatedAt)
+	}
+	if s.Comment != nil {
+		e.Comment = *s.Comment
+	}
+	if s.CreatedBy != nil {
+		e.CreatedBy = *s.CreatedBy
+	}
+	return e
+}
+
+// calendarEvents returns the upcoming occurrences of the stored maintenances, completed with the
+// silences of Alertmanager which were not created from a stored maintenance
+func (a *App) calendarEvents(now time.Time) []CalendarEvent {
+	var events []CalendarEvent
+	for _, m := range a.maintenanceStore().List() {
+		if m.State != stateScheduled {
+			continue
+		}
+		list, err := maintenanceEvents(m, now)
+		if err != nil {
+			log.Printf("unable to list occurrences of maintenance '%s': %s\n", m.ID, err.Error())
+			continue
+		}
+		events = append(events, list...)
+	}
+
+	silences, err := a.amClient().ListSilences()
+	if err != nil {
+		// the stored maintenances are still worth serving
+		log.Printf("unable to retrieve silences for the calendar: %s\n", err.Error())
+		silences = nil
+	}
+	for _, s := range FilterExpired(silences) {
+		if s == nil || s.ID == nil || s.StartsAt == nil || s.EndsAt == nil {
+			continue
+		}
+		if _, ok := a.maintenanceStore().FindBySilenceID(*s.ID); ok {
+			continue
+		}
+		events = append(events, silenceEvent(s))
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Start.Equal(events[j].Start) {
+			return events[i].UID < events[j].UID
+		}
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events
+}
+
+// foldICSLine splits a content line into lines of at most 75 octets, continuation lines starting with a space
+func foldICSLine(line string) string {
+	var b strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > icsLineLength {
+			b.WriteString("\r\n ")
+			length = 1
+		}
+		b.WriteRune(r)
+		length += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+func (e CalendarEvent) description() string {
+	var matchers []string
+	for _, m := range e.Matchers {
+		matchers = append(matchers, m.String())
+	}
+	return fmt.Sprintf("Matchers: {%s}\nComment: %s\nCreated by: %s", strings.Join(matchers, ", "), e.Comment, e.CreatedBy)
+}
+
+// renderICS renders the events as an iCalendar (RFC 5545) document
+func renderICS(events []CalendarEvent, now time.Time) []byte {
+	var b bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		b.WriteString(foldICSLine(fmt.Sprintf(format, args...)))
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//FXinnovation//alertmanager-maintenance-scheduler//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:Maintenance windows")
+	for _, e := range events {
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = now
+		}
+		summary := strings.SplitN(e.Comment, "\n", 2)[0]
+
+		line("BEGIN:VEVENT")
+		line("UID:%s", e.UID)
+		line("DTSTAMP:%s", stamp.UTC().Format(icsTimeLayout))
+		line("LAST-MODIFIED:%s", stamp.UTC().Format(icsTimeLayout))
+		line("DTSTART:%s", e.Start.UTC().Format(icsTimeLayout))
+		line("DTEND:%s", e.End.UTC().Format(icsTimeLayout))
+		line("SUMMARY:%s", icsEscaper.Replace("Maintenance: "+summary))
+		line("DESCRIPTION:%s", icsEscaper.Replace(e.description()))
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+func (a *App) getCalendar(w http.ResponseWriter, r *http.Request) {
+	var filters []calendarFilter
+	for _, s := range r.URL.Query()["filter"] {
+		f, err := parseCalendarFilter(s)
+		if err != nil {
+			writeErrorWithCode(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		filters = append(filters, f)
+	}
+
+	now := time.Now()
+	var events []CalendarEvent
+	for _, e := range a.calendarEvents(now) {
+		selected := true
+		for _, f := range filters {
+			if !f.selects(e.Matchers) {
+				selected = false
+				break
+			}
+		}
+		if selected {
+			events = append(events, e)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="maintenances.ics"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(renderICS(events, now))
+}
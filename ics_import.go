@@ -0,0 +1,624 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+const (
+	icsDateLayout     = "20060102"
+	icsLocalLayout    = "20060102T150405"
+	icsSourcePrefix   = "ics:"
+	calendarSizeLimit = 1 << 20
+)
+
+// outcomes of the import of an event
+const (
+	noticeCreated   = "created"
+	noticeUpdated   = "updated"
+	noticeUnchanged = "unchanged"
+	noticeCancelled = "cancelled"
+	noticeFailed    = "failed"
+)
+
+var (
+	icsDurationRe  = regexp.MustCompile(`^([+-]?)P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+	icsUnescaper   = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	icsFrequencies = map[string]string{"HOURLY": "h", "DAILY": "d", "WEEKLY": "w"}
+)
+
+// icsProperty a content line of an iCalendar document
+type icsProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// CalendarNotice a VEVENT of an uploaded calendar, announcing a maintenance window
+type CalendarNotice struct {
+	UID       string
+	Summary   string
+	Start     time.Time
+	End       time.Time
+	RRule     string
+	ExDates   []time.Time
+	Cancelled bool
+	// Unsupported reason the event cannot be imported, if any
+	Unsupported string
+}
+
+// CalendarImportReport outcome of the upload of a calendar, per event
+type CalendarImportReport struct {
+	Created   []CalendarImportResult `json:"created"`
+	Updated   []CalendarImportResult `json:"updated"`
+	Unchanged []CalendarImportResult `json:"unchanged"`
+	Cancelled []CalendarImportResult `json:"cancelled"`
+	Invalid   []CalendarImportResult `json:"invalid"`
+	Forbidden []CalendarImportResult `json:"forbidden"`
+	Failed    []CalendarImportResult `json:"failed"`
+}
+
+// CalendarImportResult outcome of the import of a single event
+type CalendarImportResult struct {
+	UID           string `json:"uid"`
+	MaintenanceID string `json:"maintenanceID,omitempty"`
+	State         string `json:"state,omitempty"`
+	Silences      int    `json:"silences,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// unfoldICS joins the continuation lines of an iCalendar document to the line they continue
+func unfoldICS(data string) []string {
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	var lines []string
+	for _, l := range strings.Split(data, "\n") {
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// parseICSProperty splits a content line into its name, parameters and value,
+// colons and semicolons within quoted parameter values are kept
+func parseICSProperty(line string) (icsProperty, error) {
+	quoted := false
+	sep := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 {
+		return icsProperty{}, fmt.Errorf("invalid content line '%s'", line)
+	}
+
+	p := icsProperty{Params: map[string]string{}, Value: line[sep+1:]}
+	var parts []string
+	start := 0
+	quoted = false
+	for i, r := range line[:sep] {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ';' && !quoted {
+			parts = append(parts, line[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, line[start:sep])
+
+	p.Name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return icsProperty{}, fmt.Errorf("invalid parameter '%s' of property '%s'", param, p.Name)
+		}
+		p.Params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return p, nil
+}
+
+// parseICSTime parses a DATE or DATE-TIME value, in UTC, in the time zone of its TZID parameter,
+// or as UTC when floating
+func parseICSTime(value string, params map[string]string) (time.Time, error) {
+	loc := time.UTC
+	if tzid, ok := params["TZID"]; ok {
+		l, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone '%s'", tzid)
+		}
+		loc = l
+	}
+
+	switch {
+	case params["VALUE"] == "DATE" || len(value) == len(icsDateLayout):
+		return time.ParseInLocation(icsDateLayout, value, loc)
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse(icsTimeLayout, value)
+	default:
+		return time.ParseInLocation(icsLocalLayout, value, loc)
+	}
+}
+
+// parseICSDuration parses a DURATION value such as PT2H30M or P1D
+func parseICSDuration(value string) (time.Duration, error) {
+	parts := icsDurationRe.FindStringSubmatch(value)
+	if parts == nil || value == "P" || value == "PT" {
+		return 0, fmt.Errorf("invalid duration '%s'", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if parts[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(parts[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", value)
+		}
+		d += time.Duration(n) * unit
+	}
+	if parts[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// parseCalendar returns the events of an iCalendar document, a METHOD:CANCEL document cancels all of them
+func parseCalendar(data string) ([]CalendarNotice, error) {
+	var notices []CalendarNotice
+	var current *CalendarNotice
+	var duration string
+	allDay := false
+	cancel := false
+	depth := 0
+
+	for _, line := range unfoldICS(data) {
+		p, err := parseICSProperty(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VEVENT"):
+			current = &CalendarNotice{}
+			duration = ""
+			allDay = false
+			depth = 0
+			continue
+		case current == nil:
+			if p.Name == "METHOD" && strings.EqualFold(p.Value, "CANCEL") {
+				cancel = true
+			}
+			continue
+		case p.Name == "BEGIN":
+			// nested components such as VALARM are ignored
+			depth++
+			continue
+		case p.Name == "END" && depth > 0:
+			depth--
+			continue
+		case depth > 0:
+			continue
+		}
+
+		var t time.Time
+		switch p.Name {
+		case "UID":
+			current.UID = p.Value
+		case "SUMMARY":
+			current.Summary = icsUnescaper.Replace(p.Value)
+		case "STATUS":
+			current.Cancelled = strings.EqualFold(p.Value, "CANCELLED")
+		case "RRULE":
+			current.RRule = p.Value
+		case "RDATE":
+			current.Unsupported = "RDATE is not supported"
+		case "RECURRENCE-ID":
+			current.Unsupported = "modified occurrences (RECURRENCE-ID) are not supported"
+		case "DURATION":
+			duration = p.Value
+		case "DTSTART":
+			t, err = parseICSTime(p.Value, p.Params)
+			current.Start = t
+			allDay = p.Params["VALUE"] == "DATE" || len(p.Value) == len(icsDateLayout)
+		case "DTEND":
+			t, err = parseICSTime(p.Value, p.Params)
+			current.End = t
+		case "EXDATE":
+			for _, v := range strings.Split(p.Value, ",") {
+				t, err = parseICSTime(v, p.Params)
+				if err != nil {
+					break
+				}
+				current.ExDates = append(current.ExDates, t)
+			}
+		case "END":
+			if current.UID == "" {
+				return nil, fmt.Errorf("event without UID")
+			}
+			if current.Start.IsZero() {
+				return nil, fmt.Errorf("event '%s' has no start", current.UID)
+			}
+			if current.End.IsZero() && duration != "" {
+				d, err := parseICSDuration(duration)
+				if err != nil {
+					return nil, fmt.Errorf("event '%s': %s", current.UID, err.Error())
+				}
+				current.End = current.Start.Add(d)
+			}
+			if current.End.IsZero() && allDay {
+				// an all-day event without end lasts the whole day
+				current.End = current.Start.AddDate(0, 0, 1)
+			}
+			current.Cancelled = current.Cancelled || cancel
+			notices = append(notices, *current)
+			current = nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("event '%s': invalid %s: %s", current.UID, p.Name, err.Error())
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("event '%s' is not terminated", current.UID)
+	}
+	return notices, nil
+}
+
+// repeat converts the RRULE of the event into the repeat of a schedule, only rules expressible
+// as a fixed number of hourly, daily or weekly occurrences are supported
+func (n CalendarNotice) repeat() (Repeat, error) {
+	if n.RRule == "" {
+		return Repeat{Count: 1}, nil
+	}
+
+	var r Repeat
+	var until time.Time
+	for _, part := range strings.Split(n.RRule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return r, fmt.Errorf("invalid RRULE '%s'", n.RRule)
+		}
+		var err error
+		switch strings.ToUpper(kv[0]) {
+		case "FREQ":
+			interval, ok := icsFrequencies[strings.ToUpper(kv[1])]
+			if !ok {
+				return r, fmt.Errorf("unsupported RRULE frequency '%s'", kv[1])
+			}
+			r.Interval = interval
+		case "INTERVAL":
+			if kv[1] != "1" {
+				return r, fmt.Errorf("unsupported RRULE interval '%s'", kv[1])
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(kv[1])
+		case "UNTIL":
+			until, err = parseICSTime(kv[1], map[string]string{})
+		case "WKST":
+		default:
+			return r, fmt.Errorf("unsupported RRULE part '%s'", kv[0])
+		}
+		if err != nil {
+			return r, fmt.Errorf("invalid RRULE '%s': %s", n.RRule, err.Error())
+		}
+	}
+	if r.Interval == "" {
+		return r, fmt.Errorf("RRULE '%s' has no frequency", n.RRule)
+	}
+
+	if !until.IsZero() {
+		step := time.Hour * intervalTable[r.Interval]
+		r.Count = int(until.Sub(n.Start)/step) + 1
+	}
+	if r.Count <= 0 {
+		return r, fmt.Errorf("RRULE '%s' must end with a COUNT or an UNTIL after the start", n.RRule)
+	}
+	r.Enabled = r.Count > 1
+	return r, nil
+}
+
+// request converts the event into a silence request with the given matchers
+func (n CalendarNotice) request(matchers []Matcher, createdBy, ticket string) (APISilenceRequest, error) {
+	if n.Unsupported != "" {
+		return APISilenceRequest{}, fmt.Errorf("%s", n.Unsupported)
+	}
+	if !n.End.After(n.Start) {
+		return APISilenceRequest{}, fmt.Errorf("event must end after its start")
+	}
+	repeat, err := n.repeat()
+	if err != nil {
+		return APISilenceRequest{}, err
+	}
+
+	r := APISilenceRequest{
+		Comment:   n.Summary,
+		CreatedBy: createdBy,
+		Ticket:    ticket,
+		Matchers:  matchers,
+		Schedule: Schedule{
+			StartTime: n.Start.UTC().Format(requestTimeLayout),
+			EndTime:   n.End.UTC().Format(requestTimeLayout),
+			Repeat:    repeat,
+		},
+	}
+	for _, t := range n.ExDates {
+		r.Schedule.Exclude = append(r.Schedule.Exclude, t.UTC().Format(requestTimeLayout))
+	}
+	return r, nil
+}
+
+// expireMaintenanceSilences expires the silences of a maintenance which are still in effect
+func (a *App) expireMaintenanceSilences(m Maintenance) int {
+	expiredIDs, _ := m.expiredOnPurpose()
+	failed := 0
+	for _, id := range m.SilenceIDs {
+		if expiredIDs[id] {
+			continue
+		}
+		s, err := a.amClient().GetSilenceWithID(id)
+		if err == nil && s.Status != nil && s.Status.State != nil && *s.Status.State == models.SilenceStatusStateExpired {
+			continue
+		}
+		err = a.amClient().ExpireSilenceWithID(id)
+		if err != nil {
+			log.Printf("unable to expire silence '%s' of maintenance '%s': %s\n", id, m.ID, err.Error())
+			failed++
+		}
+	}
+	return failed
+}
+
+// readCalendar returns the uploaded calendar, sent either as the file field of a multipart form
+// or as the request body
+func readCalendar(r *http.Request) (string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err := r.ParseMultipartForm(calendarSizeLimit)
+		if err != nil {
+			return "", err
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(io.LimitReader(f, calendarSizeLimit))
+		return string(b), err
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, calendarSizeLimit))
+	return string(b), err
+}
+
+// importCalendar creates a maintenance for each event of an uploaded calendar, silencing the alerts
+// selected by the matchers given along. Events are identified by their UID: uploading an updated
+// notice replaces the silences of the maintenance it created, a cancelled one expires them.
+// Nothing is imported if a single event is invalid or changes a maintenance the caller may not change.
+func (a *App) importCalendar(w http.ResponseWriter, r *http.Request) {
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	data, err := readCalendar(r)
+	if err != nil {
+		writeErrorWithCode(fmt.Sprintf("unable to read calendar: %s", err.Error()), http.StatusBadRequest, w)
+		return
+	}
+	notices, err := parseCalendar(data)
+	if err != nil {
+		writeErrorWithCode(fmt.Sprintf("unable to parse calendar: %s", err.Error()), http.StatusBadRequest, w)
+		return
+	}
+
+	var matchers []Matcher
+	for _, s := range r.Form["matcher"] {
+		f, err := parseCalendarFilter(s)
+		if err != nil {
+			writeErrorWithCode(err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		matchers = append(matchers, Matcher{Name: f.Name, Value: f.Value, IsRegex: f.IsRegex})
+	}
+	createdBy := r.Form.Get("createdBy")
+	if id, ok := identityFromContext(r.Context()); ok {
+		createdBy = id.Name
+	}
+
+	report := CalendarImportReport{
+		Created: []CalendarImportResult{}, Updated: []CalendarImportResult{}, Unchanged: []CalendarImportResult{},
+		Cancelled: []CalendarImportResult{}, Invalid: []CalendarImportResult{}, Forbidden: []CalendarImportResult{},
+		Failed: []CalendarImportResult{},
+	}
+	requests := map[string]APISilenceRequest{}
+	overrides := map[string]*overrideLog{}
+	for _, n := range notices {
+		if _, ok := requests[n.UID]; ok {
+			report.Invalid = append(report.Invalid, CalendarImportResult{UID: n.UID, Reason: "event is present more than once"})
+			continue
+		}
+		request, err := n.request(matchers, createdBy, r.Form.Get("ticket"))
+		if err != nil {
+			report.Invalid = append(report.Invalid, CalendarImportResult{UID: n.UID, Reason: err.Error()})
+			continue
+		}
+		requests[n.UID] = request
+
+		// the maintenance of a previous upload is only updated or cancelled by someone who may change it
+		existing, found := a.maintenanceStore().FindBySource(icsSourcePrefix + n.UID)
+		if found {
+			if msg, ok := a.canManage(r, existing); !ok {
+				report.Forbidden = append(report.Forbidden, CalendarImportResult{UID: n.UID, MaintenanceID: existing.ID, Reason: msg})
+				continue
+			}
+		}
+		if n.Cancelled {
+			continue
+		}
+		overrides[n.UID] = &overrideLog{}
+		msg, ok := request.Valid(a.validationRules(r, existing.ID, overrides[n.UID])...)
+		if !ok {
+			report.Invalid = append(report.Invalid, CalendarImportResult{UID: n.UID, Reason: msg})
+		}
+	}
+	if len(report.Invalid) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+	if len(report.Forbidden) > 0 {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	now := time.Now()
+	for _, n := range notices {
+		res, outcome := a.importNotice(r, n, requests[n.UID], now)
+		switch outcome {
+		case noticeCreated:
+			a.recordOverrides(r, overrides[n.UID], res.MaintenanceID)
+			report.Created = append(report.Created, res)
+		case noticeUpdated:
+			a.recordOverrides(r, overrides[n.UID], res.MaintenanceID)
+			report.Updated = append(report.Updated, res)
+		case noticeCancelled:
+			report.Cancelled = append(report.Cancelled, res)
+		case noticeUnchanged:
+			report.Unchanged = append(report.Unchanged, res)
+		default:
+			report.Failed = append(report.Failed, res)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// importNotice applies a single event to the maintenance it created, if any, and returns the outcome
+func (a *App) importNotice(r *http.Request, n CalendarNotice, request APISilenceRequest, now time.Time) (CalendarImportResult, string) {
+	existing, found := a.maintenanceStore().FindBySource(icsSourcePrefix + n.UID)
+	res := CalendarImportResult{UID: n.UID, MaintenanceID: existing.ID, State: existing.State}
+
+	if n.Cancelled {
+		if !found || existing.State == stateCancelled {
+			res.Reason = "no maintenance to cancel"
+			return res, noticeUnchanged
+		}
+		failed := 0
+		if existing.State == stateScheduled {
+			failed = a.expireMaintenanceSilences(existing)
+		}
+		updated, err := a.maintenanceStore().Update(existing.ID, func(m *Maintenance) error {
+			m.State = stateCancelled
+			return nil
+		})
+		if err != nil {
+			res.Reason = fmt.Sprintf("unable to store maintenance: %s", err.Error())
+			return res, noticeFailed
+		}
+		a.auditMaintenance(r, actionCancel, existing, updated)
+		a.notify(eventCancelled, updated, nil)
+		res.State = updated.State
+		if failed != 0 {
+			res.Reason = fmt.Sprintf("'%d' silence(s) could not be expired", failed)
+		}
+		return res, noticeCancelled
+	}
+
+	request.ID = existing.ID
+	if found && existing.State != stateCancelled && existing.State != stateRejected && reflect.DeepEqual(existing.Request, request) {
+		return res, noticeUnchanged
+	}
+
+	msg, ok, err := a.conf().Ticket.check(request.Ticket)
+	if err != nil {
+		msg = err.Error()
+	}
+	if err != nil || !ok {
+		res.Reason = msg
+		return res, noticeFailed
+	}
+
+	if !found {
+		m := Maintenance{ID: newID(), Source: icsSourcePrefix + n.UID, Request: request}
+		m.Request.ID = m.ID
+		res.Reason = a.scheduleNotice(&m, now)
+		err = a.maintenanceStore().Add(m)
+		if err != nil {
+			res.Reason = fmt.Sprintf("unable to store maintenance: %s", err.Error())
+			return res, noticeFailed
+		}
+		a.auditMaintenance(r, actionCreate, Maintenance{}, m)
+		a.notify(eventCreated, m, nil)
+		res.MaintenanceID, res.State, res.Silences = m.ID, m.State, len(m.SilenceIDs)
+		return res, noticeCreated
+	}
+
+	// the previous silences are replaced, whatever changed in the notice
+	failed := 0
+	if existing.State == stateScheduled {
+		failed = a.expireMaintenanceSilences(existing)
+	}
+	next := Maintenance{ID: existing.ID, Request: request}
+	res.Reason = a.scheduleNotice(&next, now)
+	if failed != 0 {
+		res.Reason = strings.TrimSpace(fmt.Sprintf("'%d' silence(s) could not be expired %s", failed, res.Reason))
+	}
+	updated, err := a.maintenanceStore().Update(existing.ID, func(m *Maintenance) error {
+		m.Request = next.Request
+		m.State = next.State
+		m.SilenceIDs = next.SilenceIDs
+		m.Expired = nil
+		m.ApprovedBy = ""
+		return nil
+	})
+	if err != nil {
+		res.Reason = fmt.Sprintf("unable to store maintenance: %s", err.Error())
+		return res, noticeFailed
+	}
+	a.auditMaintenance(r, actionUpdate, existing, updated)
+	res.State, res.Silences = updated.State, len(updated.SilenceIDs)
+	return res, noticeUpdated
+}
+
+// scheduleNotice applies the approval policy to the maintenance of an event, creating its silences
+// when no approval is required
+func (a *App) scheduleNotice(m *Maintenance, now time.Time) string {
+	m.State = statePending
+	if a.conf().Approval.required(m.Request) {
+		return ""
+	}
+	m.State = stateScheduled
+	ids, failed := a.createUpcomingSilences(m.Request, now)
+	m.SilenceIDs = ids
+	if failed != 0 {
+		return fmt.Sprintf("'%d' silence(s) could not be created", failed)
+	}
+	return ""
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNotice vendor notice of a weekly window, folded and with a reminder like real ones
+const testNotice = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Vendor//Notices//EN\r\n" +
+	"METHOD:%s\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:notice-42@vendor.example\r\n" +
+	"SUMMARY:Core router upgrade\\, phase 1\r\n" +
+	"DTSTART;TZID=\"Europe/Paris\":%s\r\n" +
+	"DTEND;TZID=\"Europe/Paris\":%s\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=4\r\n" +
+	"EXDATE;TZID=\"Europe/Paris\":%s\r\n" +
+	"DESCRIPTION:The upgrade of the core routers will take place during th\r\n" +
+	" e window.\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICSDuration(t *testing.T) {
+	var cases = []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"PT2H30M", 150 * time.Minute, false},
+		{"P1D", 24 * time.Hour, false},
+		{"P1W", 168 * time.Hour, false},
+		{"P1DT12H", 36 * time.Hour, false},
+		{"-PT15M", -15 * time.Minute, false},
+		{"PT", 0, true},
+		{"2H", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseICSDuration(c.value)
+		if (err != nil) != c.err || got != c.want {
+			t.Errorf("parseICSDuration not returning expected result for => '%s': got '%s' '%v'", c.value, got, err)
+		}
+	}
+}
+
+func TestCalendarNotice_request(t *testing.T) {
+	start := time.Date(2021, 11, 2, 22, 0, 0, 0, time.UTC)
+	var cases = []struct {
+		rrule string
+		want  Repeat
+		err   bool
+	}{
+		{"", Repeat{Count: 1}, false},
+		{"FREQ=DAILY;COUNT=3", Repeat{Enabled: true, Interval: "d", Count: 3}, false},
+		{"FREQ=WEEKLY;INTERVAL=1;UNTIL=20211123T220000Z", Repeat{Enabled: true, Interval: "w", Count: 4}, false},
+		{"FREQ=HOURLY;UNTIL=20211103T010000Z;WKST=MO", Repeat{Enabled: true, Interval: "h", Count: 4}, false},
+		{"FREQ=WEEKLY;COUNT=4;BYDAY=TU,TH", Repeat{}, true},
+		{"FREQ=WEEKLY;INTERVAL=2;COUNT=4", Repeat{}, true},
+		{"FREQ=MONTHLY;COUNT=4", Repeat{}, true},
+		{"FREQ=DAILY", Repeat{}, true},
+	}
+
+	for _, c := range cases {
+		n := CalendarNotice{UID: "1", Summary: "upgrade", Start: start, End: start.Add(time.Hour), RRule: c.rrule}
+		r, err := n.request([]Matcher{{Name: "team", Value: "net"}}, "alice", "")
+		if (err != nil) != c.err {
+			t.Errorf("request not returning expected error for => '%s': '%v'", c.rrule, err)
+			continue
+		}
+		if err == nil && r.Schedule.Repeat != c.want {
+			t.Errorf("wrong repeat for '%s'\ngot: '%+v'\nwant: '%+v'", c.rrule, r.Schedule.Repeat, c.want)
+		}
+	}
+}
+
+func TestParseCalendar(t *testing.T) {
+	data := fmt.Sprintf(testNotice, "REQUEST", "20211102T230000", "20211103T010000", "20211109T230000")
+	notices, err := parseCalendar(data)
+	if err != nil {
+		t.Fatalf("unable to parse calendar: %s", err.Error())
+	}
+	if len(notices) != 1 {
+		t.Fatalf("wrong number of events: got '%d' want '%d'", len(notices), 1)
+	}
+
+	n := notices[0]
+	if n.UID != "notice-42@vendor.example" || n.Summary != "Core router upgrade, phase 1" || n.Cancelled {
+		t.Errorf("unexpected event: '%+v'", n)
+	}
+	r, err := n.request([]Matcher{{Name: "team", Value: "net"}}, "alice", "")
+	if err != nil {
+		t.Fatalf("unable to convert event: %s", err.Error())
+	}
+	want := Schedule{
+		StartTime: "2021-11-02T22:00:00.000Z",
+		EndTime:   "2021-11-03T00:00:00.000Z",
+		Repeat:    Repeat{Enabled: true, Interval: "w", Count: 4},
+		Exclude:   []string{"2021-11-09T22:00:00.000Z"},
+	}
+	if !reflect.DeepEqual(r.Schedule, want) {
+		t.Errorf("unexpected schedule\ngot: '%+v'\nwant: '%+v'", r.Schedule, want)
+	}
+
+	allDay, err := parseCalendar("BEGIN:VEVENT\nUID:1\nDTSTART;VALUE=DATE:20211102\nEND:VEVENT\n")
+	if err != nil || len(allDay) != 1 || allDay[0].End.Sub(allDay[0].Start) != 24*time.Hour {
+		t.Errorf("an all-day event should last a day: '%+v' '%v'", allDay, err)
+	}
+
+	for _, invalid := range []string{
+		"BEGIN:VEVENT\nDTSTART:20211102T220000Z\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nUID:1\nDTSTART;TZID=Nowhere/Else:20211102T220000\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nUID:1\nDTSTART:20211102T220000Z\n",
+	} {
+		if _, err := parseCalendar(invalid); err == nil {
+			t.Errorf("expected an error parsing:\n%s", invalid)
+		}
+	}
+}
+
+func uploadCalendar(t *testing.T, app *App, data string, matchers ...string) (int, CalendarImportReport) {
+	return uploadCalendarAs(t, app, &Identity{Name: "carol", Token: true}, data, matchers...)
+}
+
+func uploadCalendarAs(t *testing.T, app *App, id *Identity, data string, matchers ...string) (int, CalendarImportReport) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, m := range matchers {
+		mw.WriteField("matcher", m)
+	}
+	fw, err := mw.CreateFormFile("file", "notice.ics")
+	if err != nil {
+		t.Fatalf("unable to create form: %s", err.Error())
+	}
+	fw.Write([]byte(data))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/calendar/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req = withIdentity(req, id)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.importCalendar).ServeHTTP(rr, req)
+
+	var report CalendarImportReport
+	json.NewDecoder(rr.Body).Decode(&report)
+	return rr.Code, report
+}
+
+func TestApp_importCalendar(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	// times are in UTC so the test does not depend on daylight saving time changes
+	local := func(d time.Duration) string {
+		return now.Add(d).Format(icsLocalLayout)
+	}
+	notice := func(method string, end time.Duration) string {
+		data := fmt.Sprintf(testNotice, method, local(24*time.Hour), local(end), local(8*24*time.Hour))
+		return strings.Replace(data, "Europe/Paris", "UTC", -1)
+	}
+
+	// matchers are required
+	code, report := uploadCalendar(t, &app, notice("REQUEST", 26*time.Hour))
+	if code != http.StatusBadRequest || len(report.Invalid) != 1 || len(am.silences) != 0 {
+		t.Fatalf("unexpected upload without matchers: '%d' '%+v'", code, report)
+	}
+
+	code, report = uploadCalendar(t, &app, notice("REQUEST", 26*time.Hour), "team=net", `site=~"mtl|tor"`)
+	if code != http.StatusOK || len(report.Created) != 1 {
+		t.Fatalf("unexpected upload: '%d' '%+v'", code, report)
+	}
+	// the excluded occurrence is left out
+	if got := report.Created[0].Silences; got != 3 {
+		t.Errorf("wrong number of silences created: got '%d' want '%d'", got, 3)
+	}
+	m, ok := app.maintenanceStore().FindBySource("ics:notice-42@vendor.example")
+	if !ok || m.State != stateScheduled || m.Request.CreatedBy != "carol" || len(m.Request.Matchers) != 2 {
+		t.Fatalf("unexpected maintenance: '%+v'", m)
+	}
+
+	// uploading the same notice again changes nothing
+	code, report = uploadCalendar(t, &app, notice("REQUEST", 26*time.Hour), "team=net", `site=~"mtl|tor"`)
+	if code != http.StatusOK || len(report.Unchanged) != 1 || len(am.active()) != 3 {
+		t.Errorf("unexpected upload of the same notice: '%d' '%+v'", code, report)
+	}
+
+	// an updated notice replaces the silences of the series
+	code, report = uploadCalendar(t, &app, notice("REQUEST", 27*time.Hour), "team=net", `site=~"mtl|tor"`)
+	if code != http.StatusOK || len(report.Updated) != 1 || report.Updated[0].MaintenanceID != m.ID {
+		t.Fatalf("unexpected upload of an updated notice: '%d' '%+v'", code, report)
+	}
+	if got := len(am.active()); got != 3 {
+		t.Errorf("wrong number of active silences: got '%d' want '%d'", got, 3)
+	}
+	updated, _ := app.maintenanceStore().Get(m.ID)
+	for _, s := range am.active() {
+		if !strings.Contains(strings.Join(updated.SilenceIDs, ","), *s.ID) {
+			t.Errorf("silence '%s' should belong to the updated maintenance", *s.ID)
+		}
+	}
+	items, err := app.checkDrift(now)
+	if err != nil || len(items) != 0 {
+		t.Errorf("updated maintenance should not be drifting: '%+v' '%v'", items, err)
+	}
+
+	// a cancellation expires all of them
+	code, report = uploadCalendar(t, &app, notice("CANCEL", 27*time.Hour), "team=net")
+	if code != http.StatusOK || len(report.Cancelled) != 1 {
+		t.Fatalf("unexpected cancellation: '%d' '%+v'", code, report)
+	}
+	if got := len(am.active()); got != 0 {
+		t.Errorf("wrong number of active silences: got '%d' want '%d'", got, 0)
+	}
+	if cancelled, _ := app.maintenanceStore().Get(m.ID); cancelled.State != stateCancelled {
+		t.Errorf("wrong state: got '%s' want '%s'", cancelled.State, stateCancelled)
+	}
+
+	var actions []string
+	for _, e := range app.auditLog().Query(AuditFilter{MaintenanceID: m.ID}) {
+		actions = append(actions, e.Action)
+	}
+	if want := []string{actionCreate, actionUpdate, actionCancel}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("wrong audit entries: got '%v' want '%v'", actions, want)
+	}
+}
+
+func TestApp_importCalendar_rbac(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{RBAC: testRBAC}, client: am}
+
+	local := func(d time.Duration) string {
+		return now.Add(d).Format(icsLocalLayout)
+	}
+	notice := func(method string, end time.Duration) string {
+		data := fmt.Sprintf(testNotice, method, local(24*time.Hour), local(end), local(8*24*time.Hour))
+		return strings.Replace(data, "Europe/Paris", "UTC", -1)
+	}
+
+	alice := &Identity{Name: "alice", Groups: []string{"payments-team"}, Token: true}
+	code, report := uploadCalendarAs(t, &app, alice, notice("REQUEST", 26*time.Hour), "team=payments")
+	if code != http.StatusOK || len(report.Created) != 1 {
+		t.Fatalf("unexpected upload: '%d' '%+v'", code, report)
+	}
+	m, _ := app.maintenanceStore().FindBySource("ics:notice-42@vendor.example")
+
+	// the maintenance of alice is outside of the scopes of bob, whatever the matchers he uploads with
+	bob := &Identity{Name: "bob", Token: true}
+	for _, method := range []string{"REQUEST", "CANCEL"} {
+		code, report = uploadCalendarAs(t, &app, bob, notice(method, 27*time.Hour), "team=db", "env=staging")
+		if code != http.StatusForbidden || len(report.Forbidden) != 1 || report.Forbidden[0].MaintenanceID != m.ID {
+			t.Errorf("unexpected %s by someone else: '%d' '%+v'", method, code, report)
+		}
+	}
+	if got, _ := app.maintenanceStore().Get(m.ID); got.State != stateScheduled || !reflect.DeepEqual(got.Request, m.Request) {
+		t.Errorf("maintenance should be left as is, got '%+v'", got)
+	}
+	if got := len(am.active()); got != 3 {
+		t.Errorf("wrong number of active silences: got '%d' want '%d'", got, 3)
+	}
+
+	// a member of the same team may cancel it
+	dave := &Identity{Name: "dave", Groups: []string{"payments-team"}, Token: true}
+	code, report = uploadCalendarAs(t, &app, dave, notice("CANCEL", 27*time.Hour), "team=payments")
+	if code != http.StatusOK || len(report.Cancelled) != 1 || len(am.active()) != 0 {
+		t.Errorf("unexpected cancellation: '%d' '%+v'", code, report)
+	}
+}
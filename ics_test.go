@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFoldICSLine(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("é", 60)
+	folded := foldICSLine(line)
+
+	for _, l := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(l) > icsLineLength {
+			t.Errorf("line longer than %d octets: '%s'", icsLineLength, l)
+		}
+	}
+	if unfolded := strings.Replace(strings.TrimSuffix(folded, "\r\n"), "\r\n ", "", -1); unfolded != line {
+		t.Errorf("unfolding does not give the original line back\ngot: '%s'\nwant: '%s'", unfolded, line)
+	}
+}
+
+func TestICSEscaper(t *testing.T) {
+	var cases = []struct {
+		text string
+		want string
+	}{
+		{`a\b;c,d`, `a\\b\;c\,d`},
+		// every line break becomes an escaped newline, a lone carriage return would break the content line
+		{"a\r\nb\nc\rd", `a\nb\nc\nd`},
+	}
+
+	for _, c := range cases {
+		if got := icsEscaper.Replace(c.text); got != c.want {
+			t.Errorf("wrong escaping of %q: got '%s' want '%s'", c.text, got, c.want)
+		}
+	}
+}
+
+func TestCalendarFilter_selects(t *testing.T) {
+	var cases = []struct {
+		filter   string
+		matchers []Matcher
+		want     bool
+	}{
+		{`team=db`, []Matcher{{Name: "team", Value: "db"}}, true},
+		{`team="db"`, []Matcher{{Name: "team", Value: "db"}}, true},
+		{`team=db`, []Matcher{{Name: "team", Value: "db|net", IsRegex: true}}, true},
+		{`team=db`, []Matcher{{Name: "team", Value: "net"}}, false},
+		{`team=db`, []Matcher{{Name: "job", Value: "db"}}, false},
+		{`env=~prod-.*`, []Matcher{{Name: "env", Value: "prod-eu"}}, true},
+		{`env=~prod-.*`, []Matcher{{Name: "env", Value: "prod-.*", IsRegex: true}}, true},
+		{`env=~prod-.*`, []Matcher{{Name: "env", Value: "staging"}}, false},
+	}
+
+	for _, c := range cases {
+		f, err := parseCalendarFilter(c.filter)
+		if err != nil {
+			t.Fatalf("unable to parse filter '%s': %s", c.filter, err.Error())
+		}
+		if got := f.selects(c.matchers); got != c.want {
+			t.Errorf("selects not returning expected result for => '%s' '%v'", c.filter, c.matchers)
+		}
+	}
+
+	if _, err := parseCalendarFilter("team"); err == nil {
+		t.Errorf("expected an error for a filter without value")
+	}
+}
+
+func TestApp_getCalendar(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	m := newScheduledMaintenance(t, &app, am, now.Add(-48*time.Hour), 4)
+	_, err := am.CreateSilenceWith(now.Add(time.Hour).Format(requestTimeLayout), now.Add(2*time.Hour).Format(requestTimeLayout),
+		APISilenceRequest{Comment: "network, switch upgrade", CreatedBy: "bob", Matchers: []Matcher{{Name: "team", Value: "net"}}})
+	if err != nil {
+		t.Fatalf("unable to create silence: %s", err.Error())
+	}
+
+	get := func(query string) string {
+		rr := httptest.NewRecorder()
+		app.getCalendar(rr, httptest.NewRequest("GET", "/api/v1/calendar.ics"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusOK)
+		}
+		// unfold lines to look for whole properties
+		return strings.Replace(rr.Body.String(), "\r\n ", "", -1)
+	}
+
+	feed := get("")
+	if !strings.HasPrefix(feed, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(feed, "END:VCALENDAR\r\n") {
+		t.Errorf("feed is not a calendar:\n%s", feed)
+	}
+	// two occurrences of the maintenance are left, plus the manual silence
+	if got := strings.Count(feed, "BEGIN:VEVENT"); got != 3 {
+		t.Errorf("wrong number of events: got '%d' want '%d'", got, 3)
+	}
+	if !strings.Contains(feed, `DESCRIPTION:Matchers: {team="net"}\nComment: network\, switch upgrade`) {
+		t.Errorf("description should contain the matchers and the escaped comment:\n%s", feed)
+	}
+
+	// UIDs do not change from one request to the next
+	uid := fmt.Sprintf("%s-%d@%s", m.ID, now.Add(24*time.Hour).Unix(), icsUIDDomain)
+	if !strings.Contains(feed, "UID:"+uid) || !strings.Contains(get(""), "UID:"+uid) {
+		t.Errorf("UIDs should be stable, expected '%s' in:\n%s", uid, feed)
+	}
+
+	filtered := get("?filter=team%3Dnet")
+	if got := strings.Count(filtered, "BEGIN:VEVENT"); got != 1 {
+		t.Errorf("wrong number of filtered events: got '%d' want '%d'", got, 1)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// Candidate silences created outside of the scheduler which look like a single maintenance
+type Candidate struct {
+	ID         string    `json:"id"`
+	Comment    string    `json:"comment"`
+	CreatedBy  string    `json:"createdBy"`
+	Matchers   []Matcher `json:"matchers"`
+	SilenceIDs []string  `json:"silenceIDs"`
+	// Schedule the series the silences follow, only set when they repeat at a regular interval
+	Schedule *Schedule `json:"schedule,omitempty"`
+	// Reason why the candidate cannot be adopted
+	Reason string `json:"reason,omitempty"`
+}
+
+// request returns the request the silences of the candidate could have been created from
+func (c Candidate) request() APISilenceRequest {
+	return APISilenceRequest{
+		Comment:   c.Comment,
+		CreatedBy: c.CreatedBy,
+		Matchers:  c.Matchers,
+		Schedule:  *c.Schedule,
+	}
+}
+
+// candidateKey identifies the silences belonging to the same candidate, whatever the order of their matchers
+func candidateKey(s *models.GettableSilence) string {
+	var matchers []string
+	for _, m := range matchersFromModel(s.Matchers) {
+		matchers = append(matchers, m.String())
+	}
+	sort.Strings(matchers)
+	return fmt.Sprintf("%s\x00%s\x00%s", *s.CreatedBy, *s.Comment, strings.Join(matchers, ","))
+}
+
+// detectSchedule returns the schedule followed by silences sorted by end, or the reason why they do not follow one.
+// Ends are used rather than starts since Alertmanager moves the start of silences created in the past.
+func detectSchedule(silences []*models.GettableSilence) (*Schedule, string) {
+	last := silences[len(silences)-1]
+	duration := time.Time(*last.EndsAt).Sub(time.Time(*last.StartsAt))
+	firstEnd := time.Time(*silences[0].EndsAt).UTC()
+
+	s := &Schedule{
+		StartTime: firstEnd.Add(-duration).Format(requestTimeLayout),
+		EndTime:   firstEnd.Format(requestTimeLayout),
+		Repeat:    Repeat{Count: len(silences)},
+	}
+	if len(silences) == 1 {
+		return s, ""
+	}
+	if len(silences) > scheduleCountMax {
+		return nil, fmt.Sprintf("series of %d silences is longer than %d", len(silences), scheduleCountMax)
+	}
+
+	step := time.Time(*silences[1].EndsAt).Sub(time.Time(*silences[0].EndsAt))
+	for name, hours := range intervalTable {
+		if step == time.Hour*hours {
+			s.Repeat.Interval = name
+		}
+	}
+	if s.Repeat.Interval == "" {
+		return nil, fmt.Sprintf("silences are %s apart, which is not a supported interval", step)
+	}
+
+	for i, silence := range silences {
+		if i > 0 && time.Time(*silence.EndsAt).Sub(time.Time(*silences[i-1].EndsAt)) != step {
+			return nil, "silences are not evenly spaced"
+		}
+		// the start of active silences may have been moved to their creation time
+		if silence.Status != nil && silence.Status.State != nil && *silence.Status.State == models.SilenceStatusStateActive {
+			continue
+		}
+		if time.Time(*silence.EndsAt).Sub(time.Time(*silence.StartsAt)) != duration {
+			return nil, "silences do not all last the same time"
+		}
+	}
+	return s, ""
+}
+
+// importCandidates groups the silences of Alertmanager which are neither expired nor managed by
+// the scheduler into candidates, silences with the same matchers, creator and comment being grouped
+func (a *App) importCandidates() ([]Candidate, error) {
+	list, err := a.amClient().ListSilences()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve silences: %s", err.Error())
+	}
+
+	groups := map[string][]*models.GettableSilence{}
+	for _, s := range list {
+		if s == nil || s.ID == nil || s.Comment == nil || s.CreatedBy == nil || s.StartsAt == nil || s.EndsAt == nil {
+			continue
+		}
+		if s.Status != nil && s.Status.State != nil && *s.Status.State == models.SilenceStatusStateExpired {
+			continue
+		}
+		if _, ok := managedName(*s.Comment); ok {
+			continue
+		}
+		if _, ok := a.maintenanceStore().FindBySilenceID(*s.ID); ok {
+			continue
+		}
+		key := candidateKey(s)
+		groups[key] = append(groups[key], s)
+	}
+
+	candidates := []Candidate{}
+	for key, silences := range groups {
+		sort.Slice(silences, func(i, j int) bool {
+			return time.Time(*silences[i].EndsAt).Before(time.Time(*silences[j].EndsAt))
+		})
+
+		c := Candidate{
+			Comment:   *silences[0].Comment,
+			CreatedBy: *silences[0].CreatedBy,
+			Matchers:  matchersFromModel(silences[0].Matchers),
+		}
+		for _, s := range silences {
+			c.SilenceIDs = append(c.SilenceIDs, *s.ID)
+		}
+		// the ID changes as soon as the silences of the candidate change
+		c.ID = fmt.Sprintf("%x", sha256.Sum256([]byte(key+"\x00"+strings.Join(c.SilenceIDs, ","))))[:16]
+		c.Schedule, c.Reason = detectSchedule(silences)
+		candidates = append(candidates, c)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].CreatedBy != candidates[j].CreatedBy {
+			return candidates[i].CreatedBy < candidates[j].CreatedBy
+		}
+		return candidates[i].Comment < candidates[j].Comment
+	})
+	return candidates, nil
+}
+
+func (a *App) getImportCandidates(w http.ResponseWriter, r *http.Request) {
+	candidates, err := a.importCandidates()
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadGateway, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(candidates)
+}
+
+// adoptCandidate stores the candidate as a scheduled maintenance owning its silences, nothing is
+// created in Alertmanager
+func (a *App) adoptCandidate(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	candidates, err := a.importCandidates()
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadGateway, w)
+		return
+	}
+
+	var found *Candidate
+	for i := range candidates {
+		if candidates[i].ID == id {
+			found = &candidates[i]
+		}
+	}
+	if found == nil {
+		writeErrorWithCode(fmt.Sprintf("candidate '%s' not found, its silences may have changed", id), http.StatusNotFound, w)
+		return
+	}
+	if found.Schedule == nil {
+		writeErrorWithCode(fmt.Sprintf("candidate '%s' cannot be adopted: %s", id, found.Reason), http.StatusConflict, w)
+		return
+	}
+
+	m := Maintenance{ID: newID(), State: stateScheduled, Request: found.request(), SilenceIDs: found.SilenceIDs}
+	m.Request.ID = m.ID
+
+	// adopted silences are managed by the scheduler from then on, like the ones of a new maintenance
+	overrides := &overrideLog{}
+	msg, ok := m.Request.Valid(a.accessFor(r).rule(), a.freezeRule(r, m.ID, overrides))
+	if !ok {
+		writeErrorWithCode(fmt.Sprintf("candidate '%s' cannot be adopted: %s", id, msg), http.StatusBadRequest, w)
+		return
+	}
+
+	err = a.maintenanceStore().Add(m)
+	if err != nil {
+		writeError(fmt.Sprintf("unable to store maintenance: %s", err.Error()), w)
+		return
+	}
+	a.audit(r, AuditEntry{
+		Action:        actionAdopt,
+		MaintenanceID: m.ID,
+		SilenceIDs:    m.SilenceIDs,
+		After:         auditState(m),
+	})
+	a.recordOverrides(r, overrides, m.ID)
+
+	resp := APIResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("%d silence(s) adopted as maintenance '%s'", len(m.SilenceIDs), m.ID),
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func createManualSilences(t *testing.T, am *fakeAlertmanager, r APISilenceRequest, starts ...time.Time) {
+	for _, start := range starts {
+		_, err := am.CreateSilenceWith(start.Format(requestTimeLayout), start.Add(2*time.Hour).Format(requestTimeLayout), r)
+		if err != nil {
+			t.Fatalf("unable to create silence: %s", err.Error())
+		}
+	}
+}
+
+func TestApp_importCandidates(t *testing.T) {
+	now := time.Date(2021, 10, 12, 12, 0, 0, 0, time.UTC)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+
+	weekly := APISilenceRequest{Comment: "backup", CreatedBy: "alice",
+		Matchers: []Matcher{{Name: "team", Value: "db"}, {Name: "job", Value: "backup"}}}
+	reordered := weekly
+	reordered.Matchers = []Matcher{{Name: "job", Value: "backup"}, {Name: "team", Value: "db"}}
+	irregular := APISilenceRequest{Comment: "network", CreatedBy: "bob", Matchers: []Matcher{{Name: "team", Value: "net"}}}
+
+	// the first one is in progress, its start is moved to its creation time
+	createManualSilences(t, am, weekly, now.Add(-time.Hour), now.Add(167*time.Hour))
+	createManualSilences(t, am, reordered, now.Add(335*time.Hour))
+	createManualSilences(t, am, irregular, now.Add(24*time.Hour), now.Add(50*time.Hour))
+
+	// managed silences are left out
+	managed := weekly
+	managed.Managed = "backup"
+	createManualSilences(t, am, managed, now.Add(24*time.Hour))
+	newScheduledMaintenance(t, &app, am, now.Add(24*time.Hour), 1)
+
+	candidates, err := app.importCandidates()
+	if err != nil {
+		t.Fatalf("unable to list candidates: %s", err.Error())
+	}
+	if len(candidates) != 2 {
+		t.Fatalf("wrong number of candidates: got '%d' want '%d', '%+v'", len(candidates), 2, candidates)
+	}
+
+	series, odd := candidates[0], candidates[1]
+	if series.CreatedBy != "alice" || len(series.SilenceIDs) != 3 || series.Schedule == nil {
+		t.Fatalf("unexpected weekly candidate: '%+v'", series)
+	}
+	want := Schedule{
+		StartTime: "2021-10-12T11:00:00.000Z",
+		EndTime:   "2021-10-12T13:00:00.000Z",
+		Repeat:    Repeat{Interval: "w", Count: 3},
+	}
+	if !reflect.DeepEqual(*series.Schedule, want) {
+		t.Errorf("unexpected schedule\ngot: '%+v'\nwant: '%+v'", *series.Schedule, want)
+	}
+	if odd.Schedule != nil || odd.Reason == "" {
+		t.Errorf("irregular silences should not have a schedule: '%+v'", odd)
+	}
+
+	// adopting the series makes it a maintenance without creating any silence
+	created := len(am.silences)
+	req := httptest.NewRequest("POST", "/api/v1/import/candidates/"+series.ID+"/adopt", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": series.ID})
+	req = withIdentity(req, &Identity{Name: "carol", Token: true})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.adoptCandidate).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status code: got '%d' want '%d', body: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if len(am.silences) != created {
+		t.Errorf("no silence should be created on adoption")
+	}
+
+	m, ok := app.maintenanceStore().FindBySilenceID(series.SilenceIDs[0])
+	if !ok || m.State != stateScheduled || m.Request.CreatedBy != "alice" || len(m.SilenceIDs) != 3 {
+		t.Errorf("unexpected adopted maintenance: '%+v'", m)
+	}
+	items, err := app.checkDrift(now)
+	if err != nil {
+		t.Fatalf("unable to check drift: %s", err.Error())
+	}
+	if len(items) != 0 {
+		t.Errorf("adopted silences should not be drifting, got '%+v'", items)
+	}
+
+	candidates, err = app.importCandidates()
+	if err != nil {
+		t.Fatalf("unable to list candidates: %s", err.Error())
+	}
+	if len(candidates) != 1 {
+		t.Errorf("adopted silences should not be candidates anymore, got '%+v'", candidates)
+	}
+
+	// irregular candidates cannot be adopted
+	req = httptest.NewRequest("POST", "/api/v1/import/candidates/"+odd.ID+"/adopt", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": odd.ID})
+	req = withIdentity(req, &Identity{Name: "carol", Token: true})
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.adoptCandidate).ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("wrong status code: got '%d' want '%d'", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestApp_adoptCandidate_freeze(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{Freeze: FreezeConfig{Periods: []FreezePeriod{
+		{Name: "audit", Start: now.Add(-time.Hour), End: now.Add(24 * time.Hour), Scopes: []LabelScope{{"team": "db"}}},
+	}}}, client: am}
+
+	weekly := APISilenceRequest{Comment: "backup", CreatedBy: "alice", Matchers: []Matcher{{Name: "team", Value: "db"}}}
+	createManualSilences(t, am, weekly, now.Add(48*time.Hour), now.Add(216*time.Hour))
+	candidates, err := app.importCandidates()
+	if err != nil || len(candidates) != 1 {
+		t.Fatalf("unexpected candidates: '%+v' '%v'", candidates, err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/import/candidates/"+candidates[0].ID+"/adopt", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": candidates[0].ID})
+	req = withIdentity(req, &Identity{Name: "carol", Token: true})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.adoptCandidate).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "freeze 'audit'") {
+		t.Errorf("expected the adoption to be frozen, got '%d' '%s'", rr.Code, rr.Body.String())
+	}
+	if len(app.maintenanceStore().List()) != 0 {
+		t.Errorf("nothing should be adopted during a freeze")
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// kubernetesManagedPrefix starts the managed name of the silences of a MaintenanceWindow resource
+	kubernetesManagedPrefix = "k8s."
+
+	defaultKubernetesServer    = "https://kubernetes.default.svc"
+	defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultKubernetesCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	defaultKubernetesInterval  = 30 * time.Second
+	kubernetesActor            = "kubernetes"
+
+	phaseScheduled = "Scheduled"
+	phaseActive    = "Active"
+	phaseCompleted = "Completed"
+	phaseInvalid   = "Invalid"
+)
+
+// maintenanceWindowResource the custom resource of the MaintenanceWindow resources
+var maintenanceWindowResource = schema.GroupVersionResource{Group: "ams.fxinnovation.com", Version: "v1alpha1", Resource: "maintenancewindows"}
+
+// KubernetesConfig cluster whose MaintenanceWindow resources are reconciled against Alertmanager
+type KubernetesConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Server    string        `yaml:"server"`
+	TokenFile string        `yaml:"token_file"`
+	CAFile    string        `yaml:"ca_file"`
+	Namespace string        `yaml:"namespace"`
+	Interval  time.Duration `yaml:"interval"`
+
+	// Namespaces scopes the resources of each namespace can silence alerts within, like the RBAC roles of users
+	Namespaces map[string][]LabelScope `yaml:"namespaces"`
+}
+
+func (c KubernetesConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	for namespace, scopes := range c.Namespaces {
+		for _, s := range scopes {
+			if len(s) == 0 {
+				return fmt.Errorf("kubernetes namespace '%s' has an empty scope", namespace)
+			}
+		}
+	}
+	if c.Server == "" {
+		return nil
+	}
+	u, err := url.Parse(c.Server)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid kubernetes server '%s'", c.Server)
+	}
+	return nil
+}
+
+// access returns what the resources of the namespace are allowed to silence, they are restricted to the
+// scopes of their namespace as soon as namespaces are mapped or RBAC roles restrict users
+func (c KubernetesConfig) access(rbac RBACConfig, namespace string) access {
+	return access{enabled: rbac.enabled() || len(c.Namespaces) > 0, scopes: c.Namespaces[namespace]}
+}
+
+func (c KubernetesConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultKubernetesInterval
+	}
+	return c.Interval
+}
+
+// MaintenanceWindow a maintenance defined as a Kubernetes custom resource
+type MaintenanceWindow struct {
+	Metadata ObjectMeta              `json:"metadata"`
+	Spec     MaintenanceWindowSpec   `json:"spec"`
+	Status   MaintenanceWindowStatus `json:"status,omitempty"`
+}
+
+// ObjectMeta the metadata of a Kubernetes resource the controller relies on
+type ObjectMeta struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Generation int64  `json:"generation,omitempty"`
+}
+
+// MaintenanceWindowSpec the maintenance requested by a MaintenanceWindow resource
+type MaintenanceWindowSpec struct {
+	Comment   string                    `json:"comment"`
+	Ticket    string                    `json:"ticket,omitempty"`
+	CreatedBy string                    `json:"createdBy,omitempty"`
+	Matchers  []Matcher                 `json:"matchers"`
+	Schedule  MaintenanceWindowSchedule `json:"schedule"`
+}
+
+// MaintenanceWindowSchedule the recurrence of a MaintenanceWindow resource
+type MaintenanceWindowSchedule struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Repeat Repeat    `json:"repeat,omitempty"`
+}
+
+// MaintenanceWindowStatus what the controller reports back on a MaintenanceWindow resource
+type MaintenanceWindowStatus struct {
+	Phase              string            `json:"phase,omitempty"`
+	Message            string            `json:"message,omitempty"`
+	SilenceIDs         []string          `json:"silenceIDs,omitempty"`
+	NextOccurrence     *OccurrenceStatus `json:"nextOccurrence,omitempty"`
+	ObservedGeneration int64             `json:"observedGeneration,omitempty"`
+}
+
+// OccurrenceStatus an occurrence reported in the status of a MaintenanceWindow resource
+type OccurrenceStatus struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// managedName returns the name tagging the silences of the resource, namespaces cannot contain dots
+// so the name is never ambiguous
+func (w MaintenanceWindow) managedName() string {
+	return kubernetesManagedPrefix + w.Metadata.Namespace + "." + w.Metadata.Name
+}
+
+// declaration converts the resource into the declared maintenance it stands for
+func (w MaintenanceWindow) declaration() DeclaredMaintenance {
+	var matchers []DeclaredMatcher
+	for _, m := range w.Spec.Matchers {
+		matchers = append(matchers, DeclaredMatcher{Name: m.Name, Value: m.Value, IsRegex: m.IsRegex})
+	}
+	owner := w.Spec.CreatedBy
+	if owner == "" {
+		owner = kubernetesActor
+	}
+	return DeclaredMaintenance{
+		Name:     w.managedName(),
+		Owner:    owner,
+		Comment:  w.Spec.Comment,
+		Ticket:   w.Spec.Ticket,
+		Matchers: matchers,
+		Schedule: DeclaredSchedule{
+			Start:  w.Spec.Schedule.Start,
+			End:    w.Spec.Schedule.End,
+			Repeat: w.Spec.Schedule.Repeat,
+		},
+		file: fmt.Sprintf("MaintenanceWindow %s/%s", w.Metadata.Namespace, w.Metadata.Name),
+	}
+}
+
+// status returns the status of the resource given its schedule, its silences which are not expired yet,
+// and the reason why it is invalid if it is
+func (w MaintenanceWindow) status(schedule Schedule, silences []*models.GettableSilence, invalid string, now time.Time) MaintenanceWindowStatus {
+	status := MaintenanceWindowStatus{ObservedGeneration: w.Metadata.Generation, Phase: phaseCompleted}
+	for _, s := range silences {
+		status.SilenceIDs = append(status.SilenceIDs, *s.ID)
+	}
+	sort.Strings(status.SilenceIDs)
+
+	if invalid != "" {
+		status.Phase = phaseInvalid
+		status.Message = invalid
+		return status
+	}
+
+	occurrences, _ := schedule.occurrences()
+	for _, o := range occurrences {
+		start, err := time.Parse(requestTimeLayout, o.Start)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil || !end.After(now) {
+			continue
+		}
+		status.Phase = phaseScheduled
+		if !start.After(now) {
+			status.Phase = phaseActive
+		}
+		status.NextOccurrence = &OccurrenceStatus{Start: o.Start, End: o.End}
+		break
+	}
+	return status
+}
+
+// windowRules returns the rules applied to the resource on top of the ones of declared maintenances: the
+// matchers must be within the scopes of its namespace, and as resources cannot go through the approval
+// flow, the ones needing an approval are rejected
+func (a *App) windowRules(w MaintenanceWindow, now time.Time) []ValidationRule {
+	c := a.conf()
+	rules := a.declarationRules(now)
+	return append(rules, c.Kubernetes.access(c.RBAC, w.Metadata.Namespace).rule(), c.Approval.rule("MaintenanceWindow resources"))
+}
+
+// windowFromObject converts a resource of the informer cache, the metadata and status are returned
+// along with the error when the spec cannot be decoded
+func windowFromObject(obj runtime.Object) (MaintenanceWindow, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return MaintenanceWindow{}, fmt.Errorf("unexpected object of type %T", obj)
+	}
+
+	w := MaintenanceWindow{Metadata: ObjectMeta{Name: u.GetName(), Namespace: u.GetNamespace(), Generation: u.GetGeneration()}}
+	// the status is written by the controller, a status which cannot be decoded is simply replaced
+	_ = convertField(u.Object["status"], &w.Status)
+	err := convertField(u.Object["spec"], &w.Spec)
+	if err != nil {
+		return w, fmt.Errorf("invalid spec: %s", err.Error())
+	}
+	return w, nil
+}
+
+// convertField decodes a field of an unstructured resource through its JSON encoding
+func convertField(in, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// newKubernetesClient returns a dynamic client authenticated with the service account token, both the
+// token and the CA are optional so that the client can be pointed at a proxy such as "kubectl proxy".
+// The token file is read again when it is rotated.
+func newKubernetesClient(c KubernetesConfig) (dynamic.Interface, error) {
+	server := c.Server
+	if server == "" {
+		server = defaultKubernetesServer
+	}
+	tokenFile := c.TokenFile
+	if tokenFile == "" {
+		tokenFile = defaultKubernetesTokenFile
+	}
+	caFile := c.CAFile
+	if caFile == "" {
+		caFile = defaultKubernetesCAFile
+	}
+
+	config := &rest.Config{Host: server}
+	if _, err := os.Stat(tokenFile); err == nil {
+		config.BearerTokenFile = tokenFile
+	}
+	if _, err := os.Stat(caFile); err == nil {
+		config.TLSClientConfig.CAFile = caFile
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kubernetes client: %s", err.Error())
+	}
+	return client, nil
+}
+
+// updateWindowStatus replaces the status of the resource through its status subresource, as a patch so it
+// never conflicts with changes made to the spec in the meantime
+func updateWindowStatus(client dynamic.Interface, w MaintenanceWindow) error {
+	patch, err := json.Marshal([]map[string]interface{}{{"op": "add", "path": "/status", "value": w.Status}})
+	if err != nil {
+		return fmt.Errorf("unable to marshal status: %s", err.Error())
+	}
+	_, err = client.Resource(maintenanceWindowResource).Namespace(w.Metadata.Namespace).
+		Patch(context.Background(), w.Metadata.Name, types.JSONPatchType, patch, metav1.PatchOptions{}, "status")
+	return err
+}
+
+// reconcileMaintenanceWindows creates, updates and expires the silences of the MaintenanceWindow resources
+// of the informer cache like the ones of declared maintenances, then writes the status of every resource
+// which changed
+func (a *App) reconcileMaintenanceWindows(client dynamic.Interface, lister cache.GenericLister, namespace string, now time.Time) (ReconcileResult, error) {
+	var res ReconcileResult
+
+	objects, err := lister.List(labels.Everything())
+	if err != nil {
+		return res, fmt.Errorf("unable to list MaintenanceWindow resources: %s", err.Error())
+	}
+
+	invalid := map[string]string{}
+	var windows []MaintenanceWindow
+	for _, obj := range objects {
+		w, err := windowFromObject(obj)
+		if err != nil {
+			invalid[w.managedName()] = err.Error()
+		}
+		windows = append(windows, w)
+	}
+
+	silences, err := a.amClient().ListSilences()
+	if err != nil {
+		return res, fmt.Errorf("unable to retrieve silences: %s", err.Error())
+	}
+	managed := managedSilences(silences)
+
+	valid := map[string]APISilenceRequest{}
+	for _, w := range windows {
+		name := w.managedName()
+		// an invalid resource keeps its silences untouched until it is fixed
+		if msg, ok := invalid[name]; ok {
+			log.Printf("MaintenanceWindow '%s/%s' is invalid: %s\n", w.Metadata.Namespace, w.Metadata.Name, msg)
+			res.Errors++
+			delete(managed, name)
+			continue
+		}
+		err = a.reconcileDeclared(w.declaration(), a.windowRules(w, now), managed[name], now, &res)
+		if err != nil {
+			log.Println(err)
+			invalid[name] = err.Error()
+			res.Errors++
+		} else {
+			valid[name], _ = a.conf().applyCalendar(w.declaration().request())
+		}
+		delete(managed, name)
+	}
+	a.setManagedWindows(kubernetesActor, valid)
+
+	// deleted resources, the ones of other namespaces are left alone when the controller is restricted to one
+	owned := kubernetesManagedPrefix
+	if namespace != "" {
+		owned += namespace + "."
+	}
+	for name, list := range managed {
+		if !strings.HasPrefix(name, owned) {
+			continue
+		}
+		for _, s := range list {
+			res.add(a.expireDeclared(name, s))
+		}
+	}
+
+	silences, err = a.amClient().ListSilences()
+	if err != nil {
+		return res, fmt.Errorf("unable to retrieve silences: %s", err.Error())
+	}
+	managed = managedSilences(silences)
+	for _, w := range windows {
+		name := w.managedName()
+		// the schedule is only used by valid resources, whose calendar applies
+		r, _ := a.conf().applyCalendar(w.declaration().request())
+		status := w.status(r.Schedule, managed[name], invalid[name], now)
+		if reflect.DeepEqual(status, w.Status) {
+			continue
+		}
+		w.Status = status
+		err = updateWindowStatus(client, w)
+		if err != nil {
+			log.Printf("unable to update status of MaintenanceWindow '%s/%s': %s\n", w.Metadata.Namespace, w.Metadata.Name, err.Error())
+			res.Errors++
+		}
+	}
+	return res, nil
+}
+
+// kubernetesLoop runs the controller of the MaintenanceWindow resources while the controller mode is enabled,
+// it is restarted whenever its configuration changes
+func (a *App) kubernetesLoop() {
+	for {
+		c := a.conf().Kubernetes
+		if !c.Enabled {
+			a.setManagedWindows(kubernetesActor, nil)
+			time.Sleep(c.interval())
+			continue
+		}
+
+		client, err := newKubernetesClient(c)
+		if err != nil {
+			log.Println(err)
+			time.Sleep(c.interval())
+			continue
+		}
+		a.watchMaintenanceWindows(client, c)
+	}
+}
+
+// watchMaintenanceWindows reconciles the MaintenanceWindow resources whenever one of them changes, and at
+// least once per interval for the occurrences starting and ending, until the configuration changes
+func (a *App) watchMaintenanceWindows(client dynamic.Interface, c KubernetesConfig) {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, c.Namespace, nil)
+	informer := factory.ForResource(maintenanceWindowResource)
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+
+	ticker := time.NewTicker(c.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-changed:
+		case <-ticker.C:
+		}
+		if !reflect.DeepEqual(a.conf().Kubernetes, c) {
+			return
+		}
+		// an empty cache would get the silences of every resource expired
+		if !informer.Informer().HasSynced() {
+			continue
+		}
+
+		res, err := a.reconcileMaintenanceWindows(client, informer.Lister(), c.Namespace, time.Now())
+		if err != nil {
+			log.Printf("unable to reconcile MaintenanceWindow resources: %s\n", err.Error())
+			continue
+		}
+		if res != (ReconcileResult{}) {
+			log.Printf("MaintenanceWindow resources reconciled: %d created, %d updated, %d expired, %d errors\n",
+				res.Created, res.Updated, res.Expired, res.Errors)
+		}
+	}
+}
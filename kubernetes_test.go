@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newFakeCluster returns a fake API server holding the MaintenanceWindow resources
+func newFakeCluster(windows ...MaintenanceWindow) *dynamicfake.FakeDynamicClient {
+	var objects []runtime.Object
+	for _, w := range windows {
+		objects = append(objects, windowObject(w))
+	}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{maintenanceWindowResource: "MaintenanceWindowList"}, objects...)
+}
+
+func windowObject(w MaintenanceWindow) *unstructured.Unstructured {
+	b, _ := json.Marshal(w)
+	u := &unstructured.Unstructured{}
+	json.Unmarshal(b, &u.Object)
+	// numbers are decoded as floats, the API server returns the generation as an integer
+	u.SetGeneration(w.Metadata.Generation)
+	u.SetAPIVersion(maintenanceWindowResource.GroupVersion().String())
+	u.SetKind("MaintenanceWindow")
+	return u
+}
+
+// put creates or updates the resource, increasing its generation like the API server
+func put(t *testing.T, client *dynamicfake.FakeDynamicClient, w MaintenanceWindow) {
+	resources := client.Resource(maintenanceWindowResource).Namespace(w.Metadata.Namespace)
+	current, err := resources.Get(context.Background(), w.Metadata.Name, metav1.GetOptions{})
+	if err != nil {
+		w.Metadata.Generation = 1
+		_, err = resources.Create(context.Background(), windowObject(w), metav1.CreateOptions{})
+	} else {
+		w.Metadata.Generation = current.GetGeneration() + 1
+		_, err = resources.Update(context.Background(), windowObject(w), metav1.UpdateOptions{})
+	}
+	if err != nil {
+		t.Fatalf("unable to put MaintenanceWindow: %s", err.Error())
+	}
+}
+
+func get(t *testing.T, client *dynamicfake.FakeDynamicClient, namespace, name string) MaintenanceWindow {
+	u, err := client.Resource(maintenanceWindowResource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get MaintenanceWindow: %s", err.Error())
+	}
+	w, _ := windowFromObject(u)
+	return w
+}
+
+// listerOf returns a lister of the resources of the namespace, all of them when empty, like a synced informer
+func listerOf(t *testing.T, client *dynamicfake.FakeDynamicClient, namespace string) cache.GenericLister {
+	list, err := client.Resource(maintenanceWindowResource).Namespace(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unable to list MaintenanceWindow resources: %s", err.Error())
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := range list.Items {
+		indexer.Add(&list.Items[i])
+	}
+	return cache.NewGenericLister(indexer, maintenanceWindowResource.GroupResource())
+}
+
+// statusUpdates returns the number of status patches sent to the cluster
+func statusUpdates(client *dynamicfake.FakeDynamicClient) int {
+	n := 0
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "patch" && a.GetSubresource() == "status" {
+			n++
+		}
+	}
+	return n
+}
+
+func testWindow(namespace, name string, start time.Time, count int) MaintenanceWindow {
+	return MaintenanceWindow{
+		Metadata: ObjectMeta{Name: name, Namespace: namespace},
+		Spec: MaintenanceWindowSpec{
+			Comment:   "DB patching",
+			CreatedBy: "platform",
+			Matchers:  []Matcher{{Name: "team", Value: "db"}},
+			Schedule: MaintenanceWindowSchedule{
+				Start:  start,
+				End:    start.Add(time.Hour),
+				Repeat: Repeat{Interval: "d", Count: count},
+			},
+		},
+	}
+}
+
+func TestApp_reconcileMaintenanceWindows(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{}, client: am}
+	k8s := newFakeCluster()
+
+	// a silence of a declared maintenance is never touched by the controller
+	declared := DeclaredMaintenance{Name: "backup", Owner: "ops", Comment: "Backup", Matchers: []DeclaredMatcher{{Name: "job", Value: "backup"}},
+		Schedule: DeclaredSchedule{Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)}}
+	_, err := am.CreateSilenceWith(declared.request().Schedule.StartTime, declared.request().Schedule.EndTime, declared.request())
+	if err != nil {
+		t.Fatalf("unable to create silence: %s", err.Error())
+	}
+
+	put(t, k8s, testWindow("db", "patching", now.Add(-30*time.Minute), 3))
+	res, err := app.reconcileMaintenanceWindows(k8s, listerOf(t, k8s, ""), "", now)
+	if err != nil || res != (ReconcileResult{Created: 3}) {
+		t.Fatalf("unexpected first reconciliation: '%+v' '%v'", res, err)
+	}
+	w := get(t, k8s, "db", "patching")
+	if w.Status.Phase != phaseActive || len(w.Status.SilenceIDs) != 3 || w.Status.ObservedGeneration != 1 {
+		t.Errorf("unexpected status: '%+v'", w.Status)
+	}
+	want := OccurrenceStatus{Start: now.Add(-30 * time.Minute).Format(requestTimeLayout), End: now.Add(30 * time.Minute).Format(requestTimeLayout)}
+	if w.Status.NextOccurrence == nil || *w.Status.NextOccurrence != want {
+		t.Errorf("wrong next occurrence: got '%v' want '%v'", w.Status.NextOccurrence, want)
+	}
+	for _, s := range am.active() {
+		if *s.Comment != "DB patching [ams-managed:k8s.db.patching]" && *s.Comment != declared.request().silenceComment() {
+			t.Errorf("unexpected silence comment '%s'", *s.Comment)
+		}
+	}
+
+	// nothing changes, the status is not written again
+	res, err = app.reconcileMaintenanceWindows(k8s, listerOf(t, k8s, ""), "", now)
+	if err != nil || res != (ReconcileResult{}) || statusUpdates(k8s) != 1 {
+		t.Errorf("unexpected second reconciliation: '%+v' '%v' '%d' updates", res, err, statusUpdates(k8s))
+	}
+
+	// the declarative reconciliation leaves the silences of the resources alone
+	dir, err := ioutil.TempDir("", "declarative")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res, err = app.reconcileDeclarations(dir, now)
+	if err != nil || res != (ReconcileResult{Expired: 1}) {
+		t.Errorf("unexpected declarative reconciliation: '%+v' '%v'", res, err)
+	}
+
+	// an invalid spec keeps its silences and reports why
+	invalid := testWindow("db", "patching", now.Add(-30*time.Minute), 3)
+	invalid.Spec.Matchers = nil
+	put(t, k8s, invalid)
+	res, err = app.reconcileMaintenanceWindows(k8s, listerOf(t, k8s, ""), "", now)
+	w = get(t, k8s, "db", "patching")
+	if err != nil || res.Errors != 1 || w.Status.Phase != phaseInvalid || w.Status.Message == "" || len(w.Status.SilenceIDs) != 3 {
+		t.Errorf("unexpected reconciliation of invalid resource: '%+v' '%v' '%+v'", res, err, w.Status)
+	}
+
+	// so does a spec which cannot be decoded
+	u := windowObject(invalid)
+	u.SetGeneration(3)
+	unstructured.SetNestedField(u.Object, "tomorrow", "spec", "schedule", "start")
+	_, err = k8s.Resource(maintenanceWindowResource).Namespace("db").Update(context.Background(), u, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("unable to update MaintenanceWindow: %s", err.Error())
+	}
+	res, err = app.reconcileMaintenanceWindows(k8s, listerOf(t, k8s, ""), "", now)
+	w = get(t, k8s, "db", "patching")
+	if err != nil || res != (ReconcileResult{Errors: 1}) || w.Status.Phase != phaseInvalid || !strings.Contains(w.Status.Message, "invalid spec") ||
+		len(am.active()) != 3 {
+		t.Errorf("unexpected reconciliation of undecodable resource: '%+v' '%v' '%+v'", res, err, w.Status)
+	}
+
+	// resources of other namespaces are ignored when restricted to one, deleted ones get their silences expired
+	put(t, k8s, testWindow("net", "upgrade", now.Add(24*time.Hour), 1))
+	res, err = app.reconcileMaintenanceWindows(k8s, listerOf(t, k8s, "net"), "net", now)
+	if err != nil || res != (ReconcileResult{Created: 1}) {
+		t.Errorf("unexpected reconciliation of namespace: '%+v' '%v'", res, err)
+	}
+	if w = get(t, k8s, "net", "upgrade"); w.Status.Phase != phaseScheduled {
+		t.Errorf("wrong phase: got '%s' want '%s'", w.Status.Phase, phaseScheduled)
+	}
+	err = k8s.Resource(maintenanceWindowResource).Namespace("db").Delete(context.Background(), "patching", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("unable to delete MaintenanceWindow: %s", err.Error())
+	}
+	res, err = app.reconcileMaintenanceWindows(k8s, listerOf(t, k8s, ""), "", now)
+	if err != nil || res != (ReconcileResult{Expired: 3}) {
+		t.Errorf("unexpected reconciliation of deleted resource: '%+v' '%v'", res, err)
+	}
+	if got := len(am.active()); got != 1 {
+		t.Errorf("wrong number of active silences: got '%d' want '%d'", got, 1)
+	}
+}
+
+func TestApp_watchMaintenanceWindows(t *testing.T) {
+	now := time.Now().UTC()
+	am := newFakeAlertmanager(now)
+	c := KubernetesConfig{Enabled: true, Namespace: "db", Interval: 10 * time.Millisecond}
+	app := App{config: &Config{Kubernetes: c}, client: am}
+	k8s := newFakeCluster(testWindow("db", "patching", now.Add(-30*time.Minute), 1), testWindow("net", "upgrade", now.Add(-30*time.Minute), 1))
+
+	done := make(chan struct{})
+	go func() {
+		app.watchMaintenanceWindows(k8s, c)
+		close(done)
+	}()
+	waitFor := func(what string, cond func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	// resources of the watched namespace get silenced, and deleted ones expired, as the changes come
+	waitFor("the resource to be silenced", func() bool { return len(am.active()) == 1 && get(t, k8s, "db", "patching").Status.Phase == phaseActive })
+	if w := get(t, k8s, "net", "upgrade"); w.Status.Phase != "" {
+		t.Errorf("resource of another namespace reconciled: '%+v'", w.Status)
+	}
+	err := k8s.Resource(maintenanceWindowResource).Namespace("db").Delete(context.Background(), "patching", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("unable to delete MaintenanceWindow: %s", err.Error())
+	}
+	waitFor("the silence to be expired", func() bool { return len(am.active()) == 0 })
+
+	// a change of configuration stops the watch
+	app.mu.Lock()
+	app.config = &Config{}
+	app.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch still running after a change of configuration")
+	}
+}
+
+func TestNewKubernetesClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// missing files are ignored, out of the cluster
+	_, err = newKubernetesClient(KubernetesConfig{Server: "https://kubernetes.default.svc", TokenFile: tokenFile, CAFile: filepath.Join(dir, "ca.crt")})
+	if err != nil {
+		t.Errorf("unable to create client: %s", err.Error())
+	}
+	_, err = newKubernetesClient(KubernetesConfig{Server: "://"})
+	if err == nil {
+		t.Error("expected an error with an invalid server")
+	}
+}
+
+func TestApp_windowRules(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	namespaces := map[string][]LabelScope{"db": {{"team": "db"}}}
+	var cases = []struct {
+		config    *Config
+		namespace string
+		matchers  []Matcher
+		want      string
+	}{
+		// no restriction without RBAC, mapped namespaces or approval
+		{&Config{}, "db", []Matcher{{Name: "team", Value: "payments"}}, ""},
+		// resources are confined to the scopes of their namespace
+		{&Config{Kubernetes: KubernetesConfig{Namespaces: namespaces}}, "db", []Matcher{{Name: "team", Value: "db"}}, ""},
+		{&Config{Kubernetes: KubernetesConfig{Namespaces: namespaces}}, "db", []Matcher{{Name: "team", Value: "payments"}}, "matchers must be confined"},
+		{&Config{RBAC: testRBAC}, "db", []Matcher{{Name: "team", Value: "db"}}, "no role allows"},
+		// the ones needing an approval are rejected
+		{&Config{Approval: testApproval, Kubernetes: KubernetesConfig{Namespaces: namespaces}}, "db",
+			[]Matcher{{Name: "team", Value: "db"}, {Name: "env", Value: "prod"}}, "needs an approval"},
+		{&Config{Approval: testApproval, Kubernetes: KubernetesConfig{Namespaces: namespaces}}, "db",
+			[]Matcher{{Name: "team", Value: "db"}, {Name: "env", Value: "staging"}}, ""},
+	}
+
+	for _, c := range cases {
+		app := App{config: c.config}
+		w := testWindow(c.namespace, "patching", now.Add(time.Hour), 1)
+		w.Spec.Matchers = c.matchers
+		msg, ok := w.declaration().request().Valid(app.windowRules(w, now)...)
+		if ok != (c.want == "") || !strings.Contains(msg, c.want) {
+			t.Errorf("wrong validation of '%v' in '%s': got '%s' want '%s'", c.matchers, c.namespace, msg, c.want)
+		}
+	}
+
+	// the reason is reported in the status of the resource and nothing is silenced
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{Approval: testApproval}, client: am}
+	k8s := newFakeCluster()
+	put(t, k8s, testWindow("db", "patching", now.Add(time.Hour), 1))
+	res, err := app.reconcileMaintenanceWindows(k8s, listerOf(t, k8s, ""), "", now)
+	w := get(t, k8s, "db", "patching")
+	if err != nil || res != (ReconcileResult{Errors: 1}) || w.Status.Phase != phaseInvalid || !strings.Contains(w.Status.Message, "needs an approval") {
+		t.Errorf("unexpected reconciliation: '%+v' '%v' '%+v'", res, err, w.Status)
+	}
+	if got := len(am.active()); got != 0 {
+		t.Errorf("wrong number of active silences: got '%d' want '%d'", got, 0)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+const (
+	defaultMaintenanceAlertName     = "MaintenanceInProgress"
+	defaultMaintenanceAlertInterval = time.Minute
+)
+
+// MaintenanceAlertsConfig informational alerts posted to Alertmanager while an occurrence is in progress
+type MaintenanceAlertsConfig struct {
+	Enabled   bool              `yaml:"enabled"`
+	AlertName string            `yaml:"alertname"`
+	Labels    map[string]string `yaml:"labels"`
+	Interval  time.Duration     `yaml:"interval"`
+}
+
+func (c MaintenanceAlertsConfig) validate() error {
+	for name := range c.Labels {
+		if !windowLabelReg.MatchString(name) {
+			return fmt.Errorf("invalid maintenance alert label name '%s'", name)
+		}
+	}
+	return nil
+}
+
+func (c MaintenanceAlertsConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultMaintenanceAlertInterval
+	}
+	return c.Interval
+}
+
+func (c MaintenanceAlertsConfig) alertName() string {
+	if c.AlertName == "" {
+		return defaultMaintenanceAlertName
+	}
+	return c.AlertName
+}
+
+// maintenanceAlert returns the alert of an occurrence in progress, labelled with the equality matchers
+// of the maintenance so inhibition rules can target the alerts it silences
+func (c MaintenanceAlertsConfig) maintenanceAlert(m Maintenance, start, end time.Time) *models.PostableAlert {
+	labels := models.LabelSet{}
+	for name, value := range c.Labels {
+		labels[name] = value
+	}
+	for name, value := range windowLabels(m.Request) {
+		labels[name] = value
+	}
+	labels["alertname"] = c.alertName()
+	labels[windowIDLabel] = m.ID
+
+	annotations := models.LabelSet{
+		"summary":    m.Request.silenceComment(),
+		"created_by": m.Request.CreatedBy,
+	}
+	if m.Request.Ticket != "" {
+		annotations["ticket"] = m.Request.Ticket
+	}
+
+	return &models.PostableAlert{
+		Alert:       models.Alert{Labels: labels},
+		Annotations: annotations,
+		StartsAt:    strfmt.DateTime(start),
+		EndsAt:      strfmt.DateTime(end),
+	}
+}
+
+// activeMaintenanceAlerts returns the alerts of the occurrences in progress, by maintenance and occurrence,
+// for the scheduled maintenances and the valid declared ones and MaintenanceWindow resources
+func (a *App) activeMaintenanceAlerts(now time.Time) map[string]*models.PostableAlert {
+	c := a.conf().MaintenanceAlerts
+	active := map[string]*models.PostableAlert{}
+	for _, m := range a.windowMaintenances() {
+		occurrences, err := m.Request.Schedule.occurrences()
+		if err != nil {
+			log.Printf("unable to list occurrences of maintenance '%s': %s\n", m.ID, err.Error())
+			continue
+		}
+		_, cancelled := m.expiredOnPurpose()
+		for _, o := range occurrences {
+			start, err := time.Parse(requestTimeLayout, o.Start)
+			if err != nil {
+				continue
+			}
+			end, err := time.Parse(requestTimeLayout, o.End)
+			if err != nil || cancelled[o.End] || start.After(now) || !end.After(now) {
+				continue
+			}
+			active[m.ID+"/"+o.Start] = c.maintenanceAlert(m, start, end)
+		}
+	}
+	return active
+}
+
+// pushMaintenanceAlerts posts the alerts of the occurrences in progress to Alertmanager, and resolves
+// the ones posted before whose occurrence was cancelled. It returns the number of alerts posted.
+func (a *App) pushMaintenanceAlerts(now time.Time) (int, error) {
+	active := a.activeMaintenanceAlerts(now)
+
+	a.alertsMu.Lock()
+	defer a.alertsMu.Unlock()
+
+	var keys []string
+	for key := range active {
+		keys = append(keys, key)
+	}
+	for key := range a.postedAlerts {
+		if _, ok := active[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	sort.Strings(keys)
+
+	var alerts models.PostableAlerts
+	for _, key := range keys {
+		if alert, ok := active[key]; ok {
+			alerts = append(alerts, alert)
+			continue
+		}
+		// the occurrence is over or was cancelled, the alert is resolved now in case it ended early
+		resolved := *a.postedAlerts[key]
+		if time.Time(resolved.EndsAt).After(now) {
+			resolved.EndsAt = strfmt.DateTime(now)
+		}
+		alerts = append(alerts, &resolved)
+	}
+
+	err := a.amClient().PostAlerts(alerts)
+	if err != nil {
+		return 0, fmt.Errorf("unable to post maintenance alerts: %s", err.Error())
+	}
+	a.postedAlerts = active
+	return len(alerts), nil
+}
+
+// maintenanceAlertsLoop periodically re-posts the alerts of the occurrences in progress, so they
+// stay active in Alertmanager until the end of the occurrence
+func (a *App) maintenanceAlertsLoop() {
+	for {
+		if a.conf().MaintenanceAlerts.Enabled {
+			_, err := a.pushMaintenanceAlerts(time.Now())
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		time.Sleep(a.conf().MaintenanceAlerts.interval())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+func TestApp_pushMaintenanceAlerts(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{MaintenanceAlerts: MaintenanceAlertsConfig{Enabled: true, Labels: map[string]string{"severity": "info"}}}, client: am}
+
+	// in progress, and upcoming
+	inProgress := newScheduledMaintenance(t, &app, am, now.Add(-30*time.Minute), 2)
+	newScheduledMaintenance(t, &app, am, now.Add(2*time.Hour), 1)
+
+	n, err := app.pushMaintenanceAlerts(now)
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected push: '%d' '%v'", n, err)
+	}
+	alert := am.alerts[0][0]
+	want := models.LabelSet{"alertname": defaultMaintenanceAlertName, "maintenance_id": inProgress.ID, "team": "db", "severity": "info"}
+	if len(alert.Labels) != len(want) {
+		t.Errorf("wrong labels: got '%v' want '%v'", alert.Labels, want)
+	}
+	for name, value := range want {
+		if alert.Labels[name] != value {
+			t.Errorf("wrong label '%s': got '%s' want '%s'", name, alert.Labels[name], value)
+		}
+	}
+	if end := time.Time(alert.EndsAt); !end.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("alert should end with its occurrence, got '%s'", end)
+	}
+
+	// the alert is re-posted while the occurrence is in progress
+	n, err = app.pushMaintenanceAlerts(now.Add(10 * time.Minute))
+	if err != nil || n != 1 {
+		t.Errorf("unexpected second push: '%d' '%v'", n, err)
+	}
+
+	// expiring the occurrence resolves its alert
+	app.markExpired(inProgress.SilenceIDs[0], app.silenceSnapshot(inProgress.SilenceIDs[0]))
+	resolvedAt := now.Add(15 * time.Minute)
+	n, err = app.pushMaintenanceAlerts(resolvedAt)
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected push after expiration: '%d' '%v'", n, err)
+	}
+	if end := time.Time(am.alerts[2][0].EndsAt); !end.Equal(resolvedAt) {
+		t.Errorf("alert should be resolved, got end '%s' want '%s'", end, resolvedAt)
+	}
+
+	// nothing is posted once resolved
+	n, err = app.pushMaintenanceAlerts(now.Add(20 * time.Minute))
+	if err != nil || n != 0 || len(am.alerts) != 3 {
+		t.Errorf("nothing should be posted, got '%d' '%v'", n, err)
+	}
+}
+
+func TestApp_activeMaintenanceAlerts_managed(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	app := App{config: &Config{MaintenanceAlerts: MaintenanceAlertsConfig{Enabled: true}}, client: newFakeAlertmanager(now)}
+
+	// declared maintenances and MaintenanceWindow resources get their alerts too
+	declared := DeclaredMaintenance{Name: "backup", Owner: "ops", Comment: "Backup", Matchers: []DeclaredMatcher{{Name: "job", Value: "backup"}},
+		Schedule: DeclaredSchedule{Start: now.Add(-time.Hour), End: now.Add(time.Hour)}}
+	app.setManagedWindows(declarativeActor, map[string]APISilenceRequest{declared.Name: declared.request()})
+
+	active := app.activeMaintenanceAlerts(now)
+	alert, ok := active["backup/"+now.Add(-time.Hour).Format(requestTimeLayout)]
+	if len(active) != 1 || !ok {
+		t.Fatalf("unexpected alerts: '%v'", active)
+	}
+	if alert.Labels[windowIDLabel] != "backup" || alert.Labels["job"] != "backup" {
+		t.Errorf("wrong labels: '%v'", alert.Labels)
+	}
+
+	app.setManagedWindows(declarativeActor, nil)
+	if active = app.activeMaintenanceAlerts(now); len(active) != 0 {
+		t.Errorf("alerts should be gone with the declaration: '%v'", active)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	eventCreated   = "created"
+	eventStarting  = "starting"
+	eventStarted   = "started"
+	eventEnded     = "ended"
+	eventCancelled = "cancelled"
+
+	receiverWebhook = "webhook"
+	receiverSlack   = "slack"
+	receiverSMTP    = "smtp"
+
+	defaultNotifyInterval  = time.Minute
+	defaultNotifyLeadTime  = 15 * time.Minute
+	defaultReceiverTimeout = 10 * time.Second
+
+	defaultNotifyTemplate = `Maintenance {{ .Event }}{{ if eq .Event "starting" }} in {{ .In }}{{ end }}: {{ .Comment }}
+{{ .Start.UTC.Format "2006-01-02 15:04 MST" }} - {{ .End.UTC.Format "2006-01-02 15:04 MST" }}, silencing {{ matchers .Matchers }} (created by {{ .CreatedBy }})`
+)
+
+var (
+	notifyEvents = map[string]bool{eventCreated: true, eventStarting: true, eventStarted: true, eventEnded: true, eventCancelled: true}
+
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ams",
+		Name:      "notifications_total",
+		Help:      "Number of lifecycle notifications sent, by receiver and result.",
+	}, []string{"receiver", "result"})
+
+	notifyFuncs = template.FuncMap{
+		"matchers": func(matchers []Matcher) string {
+			var s []string
+			for _, m := range matchers {
+				s = append(s, m.String())
+			}
+			return "{" + strings.Join(s, ", ") + "}"
+		},
+	}
+)
+
+func init() {
+	prometheus.MustRegister(notificationsSent)
+}
+
+// NotifyConfig receivers of the maintenance lifecycle notifications
+type NotifyConfig struct {
+	// LeadTime how long before the start of an occurrence the starting notification is sent
+	LeadTime time.Duration `yaml:"lead_time"`
+	// Interval how often occurrences are checked for starting, started and ended notifications
+	Interval  time.Duration    `yaml:"interval"`
+	Receivers []ReceiverConfig `yaml:"receivers"`
+}
+
+// ReceiverConfig a channel notifications are sent to, with the events and maintenances it is interested in
+type ReceiverConfig struct {
+	Name     string        `yaml:"name"`
+	Type     string        `yaml:"type"`
+	URL      string        `yaml:"url"`
+	Events   []string      `yaml:"events"`
+	Filters  []string      `yaml:"filters"`
+	Template string        `yaml:"template"`
+	Timeout  time.Duration `yaml:"timeout"`
+	SMTP     *SMTPConfig   `yaml:"smtp"`
+
+	filters []calendarFilter
+	tmpl    *template.Template
+}
+
+// SMTPConfig mail server and recipients of an SMTP receiver
+type SMTPConfig struct {
+	Addr     string   `yaml:"addr"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+}
+
+// Notification a lifecycle event of a maintenance, or of one of its occurrences
+type Notification struct {
+	Event         string        `json:"event"`
+	MaintenanceID string        `json:"maintenanceID"`
+	State         string        `json:"state"`
+	Comment       string        `json:"comment"`
+	Ticket        string        `json:"ticket,omitempty"`
+	CreatedBy     string        `json:"createdBy"`
+	Matchers      []Matcher     `json:"matchers"`
+	Start         time.Time     `json:"start"`
+	End           time.Time     `json:"end"`
+	In            time.Duration `json:"-"`
+}
+
+func (c *NotifyConfig) validate() error {
+	names := map[string]bool{}
+	for i := range c.Receivers {
+		r := &c.Receivers[i]
+		err := r.validate()
+		if err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("notify receiver '%s' is defined more than once", r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+func (c NotifyConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultNotifyInterval
+	}
+	return c.Interval
+}
+
+func (c NotifyConfig) leadTime() time.Duration {
+	if c.LeadTime <= 0 {
+		return defaultNotifyLeadTime
+	}
+	return c.LeadTime
+}
+
+func (r *ReceiverConfig) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("notify receiver name is mandatory")
+	}
+
+	switch r.Type {
+	case receiverWebhook, receiverSlack:
+		u, err := url.Parse(r.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid url '%s' for notify receiver '%s'", r.URL, r.Name)
+		}
+	case receiverSMTP:
+		if r.SMTP == nil || r.SMTP.Addr == "" || r.SMTP.From == "" || len(r.SMTP.To) == 0 {
+			return fmt.Errorf("smtp addr, from and to are mandatory for notify receiver '%s'", r.Name)
+		}
+		if _, _, err := net.SplitHostPort(r.SMTP.Addr); err != nil {
+			return fmt.Errorf("invalid smtp addr '%s' for notify receiver '%s': %s", r.SMTP.Addr, r.Name, err.Error())
+		}
+	default:
+		return fmt.Errorf("unknown type '%s' for notify receiver '%s', expected webhook, slack or smtp", r.Type, r.Name)
+	}
+
+	for _, e := range r.Events {
+		if !notifyEvents[e] {
+			return fmt.Errorf("unknown event '%s' for notify receiver '%s'", e, r.Name)
+		}
+	}
+
+	r.filters = nil
+	for _, s := range r.Filters {
+		f, err := parseCalendarFilter(s)
+		if err != nil {
+			return fmt.Errorf("notify receiver '%s': %s", r.Name, err.Error())
+		}
+		r.filters = append(r.filters, f)
+	}
+
+	text := r.Template
+	if text == "" {
+		text = defaultNotifyTemplate
+	}
+	tmpl, err := template.New(r.Name).Funcs(notifyFuncs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid template for notify receiver '%s': %s", r.Name, err.Error())
+	}
+	r.tmpl = tmpl
+	return nil
+}
+
+// accepts returns true if the receiver wants to be notified of the event
+func (r ReceiverConfig) accepts(n Notification) bool {
+	if len(r.Events) > 0 {
+		found := false
+		for _, e := range r.Events {
+			found = found || e == n.Event
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, f := range r.filters {
+		if !f.selects(n.Matchers) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r ReceiverConfig) render(n Notification) (string, error) {
+	tmpl := r.tmpl
+	if tmpl == nil {
+		tmpl = template.Must(template.New(r.Name).Funcs(notifyFuncs).Parse(defaultNotifyTemplate))
+	}
+	var b bytes.Buffer
+	err := tmpl.Execute(&b, n)
+	if err != nil {
+		return "", fmt.Errorf("unable to render notification for receiver '%s': %s", r.Name, err.Error())
+	}
+	return b.String(), nil
+}
+
+// send delivers the notification through the channel of the receiver
+func (r ReceiverConfig) send(n Notification) error {
+	msg, err := r.render(n)
+	if err != nil {
+		return err
+	}
+
+	switch r.Type {
+	case receiverSMTP:
+		return r.sendMail(n, msg)
+	case receiverSlack:
+		return r.post(struct {
+			Text string `json:"text"`
+		}{msg})
+	default:
+		return r.post(struct {
+			Notification
+			Message string `json:"message"`
+		}{n, msg})
+	}
+}
+
+func (r ReceiverConfig) post(payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal notification for receiver '%s': %s", r.Name, err.Error())
+	}
+
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultReceiverTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Post(r.URL, "application/json", bytes.NewBuffer(b))
+	if err != nil {
+		return fmt.Errorf("unable to notify receiver '%s': %s", r.Name, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unable to notify receiver '%s': receiver returned an HTTP error code: %d", r.Name, resp.StatusCode)
+	}
+	return nil
+}
+
+// mailSubject returns the encoded subject of a mail notification, made of the first line of the comment
+// so that no line break of the comment ends up in the headers
+func mailSubject(n Notification) string {
+	comment := n.Comment
+	if i := strings.IndexAny(comment, "\r\n"); i >= 0 {
+		comment = comment[:i]
+	}
+	return mime.QEncoding.Encode("utf-8", fmt.Sprintf("Maintenance %s: %s", n.Event, comment))
+}
+
+func (r ReceiverConfig) sendMail(n Notification, msg string) error {
+	c := r.SMTP
+	var auth smtp.Auth
+	if c.Username != "" {
+		host, _, _ := net.SplitHostPort(c.Addr)
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", c.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(c.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mailSubject(n))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.Replace(msg, "\n", "\r\n", -1))
+	b.WriteString("\r\n")
+
+	err := smtp.SendMail(c.Addr, auth, c.From, c.To, b.Bytes())
+	if err != nil {
+		return fmt.Errorf("unable to notify receiver '%s': %s", r.Name, err.Error())
+	}
+	return nil
+}
+
+// newNotification describes an event of the maintenance, about the given occurrence or
+// about the first one if none is given
+func newNotification(event string, m Maintenance, o *occurrence) Notification {
+	n := Notification{
+		Event:         event,
+		MaintenanceID: m.ID,
+		State:         m.State,
+		Comment:       m.Request.Comment,
+		Ticket:        m.Request.Ticket,
+		CreatedBy:     m.Request.CreatedBy,
+		Matchers:      m.Request.Matchers,
+	}
+	start, end := m.Request.Schedule.StartTime, m.Request.Schedule.EndTime
+	if o != nil {
+		start, end = o.Start, o.End
+	}
+	n.Start, _ = time.Parse(requestTimeLayout, start)
+	n.End, _ = time.Parse(requestTimeLayout, end)
+	return n
+}
+
+// dispatch sends the notifications to every receiver interested in them
+func (a *App) dispatch(notifications ...Notification) {
+	for _, n := range notifications {
+		for _, r := range a.conf().Notify.Receivers {
+			if !r.accepts(n) {
+				continue
+			}
+			err := r.send(n)
+			if err != nil {
+				log.Println(err)
+				notificationsSent.WithLabelValues(r.Name, "failure").Inc()
+				continue
+			}
+			notificationsSent.WithLabelValues(r.Name, "success").Inc()
+		}
+	}
+}
+
+// notify sends the notification in the background, so slow receivers do not delay the request
+func (a *App) notify(event string, m Maintenance, o *occurrence) {
+	if len(a.conf().Notify.Receivers) == 0 {
+		return
+	}
+	go a.dispatch(newNotification(event, m, o))
+}
+
+// occurrenceNotifications returns the starting, started and ended notifications of the occurrences
+// of a scheduled maintenance which are due in (from, to]
+func occurrenceNotifications(m Maintenance, from, to time.Time, lead time.Duration) ([]Notification, error) {
+	occurrences, err := m.Request.Schedule.occurrences()
+	if err != nil {
+		return nil, err
+	}
+	_, cancelled := m.expiredOnPurpose()
+	due := func(t time.Time) bool {
+		return t.After(from) && !t.After(to)
+	}
+
+	var out []Notification
+	for i := range occurrences {
+		o := occurrences[i]
+		if cancelled[o.End] {
+			continue
+		}
+		start, err := time.Parse(requestTimeLayout, o.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil {
+			return nil, err
+		}
+
+		if due(start.Add(-lead)) {
+			n := newNotification(eventStarting, m, &o)
+			n.In = lead
+			out = append(out, n)
+		}
+		if due(start) {
+			out = append(out, newNotification(eventStarted, m, &o))
+		}
+		if due(end) {
+			out = append(out, newNotification(eventEnded, m, &o))
+		}
+	}
+	return out, nil
+}
+
+// notifyOccurrences sends the notifications of the occurrences due since the last check, for the scheduled
+// maintenances and the valid declared ones and MaintenanceWindow resources
+func (a *App) notifyOccurrences(from, to time.Time) {
+	lead := a.conf().Notify.leadTime()
+	for _, m := range a.windowMaintenances() {
+		notifications, err := occurrenceNotifications(m, from, to, lead)
+		if err != nil {
+			log.Printf("unable to list occurrences of maintenance '%s': %s\n", m.ID, err.Error())
+			continue
+		}
+		a.dispatch(notifications...)
+	}
+}
+
+// notifyLoop periodically sends the notifications of the occurrences starting and ending,
+// events due while the scheduler is down are not sent
+func (a *App) notifyLoop() {
+	last := time.Now()
+	for {
+		time.Sleep(a.conf().Notify.interval())
+
+		now := time.Now()
+		if len(a.conf().Notify.Receivers) > 0 {
+			a.notifyOccurrences(last, now)
+		}
+		last = now
+	}
+}
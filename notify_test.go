@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts mails on a local port and sends their data on the returned channel
+func fakeSMTPServer(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err.Error())
+	}
+	mails := make(chan string, 10)
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rd := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var data []string
+				for {
+					l, err := rd.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data = append(data, l)
+				}
+				mails <- strings.Join(data, "")
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String(), mails
+}
+
+func TestReceiverConfig_validate(t *testing.T) {
+	var cases = []struct {
+		name     string
+		receiver ReceiverConfig
+		valid    bool
+	}{
+		{"webhook", ReceiverConfig{Name: "a", Type: receiverWebhook, URL: "http://hook.example/notify"}, true},
+		{"slack with events", ReceiverConfig{Name: "a", Type: receiverSlack, URL: "https://hooks.slack.com/x", Events: []string{eventStarted}}, true},
+		{"smtp", ReceiverConfig{Name: "a", Type: receiverSMTP, SMTP: &SMTPConfig{Addr: "mail:25", From: "ams@example.com", To: []string{"ops@example.com"}}}, true},
+		{"no name", ReceiverConfig{Type: receiverWebhook, URL: "http://hook.example"}, false},
+		{"unknown type", ReceiverConfig{Name: "a", Type: "pager"}, false},
+		{"no url", ReceiverConfig{Name: "a", Type: receiverWebhook}, false},
+		{"smtp without recipient", ReceiverConfig{Name: "a", Type: receiverSMTP, SMTP: &SMTPConfig{Addr: "mail:25", From: "ams@example.com"}}, false},
+		{"smtp without port", ReceiverConfig{Name: "a", Type: receiverSMTP, SMTP: &SMTPConfig{Addr: "mail", From: "a@b", To: []string{"c@d"}}}, false},
+		{"unknown event", ReceiverConfig{Name: "a", Type: receiverWebhook, URL: "http://hook.example", Events: []string{"approved"}}, false},
+		{"invalid filter", ReceiverConfig{Name: "a", Type: receiverWebhook, URL: "http://hook.example", Filters: []string{"team"}}, false},
+		{"invalid template", ReceiverConfig{Name: "a", Type: receiverWebhook, URL: "http://hook.example", Template: "{{ .Comment"}, false},
+	}
+
+	for _, c := range cases {
+		err := c.receiver.validate()
+		if (err == nil) != c.valid {
+			t.Errorf("validate not returning expected result for => '%s': '%v'", c.name, err)
+		}
+	}
+
+	dup := NotifyConfig{Receivers: []ReceiverConfig{cases[0].receiver, cases[0].receiver}}
+	if err := dup.validate(); err == nil {
+		t.Errorf("expected an error for receivers defined more than once")
+	}
+}
+
+func TestOccurrenceNotifications(t *testing.T) {
+	start := time.Date(2021, 10, 12, 22, 0, 0, 0, time.UTC)
+	m := Maintenance{ID: "m1", State: stateScheduled, Request: APISilenceRequest{
+		Comment:   "patching",
+		CreatedBy: "alice",
+		Matchers:  []Matcher{{Name: "team", Value: "db"}},
+		Schedule: Schedule{
+			StartTime: start.Format(requestTimeLayout),
+			EndTime:   start.Add(2 * time.Hour).Format(requestTimeLayout),
+			Repeat:    Repeat{Enabled: true, Interval: "d", Count: 3},
+		},
+	}}
+	// the second occurrence was expired through the scheduler
+	m.Expired = []ExpiredSilence{{ID: "s2", End: start.Add(26 * time.Hour).Format(requestTimeLayout)}}
+
+	var cases = []struct {
+		from, to time.Duration
+		want     []string
+	}{
+		{-time.Hour, -20 * time.Minute, nil},
+		{-20 * time.Minute, -10 * time.Minute, []string{eventStarting}},
+		{-10 * time.Minute, 0, []string{eventStarted}},
+		{time.Hour, 2 * time.Hour, []string{eventEnded}},
+		{2 * time.Hour, 47 * time.Hour, nil},
+		{47 * time.Hour, 50 * time.Hour, []string{eventStarting, eventStarted, eventEnded}},
+	}
+
+	for _, c := range cases {
+		notifications, err := occurrenceNotifications(m, start.Add(c.from), start.Add(c.to), 15*time.Minute)
+		if err != nil {
+			t.Fatalf("unable to list notifications: %s", err.Error())
+		}
+		var got []string
+		for _, n := range notifications {
+			got = append(got, n.Event)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("wrong notifications in (%s, %s]: got '%v' want '%v'", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestMailSubject(t *testing.T) {
+	var cases = []struct {
+		comment string
+		want    string
+	}{
+		{"patching", "Maintenance created: patching"},
+		{"patching\nsecond line", "Maintenance created: patching"},
+		{"patching\rBcc: someone@example.com", "Maintenance created: patching"},
+		{"patching\r\nBcc: someone@example.com", "Maintenance created: patching"},
+		{"mise à jour", "=?utf-8?q?Maintenance_created:_mise_=C3=A0_jour?="},
+	}
+
+	for _, c := range cases {
+		if got := mailSubject(Notification{Event: eventCreated, Comment: c.comment}); got != c.want {
+			t.Errorf("wrong subject for '%q': got '%s' want '%s'", c.comment, got, c.want)
+		}
+	}
+}
+
+func TestApp_dispatch(t *testing.T) {
+	hooks := make(chan []byte, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		hooks <- b
+	}))
+	defer hook.Close()
+	slacks := make(chan []byte, 10)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		slacks <- b
+	}))
+	defer slack.Close()
+	addr, mails := fakeSMTPServer(t)
+
+	conf := &Config{Notify: NotifyConfig{Receivers: []ReceiverConfig{
+		{Name: "hook", Type: receiverWebhook, URL: hook.URL, Template: "{{ .Event }} {{ .MaintenanceID }}"},
+		{Name: "slack", Type: receiverSlack, URL: slack.URL, Events: []string{eventStarting}},
+		{Name: "mail", Type: receiverSMTP, Filters: []string{"team=db"},
+			SMTP: &SMTPConfig{Addr: addr, From: "ams@example.com", To: []string{"db@example.com"}}},
+	}}}
+	err := conf.Notify.validate()
+	if err != nil {
+		t.Fatalf("unable to validate config: %s", err.Error())
+	}
+	app := App{config: conf}
+
+	start := time.Date(2021, 10, 12, 22, 0, 0, 0, time.UTC)
+	db := Notification{Event: eventStarting, MaintenanceID: "m1", Comment: "patching", CreatedBy: "alice",
+		Matchers: []Matcher{{Name: "team", Value: "db"}}, Start: start, End: start.Add(time.Hour), In: 15 * time.Minute}
+	other := db
+	other.Event, other.MaintenanceID, other.Matchers = eventCreated, "m2", []Matcher{{Name: "team", Value: "net"}}
+	app.dispatch(db, other)
+
+	// the webhook receives both, with the rendered message
+	for _, want := range []string{"starting m1", "created m2"} {
+		var payload struct {
+			Event   string `json:"event"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(<-hooks, &payload)
+		if payload.Message != want {
+			t.Errorf("wrong webhook message: got '%s' want '%s'", payload.Message, want)
+		}
+	}
+
+	// slack only receives the starting notification
+	var text struct {
+		Text string `json:"text"`
+	}
+	json.Unmarshal(<-slacks, &text)
+	want := "Maintenance starting in 15m0s: patching\n2021-10-12 22:00 UTC - 2021-10-12 23:00 UTC, silencing {team=\"db\"} (created by alice)"
+	if text.Text != want {
+		t.Errorf("wrong slack message\ngot: '%s'\nwant: '%s'", text.Text, want)
+	}
+	if len(slacks) != 0 {
+		t.Errorf("slack should not receive events it did not subscribe to")
+	}
+
+	// the mail receiver only receives the maintenance of its team
+	select {
+	case mail := <-mails:
+		if !strings.Contains(mail, "Subject: Maintenance starting: patching") || !strings.Contains(mail, "To: db@example.com") {
+			t.Errorf("unexpected mail:\n%s", mail)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no mail received")
+	}
+	if len(mails) != 0 {
+		t.Errorf("the mail receiver should not receive maintenances it filters out")
+	}
+}
+
+func TestApp_notifyOccurrences(t *testing.T) {
+	hooks := make(chan []byte, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		hooks <- b
+	}))
+	defer hook.Close()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := App{config: &Config{Notify: NotifyConfig{Receivers: []ReceiverConfig{
+		{Name: "hook", Type: receiverWebhook, URL: hook.URL, Events: []string{eventStarted}},
+	}}}, client: am}
+	scheduled := newScheduledMaintenance(t, &app, am, now.Add(time.Minute), 1)
+
+	// declared maintenances and MaintenanceWindow resources are notified too
+	declared := DeclaredMaintenance{Name: "backup", Owner: "ops", Comment: "Backup", Matchers: []DeclaredMatcher{{Name: "job", Value: "backup"}},
+		Schedule: DeclaredSchedule{Start: now.Add(2 * time.Minute), End: now.Add(time.Hour)}}
+	app.setManagedWindows(declarativeActor, map[string]APISilenceRequest{declared.Name: declared.request()})
+
+	app.notifyOccurrences(now, now.Add(5*time.Minute))
+	var got []string
+	for len(hooks) > 0 {
+		var payload struct {
+			MaintenanceID string `json:"maintenanceID"`
+		}
+		json.Unmarshal(<-hooks, &payload)
+		got = append(got, payload.MaintenanceID)
+	}
+	if want := []string{scheduled.ID, "backup"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong notified maintenances: got '%v' want '%v'", got, want)
+	}
+}
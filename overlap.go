@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+const (
+	overlapReject = "reject"
+	overlapMerge  = "merge"
+	overlapCreate = "create"
+
+	idempotencyHeader = "Idempotency-Key"
+)
+
+// OverlapConfig what happens when a new maintenance overlaps an existing window with the same matchers
+type OverlapConfig struct {
+	Default string `yaml:"default"`
+}
+
+func (c OverlapConfig) validate() error {
+	switch c.Default {
+	case "", overlapReject, overlapMerge, overlapCreate:
+		return nil
+	}
+	return fmt.Errorf("invalid overlap default '%s', expected reject, merge or create", c.Default)
+}
+
+// mode returns what to do with overlaps for the request, the configured default unless it asks otherwise,
+// overlapping maintenances are created unless operators opt in to reject or merge them
+func (c OverlapConfig) mode(requested string) (string, error) {
+	if requested == "" {
+		requested = c.Default
+	}
+	switch requested {
+	case "", overlapCreate:
+		return overlapCreate, nil
+	case overlapReject, overlapMerge:
+		return requested, nil
+	}
+	return "", fmt.Errorf("invalid onOverlap '%s', expected reject, merge or create", requested)
+}
+
+// Idempotency the key an API client submitted a maintenance with, the hash of its request and the
+// status code it was replied, which retries are replied again
+type Idempotency struct {
+	Key    string `json:"key"`
+	Hash   string `json:"hash"`
+	Status int    `json:"status,omitempty"`
+}
+
+// replay returns the reply to a retry of the submission of the maintenance
+func (i Idempotency) replay(maintenanceID string) (int, string) {
+	if i.Status >= http.StatusBadRequest {
+		return i.Status, fmt.Sprintf("maintenance '%s' was already submitted with idempotency key '%s' and could not be completed, use another key to submit it again",
+			maintenanceID, i.Key)
+	}
+	// maintenances stored before the status was kept were all created
+	status := i.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return status, fmt.Sprintf("maintenance '%s' was already created with idempotency key '%s'", maintenanceID, i.Key)
+}
+
+// record keeps the status code the submission was replied, if it was submitted with a key
+func (i *Idempotency) record(status int) {
+	if i != nil {
+		i.Status = status
+	}
+}
+
+// keyLocks serializes the submissions sharing an idempotency key, so that a retry sent while the
+// first submission is in progress is replayed rather than created twice
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the key and returns the function unlocking it
+func (k *keyLocks) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyLock{}
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// Overlap an existing window overlapping an occurrence of a new request with the same matchers
+type Overlap struct {
+	MaintenanceID string `json:"maintenanceID,omitempty"`
+	SilenceID     string `json:"silenceID,omitempty"`
+	Start         string `json:"start"`
+	End           string `json:"end"`
+
+	managed bool
+}
+
+func (o Overlap) String() string {
+	if o.SilenceID == "" {
+		return fmt.Sprintf("pending maintenance '%s' (%s to %s)", o.MaintenanceID, o.Start, o.End)
+	}
+	return fmt.Sprintf("silence '%s' (%s to %s)", o.SilenceID, o.Start, o.End)
+}
+
+// requestHash identifies a silence request, to tell a retry from another request with the same idempotency key
+func requestHash(r APISilenceRequest) string {
+	b, _ := json.Marshal(r)
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// sameMatchers returns true if both lists hold the same matchers, in any order
+func sameMatchers(a, b []Matcher) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := append([]Matcher(nil), a...)
+	y := append([]Matcher(nil), b...)
+	sort.Slice(x, func(i, j int) bool { return x[i].String() < x[j].String() })
+	sort.Slice(y, func(i, j int) bool { return y[i].String() < y[j].String() })
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapsOccurrence returns true if the window overlaps one of the occurrences
+func overlapsOccurrence(start, end time.Time, occurrences []occurrence) bool {
+	for _, o := range occurrences {
+		oStart, err := time.Parse(requestTimeLayout, o.Start)
+		if err != nil {
+			continue
+		}
+		oEnd, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil {
+			continue
+		}
+		if start.Before(oEnd) && oStart.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// findOverlaps returns the silences which are not over and the occurrences of pending maintenances
+// overlapping an occurrence of the request with the same matchers
+func (a *App) findOverlaps(r APISilenceRequest, now time.Time) ([]Overlap, error) {
+	occurrences, err := r.Schedule.occurrences()
+	if err != nil {
+		return nil, err
+	}
+
+	silences, err := a.amClient().ListSilences()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve silences: %s", err.Error())
+	}
+
+	var overlaps []Overlap
+	for _, s := range silences {
+		if s == nil || s.ID == nil || s.StartsAt == nil || s.EndsAt == nil {
+			continue
+		}
+		if s.Status != nil && s.Status.State != nil && *s.Status.State == models.SilenceStatusStateExpired {
+			continue
+		}
+		start, end := time.Time(*s.StartsAt), time.Time(*s.EndsAt)
+		if !end.After(now) || !sameMatchers(matchersFromModel(s.Matchers), r.Matchers) || !overlapsOccurrence(start, end, occurrences) {
+			continue
+		}
+
+		o := Overlap{SilenceID: *s.ID, Start: start.UTC().Format(requestTimeLayout), End: end.UTC().Format(requestTimeLayout)}
+		if m, ok := a.maintenanceStore().FindBySilenceID(*s.ID); ok {
+			o.MaintenanceID = m.ID
+		}
+		if s.Comment != nil {
+			_, o.managed = managedName(*s.Comment)
+		}
+		overlaps = append(overlaps, o)
+	}
+
+	for _, m := range a.maintenanceStore().List() {
+		if m.State != statePending || !sameMatchers(m.Request.Matchers, r.Matchers) {
+			continue
+		}
+		pending, err := m.Request.Schedule.occurrences()
+		if err != nil {
+			continue
+		}
+		for _, p := range pending {
+			start, err := time.Parse(requestTimeLayout, p.Start)
+			if err != nil {
+				continue
+			}
+			end, err := time.Parse(requestTimeLayout, p.End)
+			if err != nil || !end.After(now) || !overlapsOccurrence(start, end, occurrences) {
+				continue
+			}
+			overlaps = append(overlaps, Overlap{MaintenanceID: m.ID, Start: p.Start, End: p.End})
+		}
+	}
+	return overlaps, nil
+}
+
+// mergeOverlaps extends the single occurrence of the request to cover the silences it overlaps,
+// which are to be expired once the silence of the request is created
+func mergeOverlaps(r APISilenceRequest, overlaps []Overlap) (APISilenceRequest, string, bool) {
+	if r.Schedule.Repeat.Count > 1 {
+		return r, "only a maintenance with a single occurrence can be merged into overlapping silences", false
+	}
+
+	start, err := time.Parse(requestTimeLayout, r.Schedule.StartTime)
+	if err != nil {
+		return r, "invalid start time format", false
+	}
+	end, err := time.Parse(requestTimeLayout, r.Schedule.EndTime)
+	if err != nil {
+		return r, "invalid end time format", false
+	}
+	for _, o := range overlaps {
+		if o.SilenceID == "" {
+			return r, fmt.Sprintf("cannot merge into %s, it has no silence yet", o), false
+		}
+		if o.managed {
+			return r, fmt.Sprintf("cannot merge into %s, it is managed by a declared maintenance", o), false
+		}
+		oStart, _ := time.Parse(requestTimeLayout, o.Start)
+		oEnd, _ := time.Parse(requestTimeLayout, o.End)
+		if oStart.Before(start) {
+			start = oStart
+		}
+		if oEnd.After(end) {
+			end = oEnd
+		}
+	}
+	r.Schedule.StartTime = start.Format(requestTimeLayout)
+	r.Schedule.EndTime = end.Format(requestTimeLayout)
+	return r, "", true
+}
+
+// resolveOverlaps applies the overlap mode to the request, it returns the request to create, the
+// silences to expire once it is created, and an error message and status code if it cannot be created
+func (a *App) resolveOverlaps(r *http.Request, request APISilenceRequest, mode string, rules []ValidationRule, overrides *overrideLog) (APISilenceRequest, []Overlap, string, int) {
+	if mode == overlapCreate {
+		return request, nil, "", http.StatusOK
+	}
+
+	overlaps, err := a.findOverlaps(request, time.Now())
+	if err != nil {
+		return request, nil, fmt.Sprintf("unable to check overlapping maintenances: %s", err.Error()), http.StatusBadGateway
+	}
+	if len(overlaps) == 0 {
+		return request, nil, "", http.StatusOK
+	}
+
+	var list []string
+	for _, o := range overlaps {
+		list = append(list, o.String())
+	}
+	if mode == overlapReject {
+		msg := fmt.Sprintf("maintenance overlaps %d existing window(s) with the same matchers: %s; submit it again with onOverlap set to merge to merge with them, or to create to create it anyway",
+			len(overlaps), strings.Join(list, ", "))
+		return request, nil, msg, http.StatusConflict
+	}
+
+	merged, msg, ok := mergeOverlaps(request, overlaps)
+	if !ok {
+		return request, nil, msg, http.StatusConflict
+	}
+	// the extended window must still comply with the policies, its overrides replace the ones of the request
+	overrides.reset()
+	msg, ok = merged.Valid(rules...)
+	if !ok {
+		return request, nil, fmt.Sprintf("merged maintenance is invalid: %s", msg), http.StatusBadRequest
+	}
+	return merged, overlaps, "", http.StatusOK
+}
+
+// expireMerged expires the silences a new maintenance was merged with, recording it on their maintenance
+func (a *App) expireMerged(r *http.Request, overlaps []Overlap) int {
+	failed := 0
+	for _, o := range overlaps {
+		before := a.silenceSnapshot(o.SilenceID)
+		err := a.amClient().ExpireSilenceWithID(o.SilenceID)
+		if err != nil {
+			failed++
+			continue
+		}
+		a.auditExpire(r, o.SilenceID, before)
+		a.markExpired(o.SilenceID, before)
+	}
+	return failed
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOverlapConfig_mode(t *testing.T) {
+	var cases = []struct {
+		def       string
+		requested string
+		want      string
+		err       bool
+	}{
+		{"", "", overlapCreate, false},
+		{overlapReject, "", overlapReject, false},
+		{overlapReject, overlapCreate, overlapCreate, false},
+		{overlapCreate, overlapMerge, overlapMerge, false},
+		{"", "ignore", "", true},
+	}
+
+	for _, c := range cases {
+		got, err := OverlapConfig{Default: c.def}.mode(c.requested)
+		if got != c.want || (err != nil) != c.err {
+			t.Errorf("mode(%q) with default %q: got '%s' '%v' want '%s'", c.requested, c.def, got, err, c.want)
+		}
+	}
+}
+
+func TestApp_findOverlaps_pending(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	app := App{config: &Config{}, client: newFakeAlertmanager(now)}
+	pending := Maintenance{ID: "m1", State: statePending, Request: APISilenceRequest{
+		Matchers: []Matcher{{Name: "env", Value: "prod"}, {Name: "team", Value: "db"}},
+		Schedule: Schedule{
+			StartTime: now.Add(24 * time.Hour).Format(requestTimeLayout),
+			EndTime:   now.Add(26 * time.Hour).Format(requestTimeLayout),
+			Repeat:    Repeat{Interval: "d", Count: 3},
+		},
+	}}
+	app.maintenanceStore().Add(pending)
+
+	r := APISilenceRequest{
+		Matchers: []Matcher{{Name: "team", Value: "db"}, {Name: "env", Value: "prod"}},
+		Schedule: Schedule{
+			StartTime: now.Add(49 * time.Hour).Format(requestTimeLayout),
+			EndTime:   now.Add(51 * time.Hour).Format(requestTimeLayout),
+			Repeat:    Repeat{Interval: "d", Count: 1},
+		},
+	}
+	overlaps, err := app.findOverlaps(r, now)
+	if err != nil || len(overlaps) != 1 || overlaps[0].MaintenanceID != "m1" || overlaps[0].SilenceID != "" {
+		t.Errorf("unexpected overlaps: '%+v' '%v'", overlaps, err)
+	}
+
+	// different matchers never overlap
+	r.Matchers = r.Matchers[:1]
+	if overlaps, _ = app.findOverlaps(r, now); len(overlaps) != 0 {
+		t.Errorf("unexpected overlaps: '%+v'", overlaps)
+	}
+}
+
+func TestApp_createSilence_overlap(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := &App{config: &Config{Overlap: OverlapConfig{Default: overlapReject}}, client: am}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	submit := func(start, end time.Duration, count int, onOverlap, key string) (int, string) {
+		body := fmt.Sprintf(`{"comment":"patching","matchers":[{"name":"team","value":"db"}],"onOverlap":%q,
+			"schedule":{"start_time":%q,"end_time":%q,"repeat":{"interval":"d","count":%d}}}`, onOverlap,
+			now.Add(start).Format(requestTimeLayout), now.Add(end).Format(requestTimeLayout), count)
+		req := httptest.NewRequest("POST", "/api/v1/silence", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(idempotencyHeader, key)
+		}
+		req = withIdentity(req, &Identity{Name: "carol", Token: true})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+		var resp APIResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp.Message
+	}
+
+	if code, msg := submit(time.Hour, 2*time.Hour, 1, "", ""); code != http.StatusOK {
+		t.Fatalf("unexpected status: '%d' '%s'", code, msg)
+	}
+	first := app.maintenanceStore().List()[0]
+
+	// submitting the same form twice is rejected when configured so
+	code, msg := submit(time.Hour, 2*time.Hour, 1, "", "")
+	if code != http.StatusConflict || !strings.Contains(msg, "silence 's1'") || !strings.Contains(msg, "onOverlap") || len(am.active()) != 1 {
+		t.Errorf("expected the duplicate to be rejected, got '%d' '%s'", code, msg)
+	}
+
+	// a series cannot be merged
+	if code, msg = submit(90*time.Minute, 3*time.Hour, 2, overlapMerge, ""); code != http.StatusConflict {
+		t.Errorf("expected the series not to be merged, got '%d' '%s'", code, msg)
+	}
+
+	// merging leaves a single extended silence
+	code, msg = submit(90*time.Minute, 3*time.Hour, 1, overlapMerge, "")
+	active := am.active()
+	if code != http.StatusOK || len(active) != 1 || !strings.Contains(msg, "1 overlapping silence(s) merged") {
+		t.Fatalf("unexpected merge: '%d' '%s' '%d' active", code, msg, len(active))
+	}
+	if start, end := time.Time(*active[0].StartsAt), time.Time(*active[0].EndsAt); !start.Equal(now.Add(time.Hour)) || !end.Equal(now.Add(3*time.Hour)) {
+		t.Errorf("wrong merged window: '%s' to '%s'", start, end)
+	}
+	if m, _ := app.maintenanceStore().Get(first.ID); len(m.Expired) != 1 {
+		t.Errorf("the expiration of the merged silence should be recorded, got '%+v'", m.Expired)
+	}
+
+	// creating anyway keeps both
+	if code, msg = submit(time.Hour, 2*time.Hour, 1, overlapCreate, ""); code != http.StatusOK || len(am.active()) != 2 {
+		t.Errorf("unexpected creation: '%d' '%s'", code, msg)
+	}
+}
+
+func TestApp_createSilence_idempotency(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := &App{config: &Config{Overlap: OverlapConfig{Default: overlapCreate}}, client: am}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	submit := func(comment, key, caller string) (int, string) {
+		body := fmt.Sprintf(`{"comment":%q,"matchers":[{"name":"team","value":"db"}],
+			"schedule":{"start_time":%q,"end_time":%q,"repeat":{"interval":"d","count":1}}}`, comment,
+			now.Add(time.Hour).Format(requestTimeLayout), now.Add(2*time.Hour).Format(requestTimeLayout))
+		req := httptest.NewRequest("POST", "/api/v1/silence", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyHeader, key)
+		req = withIdentity(req, &Identity{Name: caller, Token: true})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+		var resp APIResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp.Message
+	}
+
+	if code, msg := submit("patching", "k1", "carol"); code != http.StatusOK {
+		t.Fatalf("unexpected status: '%d' '%s'", code, msg)
+	}
+	code, msg := submit("patching", "k1", "carol")
+	if code != http.StatusOK || !strings.Contains(msg, "already created") || len(am.active()) != 1 {
+		t.Errorf("a retry should not create anything, got '%d' '%s'", code, msg)
+	}
+	if code, msg = submit("upgrade", "k1", "carol"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the reuse of the key to be rejected, got '%d' '%s'", code, msg)
+	}
+	// keys are scoped to their caller
+	if code, msg = submit("patching", "k1", "dave"); code != http.StatusOK || len(am.active()) != 2 {
+		t.Errorf("unexpected status for another caller: '%d' '%s'", code, msg)
+	}
+}
+
+func TestApp_createSilence_idempotencyFailed(t *testing.T) {
+	client := MockAlertManagerClient{}
+	client.On("CreateSilenceWith",
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("APISilenceRequest")).Return("", fmt.Errorf("unavailable")).Once()
+	client.On("CreateSilenceWith",
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("APISilenceRequest")).Return("1234", nil)
+	app := &App{config: &Config{Overlap: OverlapConfig{Default: overlapCreate}}, client: &client}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	submit := func() (int, string) {
+		body := `{"comment":"patching","matchers":[{"name":"team","value":"db"}],
+			"schedule":{"start_time":"2021-10-12T12:00:00.000Z","end_time":"2021-10-12T13:00:00.000Z","repeat":{"interval":"d","count":2}}}`
+		req := httptest.NewRequest("POST", "/api/v1/silence", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyHeader, "k1")
+		req = withIdentity(req, &Identity{Name: "carol", Token: true})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+		var resp APIResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp.Message
+	}
+
+	if code, msg := submit(); code != http.StatusInternalServerError {
+		t.Fatalf("expected a partial failure, got '%d' '%s'", code, msg)
+	}
+	// a retry is replied the same failure, and creates nothing
+	code, msg := submit()
+	if code != http.StatusInternalServerError || !strings.Contains(msg, "could not be completed") {
+		t.Errorf("unexpected retry: '%d' '%s'", code, msg)
+	}
+	client.AssertNumberOfCalls(t, "CreateSilenceWith", 2)
+}
+
+func TestApp_createSilence_mergeOverride(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := &App{config: &Config{RBAC: testRBAC, Policy: newTestPolicy(t)}, client: am}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	// the regex matching every severity is a policy violation an admin may override
+	submit := func(start, end time.Duration, onOverlap string) (int, string) {
+		body, _ := json.Marshal(APISilenceRequest{
+			Comment:       "emergency",
+			Matchers:      []Matcher{{Name: "severity", Value: ".+", IsRegex: true}},
+			Justification: "incident 42",
+			OnOverlap:     onOverlap,
+			Schedule: Schedule{
+				StartTime: now.Add(start).Format(requestTimeLayout),
+				EndTime:   now.Add(end).Format(requestTimeLayout),
+				Repeat:    Repeat{Interval: "h", Count: 1},
+			},
+		})
+		req := httptest.NewRequest("POST", "/api/v1/silence", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req = withIdentity(req, &Identity{Name: "carol", Groups: []string{"sre"}, Token: true})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.createSilence).ServeHTTP(rr, req)
+
+		var resp APIResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp.Message
+	}
+
+	if code, msg := submit(time.Hour, 2*time.Hour, ""); code != http.StatusOK {
+		t.Fatalf("unexpected status: '%d' '%s'", code, msg)
+	}
+	if code, msg := submit(90*time.Minute, 3*time.Hour, overlapMerge); code != http.StatusOK {
+		t.Fatalf("unexpected merge: '%d' '%s'", code, msg)
+	}
+
+	// the merged request is validated twice, its override is recorded once
+	for _, m := range app.maintenanceStore().List() {
+		if got := app.auditLog().Query(AuditFilter{Action: actionPolicyOverride, MaintenanceID: m.ID}); len(got) != 1 {
+			t.Errorf("wrong number of overrides of maintenance '%s': got '%d' want '%d'", m.ID, len(got), 1)
+		}
+	}
+}
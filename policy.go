@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// PolicyConfig guardrails applied to silence requests
+type PolicyConfig struct {
+	RequiredLabels    []string           `yaml:"required_labels"`
+	ForbiddenMatchers []ForbiddenMatcher `yaml:"forbidden_matchers"`
+	MaxWindowDuration time.Duration      `yaml:"max_window_duration"`
+	MaxSeriesSpan     time.Duration      `yaml:"max_series_span"`
+	DenyMatchAllRegex bool               `yaml:"deny_match_all_regex"`
+}
+
+// ForbiddenMatcher a matcher is forbidden when both its name and value match these regexes
+type ForbiddenMatcher struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+
+	nameReg  *regexp.Regexp
+	valueReg *regexp.Regexp
+}
+
+func (p *PolicyConfig) validate() error {
+	for i := range p.ForbiddenMatchers {
+		f := &p.ForbiddenMatchers[i]
+		if f.Name == "" {
+			return fmt.Errorf("policy forbidden matcher name is mandatory")
+		}
+
+		var err error
+		f.nameReg, err = regexp.Compile("^(?:" + f.Name + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid policy forbidden matcher name '%s': %s", f.Name, err.Error())
+		}
+
+		value := f.Value
+		if value == "" {
+			value = ".*"
+		}
+		f.valueReg, err = regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid policy forbidden matcher value '%s': %s", f.Value, err.Error())
+		}
+	}
+
+	if p.MaxWindowDuration < 0 || p.MaxSeriesSpan < 0 {
+		return fmt.Errorf("policy durations cannot be negative")
+	}
+	return nil
+}
+
+// matchesEverything returns true if the matcher is a regex accepting any label value, such as ".*", ".+",
+// or groups and alternations of them
+func (m Matcher) matchesEverything() bool {
+	if !m.IsRegex {
+		return false
+	}
+
+	re, err := syntax.Parse(m.Value, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return acceptsAll(re.Simplify())
+}
+
+// acceptsAll returns true if the regex matches every non-empty value, newlines aside
+func acceptsAll(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		return anyChar(re.Sub[0]) || acceptsAll(re.Sub[0])
+	case syntax.OpCapture:
+		return acceptsAll(re.Sub[0])
+	case syntax.OpConcat:
+		// a single part accepting everything, all the others possibly empty
+		found := false
+		for _, sub := range re.Sub {
+			if !found && acceptsAll(sub) {
+				found = true
+				continue
+			}
+			if !acceptsEmpty(sub) {
+				return false
+			}
+		}
+		return found
+	case syntax.OpAlternate:
+		var first []rune
+		for _, sub := range re.Sub {
+			if acceptsAll(sub) {
+				return true
+			}
+			// branches starting with different characters, followed by anything
+			if sub.Op == syntax.OpConcat && len(sub.Sub) == 2 && sub.Sub[1].Op == syntax.OpStar && anyChar(sub.Sub[1].Sub[0]) {
+				first = append(first, charRanges(sub.Sub[0])...)
+			}
+		}
+		return coversAll(first)
+	}
+	return false
+}
+
+// acceptsEmpty returns true if the regex matches the empty value
+func acceptsEmpty(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpStar, syntax.OpQuest, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return acceptsEmpty(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !acceptsEmpty(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if acceptsEmpty(sub) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyChar returns true if the regex matches any single character
+func anyChar(re *syntax.Regexp) bool {
+	return coversAll(charRanges(re))
+}
+
+// charRanges returns the ranges of the characters matched by a regex matching a single character, as pairs
+func charRanges(re *syntax.Regexp) []rune {
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return []rune{0, unicode.MaxRune}
+	case syntax.OpCharClass:
+		return re.Rune
+	case syntax.OpLiteral:
+		if len(re.Rune) == 1 && re.Flags&syntax.FoldCase == 0 {
+			return []rune{re.Rune[0], re.Rune[0]}
+		}
+	case syntax.OpCapture:
+		return charRanges(re.Sub[0])
+	}
+	return nil
+}
+
+// coversAll returns true if the ranges cover every character, newlines aside
+func coversAll(ranges []rune) bool {
+	type span struct{ lo, hi rune }
+	var spans []span
+	for i := 0; i+1 < len(ranges); i += 2 {
+		spans = append(spans, span{ranges[i], ranges[i+1]})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].lo < spans[j].lo })
+
+	next := rune(0)
+	for _, s := range spans {
+		if s.lo > next && !(s.lo == '\n'+1 && next == '\n') {
+			return false
+		}
+		if s.hi+1 > next {
+			next = s.hi + 1
+		}
+	}
+	return next > unicode.MaxRune
+}
+
+// seriesSpan returns the time between the start of the first silence and the end of the last one,
+// once the occurrences are skipped or shifted off holidays
+func (s Schedule) seriesSpan() (time.Duration, error) {
+	occurrences, err := s.occurrences()
+	if err != nil || len(occurrences) == 0 {
+		return 0, err
+	}
+
+	var first, last time.Time
+	for _, o := range occurrences {
+		start, err := time.Parse(requestTimeLayout, o.Start)
+		if err != nil {
+			return 0, err
+		}
+		end, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil {
+			return 0, err
+		}
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if end.After(last) {
+			last = end
+		}
+	}
+	return last.Sub(first), nil
+}
+
+// violations returns a message for each policy the request breaks
+func (p PolicyConfig) violations(r APISilenceRequest) []string {
+	var out []string
+
+	for _, label := range p.RequiredLabels {
+		found := false
+		for _, m := range r.Matchers {
+			if m.Name == label && !m.matchesEverything() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, fmt.Sprintf("a matcher on label '%s' is required", label))
+		}
+	}
+
+	for _, m := range r.Matchers {
+		for _, f := range p.ForbiddenMatchers {
+			if f.nameReg != nil && f.nameReg.MatchString(m.Name) && f.valueReg.MatchString(m.Value) {
+				out = append(out, fmt.Sprintf("matcher '%s' is forbidden", m))
+			}
+		}
+		if p.DenyMatchAllRegex && m.matchesEverything() {
+			out = append(out, fmt.Sprintf("matcher '%s' matches every value", m))
+		}
+	}
+
+	start, errStart := time.Parse(requestTimeLayout, r.Schedule.StartTime)
+	end, errEnd := time.Parse(requestTimeLayout, r.Schedule.EndTime)
+	if p.MaxWindowDuration > 0 && errStart == nil && errEnd == nil && end.Sub(start) > p.MaxWindowDuration {
+		out = append(out, fmt.Sprintf("maintenance window lasts %s, the maximum is %s", end.Sub(start), p.MaxWindowDuration))
+	}
+
+	span, err := r.Schedule.seriesSpan()
+	if p.MaxSeriesSpan > 0 && err == nil && span > p.MaxSeriesSpan {
+		out = append(out, fmt.Sprintf("maintenance series spans %s, the maximum is %s", span, p.MaxSeriesSpan))
+	}
+	return out
+}
+
+// rule returns a validation rule rejecting requests breaking the policies, admins can override
+// them by providing a justification
+func (p PolicyConfig) rule(ac access, onOverride func(r APISilenceRequest, violations []string)) ValidationRule {
+	return func(r APISilenceRequest) (string, bool) {
+		violations := p.violations(r)
+		if len(violations) == 0 {
+			return "", true
+		}
+
+		if ac.admin && r.Justification != "" {
+			log.Printf("policy overridden by '%s', justification: '%s', violations: %s\n",
+				r.CreatedBy, r.Justification, strings.Join(violations, "; "))
+			if onOverride != nil {
+				onOverride(r, violations)
+			}
+			return "", true
+		}
+
+		msg := fmt.Sprintf("policy violation: %s", strings.Join(violations, "; "))
+		if ac.admin {
+			msg += " (provide a justification to override)"
+		}
+		return msg, false
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestPolicy(t *testing.T) PolicyConfig {
+	p := PolicyConfig{
+		RequiredLabels:    []string{"team"},
+		ForbiddenMatchers: []ForbiddenMatcher{{Name: "alertname", Value: "Watchdog|DeadMansSwitch"}},
+		MaxWindowDuration: 4 * time.Hour,
+		MaxSeriesSpan:     7 * 24 * time.Hour,
+		DenyMatchAllRegex: true,
+	}
+	err := p.validate()
+	if err != nil {
+		t.Fatalf("unable to validate policy: %s", err.Error())
+	}
+	return p
+}
+
+func newPolicyRequest(matchers []Matcher, start, end, interval string, count int) APISilenceRequest {
+	return APISilenceRequest{
+		Comment:   "scheduled maintenance",
+		CreatedBy: "alice",
+		Matchers:  matchers,
+		Schedule: Schedule{
+			StartTime: start,
+			EndTime:   end,
+			Repeat:    Repeat{Interval: interval, Count: count},
+		},
+	}
+}
+
+func TestMatcher_matchesEverything(t *testing.T) {
+	var cases = []struct {
+		matcher Matcher
+		want    bool
+	}{
+		{Matcher{Name: "severity", Value: ".+", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: ".*", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "(.*)", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: ".*", IsRegex: false}, false},
+		{Matcher{Name: "severity", Value: "critical|warning", IsRegex: true}, false},
+		{Matcher{Name: "instance", Value: "prod-.*", IsRegex: true}, false},
+		{Matcher{Name: "severity", Value: ".{1,}", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "^.*$", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "(?s:.)+", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "[\\s\\S]*", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "(critical|.*)", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "x?.+", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "(.*)(.*)", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "[a-m].*|[^a-m].*", IsRegex: true}, true},
+		{Matcher{Name: "severity", Value: "[a-m].*|[n-z].*", IsRegex: true}, false},
+		{Matcher{Name: "severity", Value: ".+.+", IsRegex: true}, false},
+		{Matcher{Name: "severity", Value: ".*critical.*", IsRegex: true}, false},
+		{Matcher{Name: "severity", Value: "[^c].*", IsRegex: true}, false},
+		{Matcher{Name: "severity", Value: "(", IsRegex: true}, false},
+	}
+
+	for _, c := range cases {
+		if got := c.matcher.matchesEverything(); got != c.want {
+			t.Errorf("matchesEverything not returning expected result for => '%s'", c.matcher)
+		}
+	}
+}
+
+func TestPolicyConfig_violations(t *testing.T) {
+	policy := newTestPolicy(t)
+	team := Matcher{Name: "team", Value: "payments"}
+	// the last occurrence is moved a week later
+	shifted := newPolicyRequest([]Matcher{team}, "2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", "d", 2)
+	shifted.Schedule.Shift = map[string]string{"2021-10-13T12:00:00.000Z": "2021-10-20T12:00:00.000Z"}
+
+	var cases = []struct {
+		name    string
+		request APISilenceRequest
+		want    int
+	}{
+		{"compliant", newPolicyRequest([]Matcher{team},
+			"2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", "d", 7), 0},
+		{"missing required label", newPolicyRequest([]Matcher{{Name: "job", Value: "app"}},
+			"2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", "d", 1), 1},
+		{"required label matched with a catch-all", newPolicyRequest([]Matcher{{Name: "team", Value: ".*", IsRegex: true}},
+			"2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", "d", 1), 2},
+		{"forbidden matcher", newPolicyRequest([]Matcher{team, {Name: "alertname", Value: "Watchdog"}},
+			"2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", "d", 1), 1},
+		{"match-all regex", newPolicyRequest([]Matcher{team, {Name: "severity", Value: ".+", IsRegex: true}},
+			"2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", "d", 1), 1},
+		{"window too long", newPolicyRequest([]Matcher{team},
+			"2021-10-12T12:00:00.000Z", "2021-10-12T18:00:00.000Z", "d", 1), 1},
+		{"series too long", newPolicyRequest([]Matcher{team},
+			"2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", "d", 8), 1},
+		{"series shifted too far", shifted, 1},
+	}
+
+	for _, c := range cases {
+		got := policy.violations(c.request)
+		if len(got) != c.want {
+			t.Errorf("unexpected violations for '%s'\ngot: '%v'\nwant: %d violation(s)", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPolicyConfig_rule_override(t *testing.T) {
+	policy := newTestPolicy(t)
+	request := newPolicyRequest([]Matcher{{Name: "severity", Value: ".+", IsRegex: true}},
+		"2021-10-12T12:00:00.000Z", "2021-10-12T13:00:00.000Z", "h", 1)
+
+	var overridden []string
+	onOverride := func(r APISilenceRequest, violations []string) { overridden = violations }
+
+	// regular users cannot override
+	request.Justification = "incident 42"
+	if _, ok := request.Valid(policy.rule(access{enabled: true}, onOverride)); ok {
+		t.Errorf("non admin should not be able to override policies")
+	}
+
+	// admins need a justification
+	request.Justification = ""
+	msg, ok := request.Valid(policy.rule(access{enabled: true, admin: true}, onOverride))
+	if ok {
+		t.Errorf("admin override without justification should be rejected")
+	}
+	if msg == "" {
+		t.Errorf("expected a validation message")
+	}
+
+	request.Justification = "incident 42"
+	if _, ok := request.Valid(policy.rule(access{enabled: true, admin: true}, onOverride)); !ok {
+		t.Errorf("admin override with justification should be accepted")
+	}
+	if len(overridden) != 2 {
+		t.Errorf("override should be recorded with its violations, got '%v'", overridden)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// RBACConfig roles restricting which alerts each user can silence
+type RBACConfig struct {
+	Roles []Role `yaml:"roles"`
+}
+
+// Role grants the listed users and groups the right to silence alerts within its label scopes
+type Role struct {
+	Name   string       `yaml:"name"`
+	Users  []string     `yaml:"users"`
+	Groups []string     `yaml:"groups"`
+	Scopes []LabelScope `yaml:"scopes"`
+	Admin  bool         `yaml:"admin"`
+}
+
+// LabelScope label values a silence request must match exactly to be within the scope
+type LabelScope map[string]string
+
+func (s LabelScope) String() string {
+	var pairs []string
+	for k, v := range s {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (c RBACConfig) enabled() bool {
+	return len(c.Roles) > 0
+}
+
+func (c RBACConfig) validate() error {
+	for _, r := range c.Roles {
+		if r.Name == "" {
+			return fmt.Errorf("rbac role name is mandatory")
+		}
+		if !r.Admin && len(r.Scopes) == 0 {
+			return fmt.Errorf("rbac role '%s' needs at least one scope or to be admin", r.Name)
+		}
+		for _, s := range r.Scopes {
+			if len(s) == 0 {
+				return fmt.Errorf("rbac role '%s' has an empty scope", r.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (r Role) grantedTo(id *Identity) bool {
+	for _, u := range r.Users {
+		if u == id.Name {
+			return true
+		}
+	}
+	for _, g := range r.Groups {
+		for _, ig := range id.Groups {
+			if g == ig {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// access what a caller is allowed to silence
+type access struct {
+	enabled bool
+	admin   bool
+	scopes  []LabelScope
+	roles   []string
+}
+
+// access returns the union of the roles granted to the identity, which can be nil for anonymous callers
+func (c RBACConfig) access(id *Identity) access {
+	ac := access{enabled: c.enabled()}
+	if id == nil {
+		return ac
+	}
+
+	for _, r := range c.Roles {
+		if !r.grantedTo(id) {
+			continue
+		}
+		ac.admin = ac.admin || r.Admin
+		ac.scopes = append(ac.scopes, r.Scopes...)
+		ac.roles = append(ac.roles, r.Name)
+	}
+	return ac
+}
+
+// allows returns true if the matchers are confined to one of the scopes
+func (ac access) allows(matchers []Matcher) bool {
+	if !ac.enabled || ac.admin {
+		return true
+	}
+
+	for _, s := range ac.scopes {
+		if s.confines(matchers) {
+			return true
+		}
+	}
+	return false
+}
+
+// confines returns true if every label of the scope is matched with its exact value
+func (s LabelScope) confines(matchers []Matcher) bool {
+	for name, value := range s {
+		found := false
+		for _, m := range matchers {
+			if m.Name == name && m.Value == value && !m.IsRegex {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// rule returns a validation rule rejecting requests outside of the caller scopes
+func (ac access) rule() ValidationRule {
+	return func(r APISilenceRequest) (string, bool) {
+		if ac.allows(r.Matchers) {
+			return "", true
+		}
+		if len(ac.scopes) == 0 {
+			return "no role allows you to create silences", false
+		}
+
+		var scopes []string
+		for _, s := range ac.scopes {
+			scopes = append(scopes, s.String())
+		}
+		return fmt.Sprintf("matchers must be confined to one of your scopes: %s", strings.Join(scopes, ", ")), false
+	}
+}
+
+// accessFor returns the access of the caller of the request
+func (a *App) accessFor(r *http.Request) access {
+	id, _ := identityFromContext(r.Context())
+	return a.conf().RBAC.access(id)
+}
+
+// canExpire checks the caller may expire a silence, silences created by someone else
+// must be within the caller scopes
+func (a *App) canExpire(r *http.Request, silenceID string) (string, bool, error) {
+	ac := a.accessFor(r)
+	if !ac.enabled || ac.admin {
+		return "", true, nil
+	}
+
+	silence, err := a.amClient().GetSilenceWithID(silenceID)
+	if err != nil {
+		return "", false, err
+	}
+
+	id, _ := identityFromContext(r.Context())
+	if id != nil && silence.CreatedBy != nil && *silence.CreatedBy == id.Name {
+		return "", true, nil
+	}
+
+	if !ac.allows(matchersFromModel(silence.Matchers)) {
+		return fmt.Sprintf("silence '%s' was created by someone else and is outside of your scopes", silenceID), false, nil
+	}
+	return "", true, nil
+}
+
+// canManage checks the caller may change a maintenance, maintenances created by someone else
+// must be within the caller scopes
+func (a *App) canManage(r *http.Request, m Maintenance) (string, bool) {
+	ac := a.accessFor(r)
+	if !ac.enabled || ac.admin {
+		return "", true
+	}
+
+	id, _ := identityFromContext(r.Context())
+	if id != nil && m.Request.CreatedBy == id.Name {
+		return "", true
+	}
+
+	if !ac.allows(m.Request.Matchers) {
+		return fmt.Sprintf("maintenance '%s' was created by someone else and is outside of your scopes", m.ID), false
+	}
+	return "", true
+}
+
+func matchersFromModel(list models.Matchers) []Matcher {
+	var out []Matcher
+	for _, m := range list {
+		if m == nil || m.Name == nil || m.Value == nil {
+			continue
+		}
+		matcher := Matcher{Name: *m.Name, Value: *m.Value}
+		if m.IsRegex != nil {
+			matcher.IsRegex = *m.IsRegex
+		}
+		out = append(out, matcher)
+	}
+	return out
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/mock"
+)
+
+var testRBAC = RBACConfig{Roles: []Role{
+	{Name: "payments", Groups: []string{"payments-team"}, Scopes: []LabelScope{{"team": "payments"}}},
+	{Name: "db", Users: []string{"bob"}, Scopes: []LabelScope{{"team": "db", "env": "staging"}}},
+	{Name: "sre", Groups: []string{"sre"}, Admin: true},
+}}
+
+func TestAccess_allows(t *testing.T) {
+	var cases = []struct {
+		id       *Identity
+		matchers []Matcher
+		want     bool
+	}{
+		// scope label matched exactly
+		{&Identity{Name: "alice", Groups: []string{"payments-team"}},
+			[]Matcher{{Name: "team", Value: "payments"}, {Name: "alertname", Value: ".*", IsRegex: true}}, true},
+
+		// scope label missing
+		{&Identity{Name: "alice", Groups: []string{"payments-team"}},
+			[]Matcher{{Name: "alertname", Value: ".*", IsRegex: true}}, false},
+
+		// scope label matched with a regex
+		{&Identity{Name: "alice", Groups: []string{"payments-team"}},
+			[]Matcher{{Name: "team", Value: "payments|db", IsRegex: true}}, false},
+
+		// every label of the scope is mandatory
+		{&Identity{Name: "bob"}, []Matcher{{Name: "team", Value: "db"}}, false},
+		{&Identity{Name: "bob"}, []Matcher{{Name: "team", Value: "db"}, {Name: "env", Value: "staging"}}, true},
+
+		// admin
+		{&Identity{Name: "carol", Groups: []string{"sre"}}, []Matcher{{Name: "severity", Value: ".+", IsRegex: true}}, true},
+
+		// no role
+		{&Identity{Name: "mallory"}, []Matcher{{Name: "team", Value: "payments"}}, false},
+		{nil, []Matcher{{Name: "team", Value: "payments"}}, false},
+	}
+
+	for _, c := range cases {
+		got := testRBAC.access(c.id).allows(c.matchers)
+		if got != c.want {
+			t.Errorf("access not returning expected result for => '%+v' '%v'", c.id, c.matchers)
+		}
+	}
+
+	// rbac disabled
+	if !(RBACConfig{}).access(nil).allows([]Matcher{{Name: "severity", Value: ".+", IsRegex: true}}) {
+		t.Errorf("access should not be restricted without roles")
+	}
+}
+
+func TestAPISilenceRequest_Valid_rules(t *testing.T) {
+	request := APISilenceRequest{
+		Comment:   "scheduled maintenance",
+		CreatedBy: "alice",
+		Matchers:  []Matcher{{Name: "team", Value: "db"}},
+		Schedule: Schedule{
+			StartTime: "2021-10-12T12:34:02.566Z",
+			EndTime:   "2021-10-12T13:34:02.566Z",
+			Repeat:    Repeat{Interval: "h", Count: 1},
+		},
+	}
+
+	rule := testRBAC.access(&Identity{Name: "alice", Groups: []string{"payments-team"}}).rule()
+	msg, ok := request.Valid(rule)
+	if ok {
+		t.Errorf("request outside of the caller scope should be invalid")
+	}
+	if msg != "matchers must be confined to one of your scopes: {team=payments}" {
+		t.Errorf("unexpected validation message: '%s'", msg)
+	}
+
+	request.Matchers = []Matcher{{Name: "team", Value: "payments"}}
+	if _, ok := request.Valid(rule); !ok {
+		t.Errorf("request within the caller scope should be valid")
+	}
+}
+
+func TestApp_expireSilence_rbac(t *testing.T) {
+	id := "7d8eb77e-00f9-4e0e-9f20-047695569296"
+	createdBy := "bob"
+	name := "team"
+	value := "db"
+	isRegex := false
+	silence := models.GettableSilence{
+		ID: &id,
+		Silence: models.Silence{
+			CreatedBy: &createdBy,
+			Matchers:  models.Matchers{{Name: &name, Value: &value, IsRegex: &isRegex}},
+		},
+	}
+
+	var cases = []struct {
+		caller *Identity
+		want   int
+	}{
+		{&Identity{Name: "bob"}, http.StatusOK},
+		{&Identity{Name: "alice", Groups: []string{"payments-team"}}, http.StatusForbidden},
+		{&Identity{Name: "carol", Groups: []string{"sre"}}, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		client := MockAlertManagerClient{}
+		client.On("GetSilenceWithID", id).Return(silence, nil)
+		client.On("ExpireSilenceWithID", id).Return(nil)
+		app := App{
+			config: &Config{RBAC: testRBAC},
+			client: &client,
+		}
+
+		req := httptest.NewRequest("DELETE", "/api/v1/silence/"+id, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		req = withIdentity(req, c.caller)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.expireSilence).ServeHTTP(rr, req)
+
+		if rr.Code != c.want {
+			t.Errorf("wrong status code for '%s': got '%d' want '%d'", c.caller.Name, rr.Code, c.want)
+		}
+		if c.want == http.StatusForbidden {
+			client.AssertNotCalled(t, "ExpireSilenceWithID", mock.Anything)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ams",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ams",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(configReloadSuccess, configReloadSeconds)
+}
+
+// reloadConfig reads the config file again and atomically swaps the config and
+// Alertmanager client of the app. The current config is kept if anything fails.
+func (a *App) reloadConfig(path string) error {
+	conf, err := loadConfig(path)
+	if err != nil {
+		configReloadSuccess.Set(0)
+		return err
+	}
+
+	var auth *authenticator
+	if conf.OIDC != nil {
+		auth, err = newAuthenticator(context.Background(), conf.OIDC)
+		if err != nil {
+			configReloadSuccess.Set(0)
+			return err
+		}
+	}
+
+	// the stores are opened at startup, a new location is only used after a restart
+	if current := a.conf(); current != nil && conf.Storage != current.Storage {
+		log.Println("storage paths changed, restart to use them")
+	}
+
+	a.mu.Lock()
+	a.config = conf
+	client := NewAlertManagerClient(conf.AlertmanagerURL)
+	if conf.MaintenanceAlerts.Enabled {
+		client.ExcludedAlertName = conf.MaintenanceAlerts.alertName()
+	}
+	a.client = client
+	a.auth = auth
+	a.mu.Unlock()
+	setSessionStore(newSessionStore(conf.Session))
+
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+	return nil
+}
+
+// watchReloadSignal reloads the config every time the process receives a SIGHUP
+func (a *App) watchReloadSignal(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		err := a.reloadConfig(path)
+		if err != nil {
+			log.Printf("error reloading config: %s\n", err.Error())
+			continue
+		}
+		log.Println("config reloaded on SIGHUP")
+	}
+}
+
+// reloadHandler returns a handler reloading the config file on demand
+func (a *App) reloadHandler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := a.reloadConfig(path)
+		if err != nil {
+			msg := fmt.Sprintf("unable to reload config: %s", err.Error())
+			log.Println(msg)
+			writeError(msg, w)
+			return
+		}
+
+		resp := APIResponse{
+			Status:  "success",
+			Message: "config reloaded",
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
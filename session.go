@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/gob"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/sessions"
 )
@@ -9,17 +11,49 @@ import (
 var (
 	cookieName = "ams-session"
 
-	// no heavy encryption until authentication is required
-	store = sessions.NewCookieStore([]byte(""))
+	// sessions are only signed and encrypted once keys are configured
+	store   sessions.Store = sessions.NewCookieStore([]byte(""))
+	storeMu sync.RWMutex
 )
 
+func init() {
+	gob.Register(&Flash{})
+	gob.Register(&Identity{})
+}
+
 type Flash struct {
 	Status  string
 	Message string
 }
 
+// newSessionStore creates a cookie store signed and encrypted with the configured keys
+func newSessionStore(conf SessionConfig) sessions.Store {
+	keys := [][]byte{[]byte(conf.AuthKey)}
+	if conf.EncryptionKey != "" {
+		keys = append(keys, []byte(conf.EncryptionKey))
+	}
+
+	s := sessions.NewCookieStore(keys...)
+	s.Options.HttpOnly = true
+	s.Options.SameSite = http.SameSiteLaxMode
+	s.Options.Secure = conf.Secure
+	return s
+}
+
+func setSessionStore(s sessions.Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+func getSession(r *http.Request) (*sessions.Session, error) {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store.Get(r, cookieName)
+}
+
 func sessionAddFlash(w http.ResponseWriter, r *http.Request, status, message string) error {
-	session, err := store.Get(r, cookieName)
+	session, err := getSession(r)
 	if err != nil {
 		return err
 	}
@@ -35,7 +69,7 @@ func sessionAddFlash(w http.ResponseWriter, r *http.Request, status, message str
 }
 
 func sessionGetFlash(w http.ResponseWriter, r *http.Request) ([]interface{}, error) {
-	session, err := store.Get(r, cookieName)
+	session, err := getSession(r)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	statePending   = "pending"
+	stateScheduled = "scheduled"
+	stateRejected  = "rejected"
+	stateExpired   = "expired"
+	stateCancelled = "cancelled"
+)
+
+// Maintenance a series of silences created from a single request
+type Maintenance struct {
+	ID         string            `json:"id"`
+	State      string            `json:"state"`
+	Request    APISilenceRequest `json:"request"`
+	SilenceIDs []string          `json:"silenceIDs"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+	ApprovedBy string            `json:"approvedBy,omitempty"`
+	// Expired silences expired on purpose before the end of their occurrence
+	Expired []ExpiredSilence `json:"expired,omitempty"`
+	// Annotations region annotations created in Grafana for the occurrences
+	Annotations []Annotation `json:"annotations,omitempty"`
+	// Source external origin of the maintenance, such as the UID of an uploaded calendar event
+	Source string `json:"source,omitempty"`
+	// Idempotency key the maintenance was submitted with by an API client
+	Idempotency *Idempotency `json:"idempotency,omitempty"`
+}
+
+// StorageConfig where maintenances are persisted
+type StorageConfig struct {
+	Path          string `yaml:"path"`
+	TemplatesPath string `yaml:"templates_path"`
+}
+
+// Store keeps maintenances in memory, and in a JSON file when a path is set
+type Store struct {
+	mu           sync.RWMutex
+	path         string
+	maintenances map[string]*Maintenance
+}
+
+func newMemoryStore() *Store {
+	return &Store{maintenances: map[string]*Maintenance{}}
+}
+
+// NewStore creates a store persisted to path, loading the maintenances it already contains
+func NewStore(path string) (*Store, error) {
+	s := newMemoryStore()
+	s.path = path
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read store: %s", err.Error())
+	}
+
+	var list []*Maintenance
+	err = json.Unmarshal(f, &list)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal store: %s", err.Error())
+	}
+	for _, m := range list {
+		s.maintenances[m.ID] = m
+	}
+	return s, nil
+}
+
+// save writes all maintenances to the store file, the caller must hold the lock
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal store: %s", err.Error())
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated store
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to write store: %s", err.Error())
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return fmt.Errorf("unable to write store: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *Store) sorted() []Maintenance {
+	list := make([]Maintenance, 0, len(s.maintenances))
+	for _, m := range s.maintenances {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Add stores a new maintenance
+func (s *Store) Add(m Maintenance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.maintenances[m.ID]; ok {
+		return fmt.Errorf("maintenance '%s' already exists", m.ID)
+	}
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+	m.UpdatedAt = m.CreatedAt
+	s.maintenances[m.ID] = &m
+	return s.save()
+}
+
+// Get returns the maintenance with the given ID
+func (s *Store) Get(id string) (Maintenance, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.maintenances[id]
+	if !ok {
+		return Maintenance{}, false
+	}
+	return *m, true
+}
+
+// List returns all maintenances, oldest first
+func (s *Store) List() []Maintenance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sorted()
+}
+
+// FindBySilenceID returns the maintenance which created the silence with the given ID
+func (s *Store) FindBySilenceID(id string) (Maintenance, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, m := range s.maintenances {
+		for _, silenceID := range m.SilenceIDs {
+			if silenceID == id {
+				return *m, true
+			}
+		}
+	}
+	return Maintenance{}, false
+}
+
+// FindBySource returns the maintenance imported from the given external source
+func (s *Store) FindBySource(source string) (Maintenance, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, m := range s.maintenances {
+		if m.Source == source {
+			return *m, true
+		}
+	}
+	return Maintenance{}, false
+}
+
+// FindByIdempotencyKey returns the maintenance submitted by the creator with the given idempotency key
+func (s *Store) FindByIdempotencyKey(key, createdBy string) (Maintenance, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, m := range s.maintenances {
+		if m.Idempotency != nil && m.Idempotency.Key == key && m.Request.CreatedBy == createdBy {
+			return *m, true
+		}
+	}
+	return Maintenance{}, false
+}
+
+// Update applies fn to the maintenance with the given ID and persists the result,
+// nothing is changed if fn returns an error
+func (s *Store) Update(id string, fn func(m *Maintenance) error) (Maintenance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.maintenances[id]
+	if !ok {
+		return Maintenance{}, fmt.Errorf("maintenance '%s' not found", id)
+	}
+
+	updated := *current
+	updated.SilenceIDs = append([]string(nil), current.SilenceIDs...)
+	updated.Expired = append([]ExpiredSilence(nil), current.Expired...)
+	updated.Annotations = append([]Annotation(nil), current.Annotations...)
+	if current.Idempotency != nil {
+		idempotency := *current.Idempotency
+		updated.Idempotency = &idempotency
+	}
+	err := fn(&updated)
+	if err != nil {
+		return *current, err
+	}
+	updated.UpdatedAt = time.Now().UTC()
+	s.maintenances[id] = &updated
+	return updated, s.save()
+}
+
+// newID returns a random UUID (version 4)
+func newID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
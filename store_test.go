@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ams-store")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "maintenances.json")
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("unable to create store: %s", err.Error())
+	}
+
+	m := Maintenance{ID: newID(), State: statePending, Request: APISilenceRequest{Comment: "patching"}, Idempotency: &Idempotency{Key: "k1"}}
+	err = s.Add(m)
+	if err != nil {
+		t.Fatalf("unable to add maintenance: %s", err.Error())
+	}
+	if err = s.Add(m); err == nil {
+		t.Errorf("adding a maintenance twice should fail")
+	}
+
+	_, err = s.Update(m.ID, func(m *Maintenance) error {
+		m.State = stateScheduled
+		m.SilenceIDs = []string{"1234"}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to update maintenance: %s", err.Error())
+	}
+
+	// a failed update changes nothing
+	_, err = s.Update(m.ID, func(m *Maintenance) error {
+		m.State = stateRejected
+		m.Idempotency.record(500)
+		return fmt.Errorf("nope")
+	})
+	if err == nil {
+		t.Errorf("expected the update error to be returned")
+	}
+	if got, _ := s.Get(m.ID); got.State != stateScheduled || got.Idempotency.Status != 0 {
+		t.Errorf("a failed update should change nothing: '%+v' '%+v'", got, got.Idempotency)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("unable to reload store: %s", err.Error())
+	}
+	got, ok := reloaded.Get(m.ID)
+	if !ok {
+		t.Fatalf("maintenance '%s' not found after reload", m.ID)
+	}
+	if got.State != stateScheduled || len(got.SilenceIDs) != 1 || got.Request.Comment != "patching" {
+		t.Errorf("unexpected maintenance after reload: '%+v'", got)
+	}
+	if len(reloaded.List()) != 1 {
+		t.Errorf("unexpected number of maintenances after reload: %d", len(reloaded.List()))
+	}
+}
+
+func TestNewID(t *testing.T) {
+	a, b := newID(), newID()
+	if a == b {
+		t.Errorf("IDs should be unique")
+	}
+	if len(a) != 36 {
+		t.Errorf("unexpected ID format: '%s'", a)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// MaintenanceTemplate a named standard maintenance, instantiated with a start time and overrides
+type MaintenanceTemplate struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Comment text/template rendered with the start and end of the first occurrence and the variables
+	Comment   string            `json:"comment"`
+	Matchers  []Matcher         `json:"matchers"`
+	Duration  string            `json:"duration"`
+	Repeat    Repeat            `json:"repeat"`
+	Ticket    string            `json:"ticket,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	CreatedBy string            `json:"createdBy,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// TemplateInstance the start of a maintenance created from a template, and what it changes from the template
+type TemplateInstance struct {
+	Start    time.Time `json:"start"`
+	Duration string    `json:"duration,omitempty"`
+	Repeat   *Repeat   `json:"repeat,omitempty"`
+	// Matchers replace the matchers of the template on the same label, and are added otherwise
+	Matchers      []Matcher         `json:"matchers,omitempty"`
+	Comment       string            `json:"comment,omitempty"`
+	Ticket        string            `json:"ticket,omitempty"`
+	Justification string            `json:"justification,omitempty"`
+	CreatedBy     string            `json:"createdBy,omitempty"`
+	Variables     map[string]string `json:"variables,omitempty"`
+}
+
+// templateData what the comment of a template is rendered with
+type templateData struct {
+	Start time.Time
+	End   time.Time
+	Vars  map[string]string
+}
+
+func parseComment(comment string) (*template.Template, error) {
+	return template.New("comment").Option("missingkey=error").Parse(comment)
+}
+
+func parseTemplateDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+	return d, nil
+}
+
+func (t MaintenanceTemplate) validate() error {
+	if !declaredNameReg.MatchString(t.Name) {
+		return fmt.Errorf("invalid template name '%s'", t.Name)
+	}
+	if t.Comment == "" {
+		return fmt.Errorf("comment field empty")
+	}
+	_, err := parseComment(t.Comment)
+	if err != nil {
+		return fmt.Errorf("invalid comment: %s", err.Error())
+	}
+	if len(t.Matchers) < 1 {
+		return fmt.Errorf("number of matchers should be bigger than 0")
+	}
+	for _, m := range t.Matchers {
+		if !m.Valid() {
+			return fmt.Errorf("matcher '%v' is invalid", m)
+		}
+	}
+	_, err = parseTemplateDuration(t.Duration)
+	if err != nil {
+		return err
+	}
+	if t.Repeat != (Repeat{}) {
+		msg, ok := t.Repeat.Valid()
+		if !ok {
+			return fmt.Errorf("%s", msg)
+		}
+	}
+	return nil
+}
+
+// instantiate returns the silence request of the template starting at the given time, the request
+// still has to be validated like any other one
+func (t MaintenanceTemplate) instantiate(i TemplateInstance) (APISilenceRequest, error) {
+	if i.Start.IsZero() {
+		return APISilenceRequest{}, fmt.Errorf("start field empty")
+	}
+
+	duration := t.Duration
+	if i.Duration != "" {
+		duration = i.Duration
+	}
+	d, err := parseTemplateDuration(duration)
+	if err != nil {
+		return APISilenceRequest{}, err
+	}
+	start := i.Start.UTC()
+	end := start.Add(d)
+
+	repeat := t.Repeat
+	if i.Repeat != nil {
+		repeat = *i.Repeat
+	}
+	if repeat.Count == 0 {
+		repeat.Count = 1
+	}
+
+	var matchers []Matcher
+	overridden := map[string]bool{}
+	for _, m := range i.Matchers {
+		overridden[m.Name] = true
+	}
+	for _, m := range t.Matchers {
+		if !overridden[m.Name] {
+			matchers = append(matchers, m)
+		}
+	}
+	matchers = append(matchers, i.Matchers...)
+
+	vars := map[string]string{}
+	for name, value := range t.Variables {
+		vars[name] = value
+	}
+	for name, value := range i.Variables {
+		vars[name] = value
+	}
+	comment := t.Comment
+	if i.Comment != "" {
+		comment = i.Comment
+	}
+	tmpl, err := parseComment(comment)
+	if err != nil {
+		return APISilenceRequest{}, fmt.Errorf("invalid comment: %s", err.Error())
+	}
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, templateData{Start: start, End: end, Vars: vars})
+	if err != nil {
+		return APISilenceRequest{}, fmt.Errorf("unable to render comment: %s", err.Error())
+	}
+
+	ticket := t.Ticket
+	if i.Ticket != "" {
+		ticket = i.Ticket
+	}
+	return APISilenceRequest{
+		Comment:       rendered.String(),
+		CreatedBy:     i.CreatedBy,
+		Matchers:      matchers,
+		Justification: i.Justification,
+		Ticket:        ticket,
+		Schedule: Schedule{
+			StartTime: start.Format(requestTimeLayout),
+			EndTime:   end.Format(requestTimeLayout),
+			Repeat:    repeat,
+		},
+	}, nil
+}
+
+// TemplateStore keeps maintenance templates in memory, and in a JSON file when a path is set
+type TemplateStore struct {
+	mu        sync.RWMutex
+	path      string
+	templates map[string]*MaintenanceTemplate
+}
+
+func newMemoryTemplateStore() *TemplateStore {
+	return &TemplateStore{templates: map[string]*MaintenanceTemplate{}}
+}
+
+// NewTemplateStore creates a template store persisted to path, loading the templates it already contains
+func NewTemplateStore(path string) (*TemplateStore, error) {
+	s := newMemoryTemplateStore()
+	s.path = path
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template store: %s", err.Error())
+	}
+
+	var list []*MaintenanceTemplate
+	err = json.Unmarshal(f, &list)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal template store: %s", err.Error())
+	}
+	for _, t := range list {
+		s.templates[t.Name] = t
+	}
+	return s, nil
+}
+
+// save writes all templates to the store file, the caller must hold the lock
+func (s *TemplateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal template store: %s", err.Error())
+	}
+
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to write template store: %s", err.Error())
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return fmt.Errorf("unable to write template store: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *TemplateStore) sorted() []MaintenanceTemplate {
+	list := make([]MaintenanceTemplate, 0, len(s.templates))
+	for _, t := range s.templates {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Add stores a new template
+func (s *TemplateStore) Add(t MaintenanceTemplate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.templates[t.Name]; ok {
+		return fmt.Errorf("template '%s' already exists", t.Name)
+	}
+	t.CreatedAt = time.Now().UTC()
+	t.UpdatedAt = t.CreatedAt
+	s.templates[t.Name] = &t
+	return s.save()
+}
+
+// Get returns the template with the given name
+func (s *TemplateStore) Get(name string) (MaintenanceTemplate, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.templates[name]
+	if !ok {
+		return MaintenanceTemplate{}, false
+	}
+	return *t, true
+}
+
+// List returns all templates, sorted by name
+func (s *TemplateStore) List() []MaintenanceTemplate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sorted()
+}
+
+// Replace replaces the definition of a template, keeping who created it and when
+func (s *TemplateStore) Replace(t MaintenanceTemplate) (MaintenanceTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.templates[t.Name]
+	if !ok {
+		return MaintenanceTemplate{}, fmt.Errorf("template '%s' not found", t.Name)
+	}
+	t.CreatedBy = current.CreatedBy
+	t.CreatedAt = current.CreatedAt
+	t.UpdatedAt = time.Now().UTC()
+	s.templates[t.Name] = &t
+	return t, s.save()
+}
+
+// Delete removes a template, maintenances created from it are kept
+func (s *TemplateStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.templates[name]; !ok {
+		return fmt.Errorf("template '%s' not found", name)
+	}
+	delete(s.templates, name)
+	return s.save()
+}
+
+// auditTemplate records a change of a template, before or after is empty on creation and deletion
+func (a *App) auditTemplate(r *http.Request, action string, before, after MaintenanceTemplate) {
+	e := AuditEntry{Action: action}
+	if before.Name != "" {
+		e.Before = auditState(before)
+		e.Details = fmt.Sprintf("template '%s'", before.Name)
+	}
+	if after.Name != "" {
+		e.After = auditState(after)
+		e.Details = fmt.Sprintf("template '%s'", after.Name)
+	}
+	a.audit(r, e)
+}
+
+// checkTemplateAccess checks the matchers of a template are within the caller scopes, like the ones
+// of a silence request
+func (a *App) checkTemplateAccess(r *http.Request, t MaintenanceTemplate) (string, bool) {
+	return a.accessFor(r).rule()(APISilenceRequest{Matchers: t.Matchers})
+}
+
+// canDeleteTemplate checks the caller created the template or is an admin
+func (a *App) canDeleteTemplate(r *http.Request, t MaintenanceTemplate) (string, bool) {
+	ac := a.accessFor(r)
+	if !ac.enabled || ac.admin {
+		return "", true
+	}
+	id, _ := identityFromContext(r.Context())
+	if id != nil && t.CreatedBy == id.Name {
+		return "", true
+	}
+	return fmt.Sprintf("template '%s' was created by someone else", t.Name), false
+}
+
+func (a *App) getTemplates(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.templateStore().List())
+}
+
+func (a *App) getTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	t, ok := a.templateStore().Get(name)
+	if !ok {
+		writeErrorWithCode(fmt.Sprintf("template '%s' not found", name), http.StatusNotFound, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+// readTemplate decodes and validates the template in the body of the request
+func readTemplate(r *http.Request) (MaintenanceTemplate, error) {
+	var t MaintenanceTemplate
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		return t, fmt.Errorf("unable to read template: %s", err.Error())
+	}
+	if name, ok := mux.Vars(r)["name"]; ok {
+		t.Name = name
+	}
+	err = t.validate()
+	if err != nil {
+		return t, fmt.Errorf("template is invalid: %s", err.Error())
+	}
+	return t, nil
+}
+
+func (a *App) createTemplate(w http.ResponseWriter, r *http.Request) {
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	t, err := readTemplate(r)
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+	t.CreatedBy = actor(r, "")
+	if msg, ok := a.checkTemplateAccess(r, t); !ok {
+		writeErrorWithCode(msg, http.StatusForbidden, w)
+		return
+	}
+
+	err = a.templateStore().Add(t)
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusConflict, w)
+		return
+	}
+	t, _ = a.templateStore().Get(t.Name)
+	a.auditTemplate(r, actionCreate, MaintenanceTemplate{}, t)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (a *App) updateTemplate(w http.ResponseWriter, r *http.Request) {
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	t, err := readTemplate(r)
+	if err != nil {
+		writeErrorWithCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+	before, ok := a.templateStore().Get(t.Name)
+	if !ok {
+		writeErrorWithCode(fmt.Sprintf("template '%s' not found", t.Name), http.StatusNotFound, w)
+		return
+	}
+	// the template must stay within the caller scopes, before and after the update
+	for _, tmpl := range []MaintenanceTemplate{before, t} {
+		if msg, ok := a.checkTemplateAccess(r, tmpl); !ok {
+			writeErrorWithCode(msg, http.StatusForbidden, w)
+			return
+		}
+	}
+
+	t, err = a.templateStore().Replace(t)
+	if err != nil {
+		writeError(fmt.Sprintf("unable to store template: %s", err.Error()), w)
+		return
+	}
+	a.auditTemplate(r, actionUpdate, before, t)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (a *App) deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	before, ok := a.templateStore().Get(name)
+	if !ok {
+		writeErrorWithCode(fmt.Sprintf("template '%s' not found", name), http.StatusNotFound, w)
+		return
+	}
+	if msg, ok := a.canDeleteTemplate(r, before); !ok {
+		writeErrorWithCode(msg, http.StatusForbidden, w)
+		return
+	}
+	err := a.templateStore().Delete(name)
+	if err != nil {
+		writeError(fmt.Sprintf("unable to delete template: %s", err.Error()), w)
+		return
+	}
+	a.auditTemplate(r, actionDelete, before, MaintenanceTemplate{})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(APIResponse{Status: "success", Message: fmt.Sprintf("deleted template '%s'", name)})
+}
+
+// instantiateTemplate creates a maintenance from a template, submitted like any silence request
+func (a *App) instantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	url, err := router.Get("indexHandler").URL()
+	if err != nil {
+		writeError("internal error: unable to find redirect page", w)
+		return
+	}
+
+	if !validCSRF(r) {
+		writeErrorWithCode("invalid CSRF token", http.StatusForbidden, w)
+		return
+	}
+
+	t, ok := a.templateStore().Get(name)
+	if !ok {
+		writeErrorWithCode(fmt.Sprintf("template '%s' not found", name), http.StatusNotFound, w)
+		return
+	}
+
+	var i TemplateInstance
+	err = json.NewDecoder(r.Body).Decode(&i)
+	if err != nil {
+		writeErrorWithCode(fmt.Sprintf("unable to read template instance: %s", err.Error()), http.StatusBadRequest, w)
+		return
+	}
+	request, err := t.instantiate(i)
+	if err != nil {
+		writeErrorWithCode(fmt.Sprintf("unable to instantiate template '%s': %s", name, err.Error()), http.StatusBadRequest, w)
+		return
+	}
+
+	a.submitMaintenance(w, r, url.String(), request)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var testTemplate = MaintenanceTemplate{
+	Name:      "db-patching",
+	Comment:   `DB patching {{ .Start.Format "January 2006" }} on {{ .Vars.env }}`,
+	Matchers:  []Matcher{{Name: "team", Value: "db"}, {Name: "env", Value: "staging"}},
+	Duration:  "2h",
+	Repeat:    Repeat{Interval: "d", Count: 2},
+	Ticket:    "CHG-STANDARD",
+	Variables: map[string]string{"env": "staging"},
+}
+
+func TestMaintenanceTemplate_validate(t *testing.T) {
+	var cases = []struct {
+		name  string
+		edit  func(t *MaintenanceTemplate)
+		valid bool
+	}{
+		{"valid", func(t *MaintenanceTemplate) {}, true},
+		{"no repeat", func(t *MaintenanceTemplate) { t.Repeat = Repeat{} }, true},
+		{"invalid name", func(t *MaintenanceTemplate) { t.Name = "db patching" }, false},
+		{"invalid comment", func(t *MaintenanceTemplate) { t.Comment = "{{ .Start" }, false},
+		{"no matchers", func(t *MaintenanceTemplate) { t.Matchers = nil }, false},
+		{"invalid duration", func(t *MaintenanceTemplate) { t.Duration = "-1h" }, false},
+		{"invalid repeat", func(t *MaintenanceTemplate) { t.Repeat.Interval = "y" }, false},
+	}
+
+	for _, c := range cases {
+		tmpl := testTemplate
+		c.edit(&tmpl)
+		if err := tmpl.validate(); (err == nil) != c.valid {
+			t.Errorf("%s: unexpected validation result: '%v'", c.name, err)
+		}
+	}
+}
+
+func TestMaintenanceTemplate_instantiate(t *testing.T) {
+	start := time.Date(2021, 10, 13, 2, 0, 0, 0, time.UTC)
+
+	r, err := testTemplate.instantiate(TemplateInstance{Start: start})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if r.Comment != "DB patching October 2021 on staging" || r.Ticket != "CHG-STANDARD" || r.Schedule.Repeat.Count != 2 {
+		t.Errorf("unexpected request: '%+v'", r)
+	}
+	if r.Schedule.EndTime != "2021-10-13T04:00:00.000Z" {
+		t.Errorf("wrong end: got '%s'", r.Schedule.EndTime)
+	}
+
+	r, err = testTemplate.instantiate(TemplateInstance{
+		Start:     start,
+		Duration:  "30m",
+		Repeat:    &Repeat{Interval: "w", Count: 1},
+		Matchers:  []Matcher{{Name: "env", Value: "prod"}, {Name: "instance", Value: "db-1"}},
+		Variables: map[string]string{"env": "prod"},
+		Ticket:    "CHG-1",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := []Matcher{{Name: "team", Value: "db"}, {Name: "env", Value: "prod"}, {Name: "instance", Value: "db-1"}}
+	if len(r.Matchers) != len(want) || r.Matchers[0] != want[0] || r.Matchers[1] != want[1] || r.Matchers[2] != want[2] {
+		t.Errorf("wrong matchers: got '%v' want '%v'", r.Matchers, want)
+	}
+	if r.Comment != "DB patching October 2021 on prod" || r.Ticket != "CHG-1" || r.Schedule.EndTime != "2021-10-13T02:30:00.000Z" {
+		t.Errorf("unexpected request: '%+v'", r)
+	}
+
+	// a variable without default must be given
+	tmpl := testTemplate
+	tmpl.Variables = nil
+	if _, err = tmpl.instantiate(TemplateInstance{Start: start}); err == nil {
+		t.Errorf("expected an error for a missing variable")
+	}
+	if _, err = testTemplate.instantiate(TemplateInstance{}); err == nil {
+		t.Errorf("expected an error for a missing start")
+	}
+}
+
+func TestTemplateStore_persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ams-templates")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "templates.json")
+
+	s, err := NewTemplateStore(path)
+	if err != nil {
+		t.Fatalf("unable to create store: %s", err.Error())
+	}
+	if err = s.Add(testTemplate); err != nil {
+		t.Fatalf("unable to add template: %s", err.Error())
+	}
+	if err = s.Add(testTemplate); err == nil {
+		t.Errorf("a template should not be added twice")
+	}
+	updated := testTemplate
+	updated.Duration = "3h"
+	if _, err = s.Replace(updated); err != nil {
+		t.Fatalf("unable to replace template: %s", err.Error())
+	}
+
+	reloaded, err := NewTemplateStore(path)
+	if err != nil {
+		t.Fatalf("unable to reload store: %s", err.Error())
+	}
+	got, ok := reloaded.Get(testTemplate.Name)
+	if !ok || got.Duration != "3h" || got.CreatedAt.IsZero() {
+		t.Errorf("unexpected reloaded template: '%+v'", got)
+	}
+
+	if err = reloaded.Delete(testTemplate.Name); err != nil || len(reloaded.List()) != 0 {
+		t.Errorf("unable to delete template: '%v'", err)
+	}
+}
+
+func TestApp_templates(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	am := newFakeAlertmanager(now)
+	app := &App{config: &Config{}, client: am}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	call := func(handler http.HandlerFunc, method, name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/templates", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = withIdentity(req, &Identity{Name: "carol", Token: true})
+		if name != "" {
+			req = mux.SetURLVars(req, map[string]string{"name": name})
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	b, _ := json.Marshal(testTemplate)
+	if rr := call(app.createTemplate, "POST", "", string(b)); rr.Code != http.StatusCreated {
+		t.Fatalf("unexpected create status: '%d' '%s'", rr.Code, rr.Body.String())
+	}
+	if rr := call(app.createTemplate, "POST", "", string(b)); rr.Code != http.StatusConflict {
+		t.Errorf("expected a conflict creating the template twice, got '%d'", rr.Code)
+	}
+	if rr := call(app.createTemplate, "POST", "", `{"name":"empty"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid template to be rejected, got '%d'", rr.Code)
+	}
+
+	edited := testTemplate
+	edited.Duration = "1h"
+	b, _ = json.Marshal(edited)
+	rr := call(app.updateTemplate, "PUT", testTemplate.Name, string(b))
+	var got MaintenanceTemplate
+	json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || got.Duration != "1h" || got.CreatedBy != "carol" {
+		t.Errorf("unexpected update: '%d' '%+v'", rr.Code, got)
+	}
+
+	start := now.Add(24 * time.Hour).Format(time.RFC3339)
+	rr = call(app.instantiateTemplate, "POST", testTemplate.Name, `{"start":"`+start+`","variables":{"env":"prod"}}`)
+	if rr.Code != http.StatusOK || len(am.active()) != 2 {
+		t.Fatalf("unexpected instantiation: '%d' '%s'", rr.Code, rr.Body.String())
+	}
+	list := app.maintenanceStore().List()
+	if len(list) != 1 || list[0].Request.CreatedBy != "carol" || !strings.HasSuffix(list[0].Request.Comment, "on prod") {
+		t.Errorf("unexpected maintenance: '%+v'", list)
+	}
+
+	// the result goes through the same validation as a silence request
+	rr = call(app.instantiateTemplate, "POST", testTemplate.Name, `{"start":"`+start+`","repeat":{"interval":"d","count":100}}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "repeat count") {
+		t.Errorf("expected the instance to be rejected, got '%d' '%s'", rr.Code, rr.Body.String())
+	}
+
+	if rr = call(app.deleteTemplate, "DELETE", testTemplate.Name, ""); rr.Code != http.StatusOK {
+		t.Errorf("unexpected delete status: '%d'", rr.Code)
+	}
+	if rr = call(app.instantiateTemplate, "POST", testTemplate.Name, `{"start":"`+start+`"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a deleted template to be not found, got '%d'", rr.Code)
+	}
+
+	var actions []string
+	for _, e := range app.auditLog().Query(AuditFilter{}) {
+		actions = append(actions, e.Action)
+	}
+	if got := strings.Join(actions, ","); got != "create,update,create,delete" {
+		t.Errorf("wrong audit actions: got '%s'", got)
+	}
+}
+
+func TestApp_templates_rbac(t *testing.T) {
+	app := &App{config: &Config{RBAC: testRBAC}}
+
+	call := func(handler http.HandlerFunc, id *Identity, name string, tmpl MaintenanceTemplate) int {
+		b, _ := json.Marshal(tmpl)
+		req := httptest.NewRequest("POST", "/api/v1/templates", strings.NewReader(string(b)))
+		req.Header.Set("Content-Type", "application/json")
+		req = withIdentity(req, id)
+		if name != "" {
+			req = mux.SetURLVars(req, map[string]string{"name": name})
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	bob := &Identity{Name: "bob", Token: true}
+	alice := &Identity{Name: "alice", Groups: []string{"payments-team"}, Token: true}
+	dave := &Identity{Name: "dave", Groups: []string{"payments-team"}, Token: true}
+	admin := &Identity{Name: "erin", Groups: []string{"sre"}, Token: true}
+
+	payments := testTemplate
+	payments.Name = "payments-patching"
+	payments.Matchers = []Matcher{{Name: "team", Value: "payments"}}
+	// the template of bob moved out of his scopes
+	moved := testTemplate
+	moved.Matchers = payments.Matchers
+
+	var steps = []struct {
+		name    string
+		handler http.HandlerFunc
+		id      *Identity
+		path    string
+		tmpl    MaintenanceTemplate
+		want    int
+	}{
+		{"create in scope", app.createTemplate, bob, "", testTemplate, http.StatusCreated},
+		{"create out of scope", app.createTemplate, bob, "", payments, http.StatusForbidden},
+		{"create for own team", app.createTemplate, alice, "", payments, http.StatusCreated},
+		{"update out of scope", app.updateTemplate, alice, testTemplate.Name, testTemplate, http.StatusForbidden},
+		{"update out of own scopes", app.updateTemplate, bob, testTemplate.Name, moved, http.StatusForbidden},
+		{"update in scope", app.updateTemplate, dave, payments.Name, payments, http.StatusOK},
+		{"delete by someone else", app.deleteTemplate, dave, payments.Name, MaintenanceTemplate{}, http.StatusForbidden},
+		{"delete by creator", app.deleteTemplate, alice, payments.Name, MaintenanceTemplate{}, http.StatusOK},
+		{"delete by admin", app.deleteTemplate, admin, testTemplate.Name, MaintenanceTemplate{}, http.StatusOK},
+	}
+
+	for _, s := range steps {
+		if got := call(s.handler, s.id, s.path, s.tmpl); got != s.want {
+			t.Errorf("%s: wrong status code: got '%d' want '%d'", s.name, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const defaultTicketTimeout = 10 * time.Second
+
+// TicketConfig how change tickets referenced by maintenances are validated
+type TicketConfig struct {
+	Required      bool          `yaml:"required"`
+	Format        string        `yaml:"format"`
+	ValidationURL string        `yaml:"validation_url"`
+	Timeout       time.Duration `yaml:"timeout"`
+
+	formatReg *regexp.Regexp
+}
+
+// TicketStatus response expected from the ticket validation hook
+type TicketStatus struct {
+	Approved bool   `json:"approved"`
+	Message  string `json:"message"`
+}
+
+func (c *TicketConfig) validate() error {
+	if c.Format != "" {
+		reg, err := regexp.Compile("^(?:" + c.Format + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid ticket format '%s': %s", c.Format, err.Error())
+		}
+		c.formatReg = reg
+	}
+
+	if c.ValidationURL != "" {
+		u, err := url.Parse(c.ValidationURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid ticket validation_url '%s'", c.ValidationURL)
+		}
+	}
+	return nil
+}
+
+// rule returns a validation rule checking the ticket reference is present and well formed
+func (c TicketConfig) rule() ValidationRule {
+	return func(r APISilenceRequest) (string, bool) {
+		if r.Ticket == "" {
+			if c.Required {
+				return "ticket field empty", false
+			}
+			return "", true
+		}
+
+		if c.formatReg != nil && !c.formatReg.MatchString(r.Ticket) {
+			return fmt.Sprintf("ticket '%s' does not match the expected format '%s'", r.Ticket, c.Format), false
+		}
+		return "", true
+	}
+}
+
+// check asks the validation hook whether the ticket exists and is approved, it returns a message
+// if the ticket is refused and an error if the hook could not be queried
+func (c TicketConfig) check(ticket string) (string, bool, error) {
+	if c.ValidationURL == "" || ticket == "" {
+		return "", true, nil
+	}
+
+	u, err := url.Parse(c.ValidationURL)
+	if err != nil {
+		return "", false, err
+	}
+	q := u.Query()
+	q.Set("ticket", ticket)
+	u.RawQuery = q.Encode()
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTicketTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return "", false, fmt.Errorf("unable to validate ticket '%s': %s", ticket, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Sprintf("ticket '%s' does not exist", ticket), false, nil
+	}
+	if resp.StatusCode >= 400 {
+		return "", false, fmt.Errorf("unable to validate ticket '%s': hook returned an HTTP error code: %d", ticket, resp.StatusCode)
+	}
+
+	var status TicketStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to validate ticket '%s': unable to unmarshal body: %s", ticket, err.Error())
+	}
+
+	if !status.Approved {
+		msg := fmt.Sprintf("ticket '%s' is not approved", ticket)
+		if status.Message != "" {
+			msg = fmt.Sprintf("%s: %s", msg, status.Message)
+		}
+		return msg, false, nil
+	}
+	return "", true, nil
+}
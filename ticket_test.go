@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeTicketSystem serves the ticket validation hook for a fixed set of tickets
+func newFakeTicketSystem(tickets map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := tickets[r.URL.Query().Get("ticket")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+}
+
+func TestTicketConfig_rule(t *testing.T) {
+	conf := TicketConfig{Required: true, Format: `CHG\d{6}`}
+	err := conf.validate()
+	if err != nil {
+		t.Fatalf("unable to validate ticket config: %s", err.Error())
+	}
+
+	var cases = []struct {
+		ticket string
+		want   bool
+	}{
+		{"CHG000123", validationSuccess},
+		{"", validationError},
+		{"CHG123", validationError},
+		{"xCHG000123", validationError},
+	}
+
+	for _, c := range cases {
+		_, got := conf.rule()(APISilenceRequest{Ticket: c.ticket})
+		if got != c.want {
+			t.Errorf("ticket validation not returning expected result for => '%s'", c.ticket)
+		}
+	}
+}
+
+func TestTicketConfig_check(t *testing.T) {
+	ts := newFakeTicketSystem(map[string]string{
+		"CHG000001": `{"approved": true}`,
+		"CHG000002": `{"approved": false, "message": "awaiting CAB"}`,
+		"CHG000003": `not json`,
+	})
+	defer ts.Close()
+
+	conf := TicketConfig{ValidationURL: ts.URL + "/validate"}
+
+	var cases = []struct {
+		ticket  string
+		wantMsg string
+		wantOK  bool
+		wantErr bool
+	}{
+		{"CHG000001", "", true, false},
+		{"CHG000002", "ticket 'CHG000002' is not approved: awaiting CAB", false, false},
+		{"CHG000404", "ticket 'CHG000404' does not exist", false, false},
+		{"CHG000003", "", false, true},
+	}
+
+	for _, c := range cases {
+		msg, ok, err := conf.check(c.ticket)
+		if msg != c.wantMsg || ok != c.wantOK || (err != nil) != c.wantErr {
+			t.Errorf("unexpected ticket check result for '%s'\ngot: '%s' '%v' '%v'\nwant: '%s' '%v' error: %v",
+				c.ticket, msg, ok, err, c.wantMsg, c.wantOK, c.wantErr)
+		}
+	}
+
+	// the hook is not called without a configured URL
+	_, ok, err := TicketConfig{}.check("CHG000404")
+	if !ok || err != nil {
+		t.Errorf("ticket check without hook should succeed")
+	}
+}
+
+func TestConstructSilence_ticket(t *testing.T) {
+	request := APISilenceRequest{
+		Comment:   "db patching",
+		CreatedBy: "alice",
+		Ticket:    "CHG000001",
+		Matchers:  []Matcher{{Name: "job", Value: "db"}},
+	}
+
+	silence, err := constructSilence("2019-11-01T22:12:33.533Z", "2019-11-01T23:11:44.603Z", request)
+	if err != nil {
+		t.Fatalf("unable to construct silence: %s", err.Error())
+	}
+	if *silence.Comment != "[CHG000001] db patching" {
+		t.Errorf("unexpected silence comment: '%s'", *silence.Comment)
+	}
+}
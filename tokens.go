@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	scopeRead   = "read"
+	scopeCreate = "create"
+	scopeExpire = "expire"
+)
+
+var (
+	tokenHashReg = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	tokenScopes  = map[string]bool{scopeRead: true, scopeCreate: true, scopeExpire: true}
+)
+
+// APIToken a named token allowing automation clients to use the API, only its SHA-256 hash is stored
+type APIToken struct {
+	Name        string    `yaml:"name"`
+	TokenSHA256 string    `yaml:"token_sha256"`
+	Expires     time.Time `yaml:"expires"`
+	Scopes      []string  `yaml:"scopes"`
+}
+
+func (t APIToken) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("api token name is mandatory")
+	}
+	if !tokenHashReg.MatchString(t.TokenSHA256) {
+		return fmt.Errorf("api token '%s': token_sha256 must be a lowercase hex encoded SHA-256 hash", t.Name)
+	}
+	if len(t.Scopes) == 0 {
+		return fmt.Errorf("api token '%s': at least one scope is required", t.Name)
+	}
+	for _, s := range t.Scopes {
+		if !tokenScopes[s] {
+			return fmt.Errorf("api token '%s': unknown scope '%s'", t.Name, s)
+		}
+	}
+	return nil
+}
+
+func (t APIToken) expired(now time.Time) bool {
+	return !t.Expires.IsZero() && now.After(t.Expires)
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// findToken returns the configured token matching the raw bearer token
+func (c *Config) findToken(raw string) (APIToken, bool) {
+	hash := []byte(hashToken(raw))
+	for _, t := range c.APITokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.TokenSHA256)) == 1 {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, "Bearer ")), true
+}
+
+// queryToken returns the API token passed as the "token" query parameter of the calendar feed, calendar
+// applications subscribe to a URL and cannot send headers. No other route accepts it.
+func queryToken(r *http.Request) (string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil || route.GetName() != "getCalendar" {
+		return "", false
+	}
+	raw := r.URL.Query().Get("token")
+	return raw, raw != ""
+}
+
+// readOnly returns true if the identity is a token restricted to the read scope
+func (id *Identity) readOnly() bool {
+	if !id.Token || len(id.Scopes) == 0 {
+		return false
+	}
+	for _, s := range id.Scopes {
+		if s != scopeRead {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenIdentity authenticates a request carrying a bearer token
+func (a *App) tokenIdentity(raw string) (*Identity, error) {
+	t, ok := a.conf().findToken(raw)
+	if !ok {
+		return nil, fmt.Errorf("invalid api token")
+	}
+	if t.expired(time.Now()) {
+		return nil, fmt.Errorf("api token '%s' has expired", t.Name)
+	}
+	return &Identity{Name: t.Name, Token: true, Scopes: t.Scopes}, nil
+}
+
+// can returns true if the identity is allowed to perform actions of the given scope,
+// scopes only restrict API tokens
+func (id *Identity) can(scope string) bool {
+	if !id.Token {
+		return true
+	}
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requireScope rejects requests authenticated with a token lacking the given scope
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := identityFromContext(r.Context())
+		if ok && !id.can(scope) {
+			msg := fmt.Sprintf("api token '%s' lacks the '%s' scope", id.Name, scope)
+			writeErrorWithCode(msg, http.StatusForbidden, w)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAPIToken_validate(t *testing.T) {
+	hash := hashToken("secret")
+	var cases = []struct {
+		token APIToken
+		want  bool
+	}{
+		// happy path
+		{APIToken{Name: "ci", TokenSHA256: hash, Scopes: []string{scopeRead, scopeCreate}}, validationSuccess},
+
+		// missing name
+		{APIToken{TokenSHA256: hash, Scopes: []string{scopeRead}}, validationError},
+
+		// clear text token instead of hash
+		{APIToken{Name: "ci", TokenSHA256: "secret", Scopes: []string{scopeRead}}, validationError},
+
+		// no scope
+		{APIToken{Name: "ci", TokenSHA256: hash}, validationError},
+
+		// unknown scope
+		{APIToken{Name: "ci", TokenSHA256: hash, Scopes: []string{"admin"}}, validationError},
+	}
+
+	for _, c := range cases {
+		err := c.token.validate()
+		if got := err == nil; got != c.want {
+			t.Errorf("APIToken validation not returning expected result for => '%v': %v\n", c.token, err)
+		}
+	}
+}
+
+func TestApp_requireAuth_tokens(t *testing.T) {
+	app := &App{config: &Config{APITokens: []APIToken{
+		{Name: "ci", TokenSHA256: hashToken("valid"), Scopes: []string{scopeRead}},
+		{Name: "old", TokenSHA256: hashToken("expired"), Scopes: []string{scopeRead}, Expires: time.Now().Add(-time.Hour)},
+	}}}
+
+	var got *Identity
+	handler := app.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = identityFromContext(r.Context())
+	}))
+
+	var cases = []struct {
+		token    string
+		wantCode int
+		wantName string
+	}{
+		{"valid", http.StatusOK, "ci"},
+		{"expired", http.StatusUnauthorized, ""},
+		{"unknown", http.StatusUnauthorized, ""},
+	}
+
+	for _, c := range cases {
+		got = nil
+		req := httptest.NewRequest("GET", "/api/v1/silences", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != c.wantCode {
+			t.Errorf("wrong status code for token '%s': got '%d' want '%d'", c.token, rr.Code, c.wantCode)
+		}
+		if c.wantName != "" && (got == nil || got.Name != c.wantName || !got.Token) {
+			t.Errorf("unexpected identity for token '%s': '%+v'", c.token, got)
+		}
+	}
+}
+
+func TestApp_requireAuth_queryToken(t *testing.T) {
+	app := &App{config: &Config{APITokens: []APIToken{
+		{Name: "calendar", TokenSHA256: hashToken("read"), Scopes: []string{scopeRead}},
+		{Name: "ci", TokenSHA256: hashToken("create"), Scopes: []string{scopeRead, scopeCreate}},
+	}}, auth: &authenticator{}}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router := mux.NewRouter()
+	s := router.PathPrefix("/api/v1").Subrouter()
+	s.HandleFunc("/calendar.ics", requireScope(scopeRead, ok)).Methods("GET").Name("getCalendar")
+	s.HandleFunc("/silences", requireScope(scopeRead, ok)).Methods("GET").Name("getSilences")
+	s.Use(app.requireAuth)
+
+	var cases = []struct {
+		url  string
+		want int
+	}{
+		{"/api/v1/calendar.ics?token=read", http.StatusOK},
+		{"/api/v1/calendar.ics?token=unknown", http.StatusUnauthorized},
+		// a token which can do more than reading is not to be exposed in a URL
+		{"/api/v1/calendar.ics?token=create", http.StatusForbidden},
+		{"/api/v1/calendar.ics", http.StatusUnauthorized},
+		// only the calendar feed accepts it
+		{"/api/v1/silences?token=read", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", c.url, nil))
+		if rr.Code != c.want {
+			t.Errorf("wrong status code for '%s': got '%d' want '%d'", c.url, rr.Code, c.want)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := requireScope(scopeCreate, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var cases = []struct {
+		id   *Identity
+		want int
+	}{
+		{nil, http.StatusOK},
+		{&Identity{Name: "alice"}, http.StatusOK},
+		{&Identity{Name: "ci", Token: true, Scopes: []string{scopeCreate}}, http.StatusOK},
+		{&Identity{Name: "ci", Token: true, Scopes: []string{scopeRead}}, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/api/v1/silence", nil)
+		if c.id != nil {
+			req = withIdentity(req, c.id)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != c.want {
+			t.Errorf("wrong status code for identity '%+v': got '%d' want '%d'", c.id, rr.Code, c.want)
+		}
+	}
+}
+
+func TestApp_createSilence_token(t *testing.T) {
+	client := MockAlertManagerClient{}
+	client.On("CreateSilenceWith",
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string"),
+		mock.MatchedBy(func(r APISilenceRequest) bool { return r.CreatedBy == "ci" })).Return("1234", nil)
+	client.On("ListSilences").Return(models.GettableSilences{}, nil)
+	app := &App{
+		config: &Config{APITokens: []APIToken{
+			{Name: "ci", TokenSHA256: hashToken("valid"), Scopes: []string{scopeCreate}},
+		}},
+		client: &client,
+	}
+
+	router = mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/", indexHandler).Name("indexHandler")
+
+	body := `{
+  "comment": "deploy",
+  "matchers": [{"name": "job", "value": "MockApp", "isRegex": false}],
+  "schedule": {
+    "start_time": "2020-11-01T22:12:33.533Z",
+    "end_time": "2020-11-01T23:11:44.603Z",
+    "repeat": {"interval": "h", "count": 2}
+  }
+}`
+	req := httptest.NewRequest("POST", "/api/v1/silence", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer valid")
+
+	rr := httptest.NewRecorder()
+	app.requireAuth(requireScope(scopeCreate, app.createSilence)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("wrong status code: got '%d' want '%d', body: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	expected := `{"status": "success", "message": "2/2 new silences created"}`
+	if ok, err := AreEqualJSON(rr.Body.String(), expected); !ok || err != nil {
+		t.Errorf("handler returned unexpected body\ngot: '%s'\nwant: '%s'\nerror: '%v'", rr.Body.String(), expected, err)
+	}
+	client.AssertNumberOfCalls(t, "CreateSilenceWith", 2)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	windowActiveName    = "maintenance_window_active"
+	windowNextStartName = "maintenance_window_next_start_timestamp_seconds"
+	windowIDLabel       = "maintenance_id"
+)
+
+var windowLabelReg = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// maintenanceWindow the current state of the occurrences of a scheduled maintenance
+type maintenanceWindow struct {
+	MaintenanceID string
+	Labels        map[string]string
+	Active        bool
+	NextStart     time.Time
+}
+
+// windowLabels returns the labels of the equality matchers of the request, the ones which cannot
+// be used as Prometheus label names are left out
+func windowLabels(r APISilenceRequest) map[string]string {
+	labels := map[string]string{}
+	for _, m := range r.Matchers {
+		if m.IsRegex || m.Name == windowIDLabel || !windowLabelReg.MatchString(m.Name) || m.Name[:1] == "_" {
+			continue
+		}
+		if _, ok := labels[m.Name]; !ok {
+			labels[m.Name] = m.Value
+		}
+	}
+	return labels
+}
+
+// windowOf returns the state of the maintenance at the given time, false once its last occurrence is over
+func windowOf(m Maintenance, now time.Time) (maintenanceWindow, bool, error) {
+	occurrences, err := m.Request.Schedule.occurrences()
+	if err != nil {
+		return maintenanceWindow{}, false, err
+	}
+	_, cancelled := m.expiredOnPurpose()
+
+	w := maintenanceWindow{MaintenanceID: m.ID, Labels: windowLabels(m.Request)}
+	for _, o := range occurrences {
+		if cancelled[o.End] {
+			continue
+		}
+		start, err := time.Parse(requestTimeLayout, o.Start)
+		if err != nil {
+			return w, false, err
+		}
+		end, err := time.Parse(requestTimeLayout, o.End)
+		if err != nil {
+			return w, false, err
+		}
+
+		if !start.After(now) && end.After(now) {
+			w.Active = true
+		}
+		if start.After(now) && (w.NextStart.IsZero() || start.Before(w.NextStart)) {
+			w.NextStart = start
+		}
+	}
+	return w, w.Active || !w.NextStart.IsZero(), nil
+}
+
+// setManagedWindows replaces the maintenances managed by a reconciler which are exposed as windows
+func (a *App) setManagedWindows(reconciler string, requests map[string]APISilenceRequest) {
+	a.managedMu.Lock()
+	defer a.managedMu.Unlock()
+	if a.managedWindows == nil {
+		a.managedWindows = map[string]map[string]APISilenceRequest{}
+	}
+	a.managedWindows[reconciler] = requests
+}
+
+// managedReported returns true once the reconciler reported its valid maintenances, even if it is disabled
+func (a *App) managedReported(reconciler string) bool {
+	a.managedMu.Lock()
+	defer a.managedMu.Unlock()
+	_, ok := a.managedWindows[reconciler]
+	return ok
+}
+
+// windowMaintenances returns the scheduled maintenances of the store along with the ones managed by
+// the declarative and Kubernetes reconcilers, identified by their managed name
+func (a *App) windowMaintenances() []Maintenance {
+	var out []Maintenance
+	for _, m := range a.maintenanceStore().List() {
+		if m.State == stateScheduled {
+			out = append(out, m)
+		}
+	}
+
+	a.managedMu.Lock()
+	defer a.managedMu.Unlock()
+	var names []string
+	requests := map[string]APISilenceRequest{}
+	for _, list := range a.managedWindows {
+		for name, r := range list {
+			names = append(names, name)
+			requests[name] = r
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out = append(out, Maintenance{ID: name, State: stateScheduled, Request: requests[name]})
+	}
+	return out
+}
+
+// windowCollector exposes the windows of the scheduled and managed maintenances, labelled with their matchers so
+// alerting rules can be joined with them
+type windowCollector struct {
+	app *App
+	now func() time.Time
+}
+
+// Describe sends no descriptor: the label names depend on the matchers of the maintenances,
+// which makes the collector unchecked
+func (c *windowCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect sends a series per scheduled maintenance which is not over, with the union of the labels
+// of every maintenance so all series of a metric have the same label names
+func (c *windowCollector) Collect(ch chan<- prometheus.Metric) {
+	now := c.now()
+	var windows []maintenanceWindow
+	names := map[string]bool{}
+	for _, m := range c.app.windowMaintenances() {
+		w, ok, err := windowOf(m, now)
+		if err != nil || !ok {
+			continue
+		}
+		windows = append(windows, w)
+		for name := range w.Labels {
+			names[name] = true
+		}
+	}
+
+	labelNames := []string{windowIDLabel}
+	for name := range names {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames[1:])
+
+	active := prometheus.NewDesc(windowActiveName,
+		"Whether an occurrence of the maintenance is in progress (1) or not (0).", labelNames, nil)
+	nextStart := prometheus.NewDesc(windowNextStartName,
+		"Start of the next occurrence of the maintenance, as a Unix timestamp.", labelNames, nil)
+
+	for _, w := range windows {
+		values := []string{w.MaintenanceID}
+		for _, name := range labelNames[1:] {
+			values = append(values, w.Labels[name])
+		}
+
+		value := 0.0
+		if w.Active {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(active, prometheus.GaugeValue, value, values...)
+		if !w.NextStart.IsZero() {
+			ch <- prometheus.MustNewConstMetric(nextStart, prometheus.GaugeValue, float64(w.NextStart.Unix()), values...)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWindowLabels(t *testing.T) {
+	r := APISilenceRequest{Matchers: []Matcher{
+		{Name: "instance", Value: "db-1"},
+		{Name: "env", Value: "prod|staging", IsRegex: true},
+		{Name: "maintenance_id", Value: "x"},
+		{Name: "__name__", Value: "up"},
+		{Name: "instance", Value: "db-2"},
+	}}
+
+	got := windowLabels(r)
+	if len(got) != 1 || got["instance"] != "db-1" {
+		t.Errorf("unexpected labels: '%v'", got)
+	}
+}
+
+func TestWindowCollector(t *testing.T) {
+	now := time.Date(2021, 10, 12, 12, 0, 0, 0, time.UTC)
+	app := App{config: &Config{}}
+
+	add := func(id string, start time.Time, count int, matchers ...Matcher) {
+		err := app.maintenanceStore().Add(Maintenance{ID: id, State: stateScheduled, Request: APISilenceRequest{
+			Comment:   "patching",
+			CreatedBy: "alice",
+			Matchers:  matchers,
+			Schedule: Schedule{
+				StartTime: start.Format(requestTimeLayout),
+				EndTime:   start.Add(2 * time.Hour).Format(requestTimeLayout),
+				Repeat:    Repeat{Interval: "d", Count: count},
+			},
+		}})
+		if err != nil {
+			t.Fatalf("unable to store maintenance: %s", err.Error())
+		}
+	}
+	// in progress, with another occurrence tomorrow
+	add("db", now.Add(-time.Hour), 2, Matcher{Name: "instance", Value: "db-1"})
+	// upcoming
+	add("net", now.Add(3*time.Hour), 1, Matcher{Name: "team", Value: "net"}, Matcher{Name: "site", Value: "mtl|tor", IsRegex: true})
+	// over
+	add("old", now.Add(-72*time.Hour), 2, Matcher{Name: "job", Value: "backup"})
+	// managed by the declarative reconciler, in progress
+	app.setManagedWindows(declarativeActor, map[string]APISilenceRequest{"nightly": {
+		Comment:  "backups",
+		Matchers: []Matcher{{Name: "instance", Value: "db-2"}},
+		Managed:  "nightly",
+		Schedule: Schedule{
+			StartTime: now.Add(-30 * time.Minute).Format(requestTimeLayout),
+			EndTime:   now.Add(30 * time.Minute).Format(requestTimeLayout),
+			Repeat:    Repeat{Interval: "d", Count: 1},
+		},
+	}})
+
+	expected := fmt.Sprintf(`
+# HELP maintenance_window_active Whether an occurrence of the maintenance is in progress (1) or not (0).
+# TYPE maintenance_window_active gauge
+maintenance_window_active{instance="db-1",maintenance_id="db",team=""} 1
+maintenance_window_active{instance="",maintenance_id="net",team="net"} 0
+maintenance_window_active{instance="db-2",maintenance_id="nightly",team=""} 1
+# HELP maintenance_window_next_start_timestamp_seconds Start of the next occurrence of the maintenance, as a Unix timestamp.
+# TYPE maintenance_window_next_start_timestamp_seconds gauge
+maintenance_window_next_start_timestamp_seconds{instance="db-1",maintenance_id="db",team=""} %d
+maintenance_window_next_start_timestamp_seconds{instance="",maintenance_id="net",team="net"} %d
+`, now.Add(23*time.Hour).Unix(), now.Add(3*time.Hour).Unix())
+
+	c := &windowCollector{app: &app, now: func() time.Time { return now }}
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), windowActiveName, windowNextStartName)
+	if err != nil {
+		t.Error(err)
+	}
+}